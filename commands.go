@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/app"
+)
+
+const usage = `usage:
+  gochat                             run the server
+  gochat retention report            show what retention would remove now
+  gochat retention run               apply retention policies once
+  gochat retention archives [room]   list message archives
+  gochat retention restore <id>      restore the messages of an archive`
+
+// runCommand runs a maintenance command instead of the server
+func runCommand(app *app.Application, args []string) error {
+	if len(args) < 2 || args[0] != "retention" {
+		return errors.New(usage)
+	}
+
+	ctx := context.Background()
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer out.Flush()
+
+	switch args[1] {
+	case "report":
+		reports, err := app.Retention.Report(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "ROOM\tDAYS\tACTION\tCUTOFF\tMESSAGES\tOLDEST")
+		for _, report := range reports {
+			oldest := "-"
+			if report.Oldest != nil {
+				oldest = report.Oldest.Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%s\t%d\t%s\t%s\t%d\t%s\n", report.RoomID, report.Policy.Days,
+				report.Policy.Action, report.Cutoff.Format(time.RFC3339), report.Messages, oldest)
+		}
+
+	case "run":
+		removed, err := app.Retention.Run(ctx)
+		fmt.Fprintf(out, "%d messages removed\n", removed)
+		return err
+
+	case "archives":
+		roomID := ""
+		if len(args) > 2 {
+			roomID = args[2]
+		}
+		archives, err := app.RetentionStore.GetArchives(ctx, roomID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "ID\tROOM\tMESSAGES\tOLDEST\tNEWEST\tRESTORED")
+		for _, archive := range archives {
+			restored := "-"
+			if archive.RestoredAt != nil {
+				restored = archive.RestoredAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%s\t%s\t%d\t%s\t%s\t%s\n", archive.ID, archive.RoomID, archive.MessageCount,
+				archive.Oldest.Format(time.RFC3339), archive.Newest.Format(time.RFC3339), restored)
+		}
+
+	case "restore":
+		if len(args) < 3 {
+			return errors.New(usage)
+		}
+		restored, err := app.Retention.Restore(ctx, args[2])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d messages restored\n", restored)
+
+	default:
+		return errors.New(usage)
+	}
+
+	return nil
+}
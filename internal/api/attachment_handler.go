@@ -0,0 +1,347 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/kaczmarekdaniel/gochat/internal/blob"
+	"github.com/kaczmarekdaniel/gochat/internal/media"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+const (
+	// Largest file that can be uploaded
+	MaxAttachmentSize = 25 << 20
+
+	// How long a signed download URL stays valid
+	downloadURLTTL = 15 * time.Minute
+)
+
+// Content types accepted for upload, as detected from the file itself rather
+// than trusted from the client
+var allowedAttachmentTypes = map[string]bool{
+	"image/jpeg":         true,
+	"image/png":          true,
+	"image/gif":          true,
+	"image/webp":         true,
+	"application/pdf":    true,
+	"application/zip":    true,
+	"text/plain":         true,
+	"audio/mpeg":         true,
+	"audio/wave":         true,
+	"video/mp4":          true,
+	"video/webm":         true,
+	"application/ogg":    true,
+	"application/x-gzip": true,
+}
+
+type AttachmentHandler struct {
+	attachmentStore store.AttachmentStore
+	roomStore       store.RoomStore
+	blobStore       blob.BlobStore
+	signingKey      []byte
+}
+
+func NewAttachmentHandler(attachmentStore store.AttachmentStore, roomStore store.RoomStore, blobStore blob.BlobStore, signingKey []byte) *AttachmentHandler {
+	return &AttachmentHandler{
+		attachmentStore: attachmentStore,
+		roomStore:       roomStore,
+		blobStore:       blobStore,
+		signingKey:      signingKey,
+	}
+}
+
+// HandleUpload streams the request body into the blob store as a new
+// attachment of a room. The file name comes from the filename parameter and
+// the request must carry a Content-Length.
+func (ah *AttachmentHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID := r.PathValue("id")
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if r.ContentLength <= 0 {
+		http.Error(w, "Content-Length is required", http.StatusLengthRequired)
+		return
+	}
+	if r.ContentLength > MaxAttachmentSize {
+		http.Error(w, "File is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ctx := context.Background()
+	if !authorize(w, ctx, ah.roomStore, userID, roomID, store.PermPost, "You are not allowed to post in this room") {
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, r.ContentLength)
+	contentType, reader, err := media.Sniff(body)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	if !allowedAttachmentTypes[contentType] {
+		http.Error(w, "File type is not allowed", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	attachment := &store.Attachment{
+		ID:          uuid.New().String(),
+		RoomID:      roomID,
+		UploaderID:  userID,
+		Filename:    cleanFilename(r.URL.Query().Get("filename")),
+		ContentType: contentType,
+		Size:        r.ContentLength,
+	}
+	attachment.BlobKey = "attachments/" + roomID + "/" + attachment.ID
+
+	// Images are kept in memory on the way through so a thumbnail can be
+	// made without reading the blob back
+	var imageData bytes.Buffer
+	if media.CanThumbnail(contentType) {
+		reader = io.TeeReader(reader, &imageData)
+	}
+
+	err = ah.blobStore.Put(ctx, attachment.BlobKey, reader, attachment.Size, contentType)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+
+	if imageData.Len() > 0 {
+		ah.storeThumbnail(ctx, attachment, imageData.Bytes())
+	}
+
+	created, err := ah.attachmentStore.CreateAttachment(ctx, attachment)
+	if err != nil {
+		fmt.Println(err)
+		ah.deleteBlobs(ctx, attachment)
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// storeThumbnail generates and stores a preview of an image. An image that
+// can't be previewed is still a valid attachment, so failures only log.
+func (ah *AttachmentHandler) storeThumbnail(ctx context.Context, attachment *store.Attachment, data []byte) {
+	thumbnail, err := media.MakeThumbnail(data)
+	if err != nil {
+		fmt.Println("thumbnail:", err)
+		return
+	}
+
+	key := attachment.BlobKey + "_thumb"
+	err = ah.blobStore.Put(ctx, key, bytes.NewReader(thumbnail.Data), int64(len(thumbnail.Data)), "image/jpeg")
+	if err != nil {
+		fmt.Println("thumbnail:", err)
+		return
+	}
+
+	attachment.ThumbnailKey = key
+	attachment.Width = thumbnail.Width
+	attachment.Height = thumbnail.Height
+}
+
+func (ah *AttachmentHandler) deleteBlobs(ctx context.Context, attachment *store.Attachment) {
+	for _, key := range []string{attachment.BlobKey, attachment.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := ah.blobStore.Delete(ctx, key); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// HandleGetAttachment returns an attachment's metadata with short-lived
+// signed URLs the caller can download it from
+func (ah *AttachmentHandler) HandleGetAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	attachment, ok := ah.getMemberAttachment(ctx, w, userID, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	expires := time.Now().Add(downloadURLTTL)
+	response := map[string]any{
+		"attachment": attachment,
+		"url":        ah.signedURL(attachment.ID, userID, "original", expires),
+		"expires_at": expires.UTC().Format(time.RFC3339),
+	}
+	if attachment.HasThumbnail {
+		response["thumbnail_url"] = ah.signedURL(attachment.ID, userID, "thumbnail", expires)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleDownload serves an attachment to the holder of a valid signed URL.
+// Membership is checked again, so leaving a room revokes outstanding links.
+func (ah *AttachmentHandler) HandleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	attachmentID := r.PathValue("id")
+	userID := params.Get("user_id")
+	variant := params.Get("variant")
+
+	expiresUnix, err := strconv.ParseInt(params.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expiresUnix {
+		http.Error(w, "Download link has expired", http.StatusForbidden)
+		return
+	}
+
+	expected := ah.signature(attachmentID, userID, variant, expiresUnix)
+	if !hmac.Equal([]byte(expected), []byte(params.Get("sig"))) {
+		http.Error(w, "Invalid download link", http.StatusForbidden)
+		return
+	}
+
+	ctx := context.Background()
+	attachment, ok := ah.getMemberAttachment(ctx, w, userID, attachmentID)
+	if !ok {
+		return
+	}
+
+	key, contentType, filename := attachment.BlobKey, attachment.ContentType, attachment.Filename
+	if variant == "thumbnail" {
+		if !attachment.HasThumbnail {
+			http.Error(w, "Attachment has no thumbnail", http.StatusNotFound)
+			return
+		}
+		key, contentType, filename = attachment.ThumbnailKey, "image/jpeg", "thumbnail.jpg"
+	}
+
+	reader, err := ah.blobStore.Get(ctx, key)
+	if errors.Is(err, blob.ErrNotFound) {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve attachment", http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	// Only images are shown inline; everything else is a download, and the
+	// browser must never second-guess the type
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, reader)
+}
+
+// getMemberAttachment loads an attachment for a user, answering with 404
+// when it does not exist and 403 when the user is not in its room
+func (ah *AttachmentHandler) getMemberAttachment(ctx context.Context, w http.ResponseWriter, userID, attachmentID string) (*store.Attachment, bool) {
+	attachment, err := ah.attachmentStore.GetAttachment(ctx, attachmentID)
+	if errors.Is(err, store.ErrAttachmentNotFound) {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve attachment", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	isInRoom, err := ah.roomStore.IsUserInRoom(ctx, userID, attachment.RoomID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve attachment", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !isInRoom {
+		http.Error(w, "You are not a member of this room", http.StatusForbidden)
+		return nil, false
+	}
+
+	return attachment, true
+}
+
+// signedURL builds a download link bound to one user, one variant of the
+// file and an expiry time
+func (ah *AttachmentHandler) signedURL(attachmentID, userID, variant string, expires time.Time) string {
+	params := url.Values{}
+	params.Set("user_id", userID)
+	params.Set("variant", variant)
+	params.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	params.Set("sig", ah.signature(attachmentID, userID, variant, expires.Unix()))
+
+	return "/attachments/" + attachmentID + "/download?" + params.Encode()
+}
+
+func (ah *AttachmentHandler) signature(attachmentID, userID, variant string, expires int64) string {
+	mac := hmac.New(sha256.New, ah.signingKey)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d", attachmentID, userID, variant, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// cleanFilename keeps the last path element of a client supplied file name.
+// Postgres refuses invalid UTF-8, so long names are cut between runes.
+func cleanFilename(name string) string {
+	name = strings.ToValidUTF8(name, "")
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+	if len(name) > 255 {
+		cut := 255
+		for !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut]
+	}
+	return name
+}
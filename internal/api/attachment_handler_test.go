@@ -0,0 +1,245 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kaczmarekdaniel/gochat/internal/blob"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// fakeRoomStore lets members of its rooms do anything. Methods the tests
+// don't reach are left to the embedded nil interface.
+type fakeRoomStore struct {
+	store.RoomStore
+	members map[string]map[string]bool // room -> user
+}
+
+func (f *fakeRoomStore) IsUserInRoom(ctx context.Context, userID, roomID string) (bool, error) {
+	return f.members[roomID][userID], nil
+}
+
+func (f *fakeRoomStore) Authorize(ctx context.Context, userID, roomID string, permission store.Permission) error {
+	if !f.members[roomID][userID] {
+		return store.ErrNotRoomMember
+	}
+	return nil
+}
+
+type fakeAttachmentStore struct {
+	attachments map[string]*store.Attachment
+}
+
+func (f *fakeAttachmentStore) CreateAttachment(ctx context.Context, attachment *store.Attachment) (*store.Attachment, error) {
+	attachment.HasThumbnail = attachment.ThumbnailKey != ""
+	f.attachments[attachment.ID] = attachment
+	return attachment, nil
+}
+
+func (f *fakeAttachmentStore) GetAttachment(ctx context.Context, attachmentID string) (*store.Attachment, error) {
+	attachment, ok := f.attachments[attachmentID]
+	if !ok {
+		return nil, store.ErrAttachmentNotFound
+	}
+	return attachment, nil
+}
+
+func newTestAttachmentHandler(t *testing.T) (*AttachmentHandler, *fakeAttachmentStore) {
+	t.Helper()
+	blobStore, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments := &fakeAttachmentStore{attachments: make(map[string]*store.Attachment)}
+	rooms := &fakeRoomStore{members: map[string]map[string]bool{
+		"room": {"alice": true, "bob": true},
+	}}
+	return NewAttachmentHandler(attachments, rooms, blobStore, []byte("test-key")), attachments
+}
+
+func upload(handler *AttachmentHandler, userID string, body []byte, contentLength int64) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/rooms/room/attachments?user_id="+userID+"&filename=../notes.txt", bytes.NewReader(body))
+	r.SetPathValue("id", "room")
+	r.ContentLength = contentLength
+	w := httptest.NewRecorder()
+	handler.HandleUpload(w, r)
+	return w
+}
+
+func TestUploadLimits(t *testing.T) {
+	handler, _ := newTestAttachmentHandler(t)
+	text := []byte("meeting notes")
+
+	tests := []struct {
+		name          string
+		userID        string
+		body          []byte
+		contentLength int64
+		want          int
+	}{
+		{"accepted", "alice", text, int64(len(text)), http.StatusCreated},
+		{"no length", "alice", text, 0, http.StatusLengthRequired},
+		{"too large", "alice", text, MaxAttachmentSize + 1, http.StatusRequestEntityTooLarge},
+		{"type from content, not name", "alice", []byte("<html><script>alert(1)</script></html>"), 38, http.StatusUnsupportedMediaType},
+		{"not a member", "mallory", text, int64(len(text)), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := upload(handler, tt.userID, tt.body, tt.contentLength)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+// uploadAndSign stores a file as alice and returns its ID
+func uploadAndSign(t *testing.T, handler *AttachmentHandler, attachments *fakeAttachmentStore, content string) string {
+	t.Helper()
+	w := upload(handler, "alice", []byte(content), int64(len(content)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload: status %d: %s", w.Code, w.Body)
+	}
+	for id, attachment := range attachments.attachments {
+		if attachment.Filename != "notes.txt" {
+			t.Fatalf("file name %q was not cleaned", attachment.Filename)
+		}
+		return id
+	}
+	t.Fatal("upload stored nothing")
+	return ""
+}
+
+func download(handler *AttachmentHandler, attachmentID string, params url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/attachments/"+attachmentID+"/download?"+params.Encode(), nil)
+	r.SetPathValue("id", attachmentID)
+	w := httptest.NewRecorder()
+	handler.HandleDownload(w, r)
+	return w
+}
+
+func signedParams(t *testing.T, handler *AttachmentHandler, attachmentID, userID string, expires time.Time) url.Values {
+	t.Helper()
+	signed, err := url.Parse(handler.signedURL(attachmentID, userID, "original", expires))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed.Query()
+}
+
+func TestDownloadSignedURL(t *testing.T) {
+	handler, attachments := newTestAttachmentHandler(t)
+	id := uploadAndSign(t, handler, attachments, "meeting notes")
+	valid := func() url.Values {
+		return signedParams(t, handler, id, "alice", time.Now().Add(downloadURLTTL))
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		w := download(handler, id, valid())
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		if body, _ := io.ReadAll(w.Body); string(body) != "meeting notes" {
+			t.Fatalf("body %q", body)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Fatal("download can be sniffed")
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+			t.Fatalf("text is served %q", w.Header().Get("Content-Disposition"))
+		}
+	})
+
+	tests := []struct {
+		name   string
+		params func() url.Values
+		want   string
+	}{
+		{"expired", func() url.Values {
+			return signedParams(t, handler, id, "alice", time.Now().Add(-time.Second))
+		}, "expired"},
+		{"expiry pushed back", func() url.Values {
+			params := valid()
+			params.Set("expires", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+			return params
+		}, "Invalid"},
+		{"tampered signature", func() url.Values {
+			params := valid()
+			params.Set("sig", strings.Repeat("0", 64))
+			return params
+		}, "Invalid"},
+		{"someone else's link", func() url.Values {
+			params := valid()
+			params.Set("user_id", "bob")
+			return params
+		}, "Invalid"},
+		{"other variant", func() url.Values {
+			params := valid()
+			params.Set("variant", "thumbnail")
+			return params
+		}, "Invalid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := download(handler, id, tt.params())
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status %d, want 403: %s", w.Code, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Fatalf("body %q does not mention %q", w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadAfterLeaving(t *testing.T) {
+	handler, attachments := newTestAttachmentHandler(t)
+	id := uploadAndSign(t, handler, attachments, "meeting notes")
+	params := signedParams(t, handler, id, "alice", time.Now().Add(downloadURLTTL))
+
+	handler.roomStore.(*fakeRoomStore).members["room"]["alice"] = false
+
+	w := download(handler, id, params)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403 once the user left the room", w.Code)
+	}
+}
+
+func TestCleanFilename(t *testing.T) {
+	long := strings.Repeat("a", 254) + "żółw.txt" // ż is two bytes, cut after its first
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"notes.txt", "notes.txt"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\alice\notes.txt`, "notes.txt"},
+		{"", "file"},
+		{"/", "file"},
+		{"bad\xffname.txt", "badname.txt"},
+		{long, strings.Repeat("a", 254)},
+		{strings.Repeat("ż", 200), strings.Repeat("ż", 127)},
+	}
+
+	for _, tt := range tests {
+		got := cleanFilename(tt.name)
+		if got != tt.want {
+			t.Errorf("cleanFilename(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if !utf8.ValidString(got) || len(got) > 255 {
+			t.Errorf("cleanFilename(%q) = %q is not a valid name", tt.name, got)
+		}
+	}
+}
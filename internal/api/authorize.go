@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// authorize answers with an error unless userID's role in roomID grants the
+// permission. denied is what members without it are told.
+func authorize(w http.ResponseWriter, ctx context.Context, roomStore store.RoomStore, userID, roomID string, permission store.Permission, denied string) bool {
+	err := roomStore.Authorize(ctx, userID, roomID, permission)
+	if errors.Is(err, store.ErrNotRoomMember) {
+		http.Error(w, "You are not a member of this room", http.StatusForbidden)
+		return false
+	}
+	if errors.Is(err, store.ErrForbidden) {
+		http.Error(w, denied, http.StatusForbidden)
+		return false
+	}
+	if errors.Is(err, store.ErrMuted) {
+		http.Error(w, "You are muted in this room", http.StatusForbidden)
+		return false
+	}
+	if errors.Is(err, store.ErrRoomArchived) {
+		http.Error(w, "This room is archived", http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
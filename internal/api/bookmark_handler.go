@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+type BookmarkHandler struct {
+	bookmarkStore store.BookmarkStore
+	messageStore  store.MessageStore
+	roomStore     store.RoomStore
+}
+
+func NewBookmarkHandler(bookmarkStore store.BookmarkStore, messageStore store.MessageStore, roomStore store.RoomStore) *BookmarkHandler {
+	return &BookmarkHandler{
+		bookmarkStore: bookmarkStore,
+		messageStore:  messageStore,
+		roomStore:     roomStore,
+	}
+}
+
+// HandleBookmark saves a message for the caller on POST and forgets it on
+// DELETE. Both are idempotent.
+func (bh *BookmarkHandler) HandleBookmark(w http.ResponseWriter, r *http.Request) {
+	var userID string
+	switch r.Method {
+	case http.MethodPost:
+		var bookmarkRequest struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&bookmarkRequest); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		userID = bookmarkRequest.UserID
+
+	case http.MethodDelete:
+		userID = r.URL.Query().Get("user_id")
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	messageID := r.PathValue("id")
+
+	if r.Method == http.MethodDelete {
+		// Leaving the room doesn't stop anyone from cleaning up their list
+		if _, err := bh.bookmarkStore.RemoveBookmark(ctx, userID, messageID); err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to remove bookmark", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	message, err := bh.messageStore.GetMessage(ctx, messageID)
+	if errors.Is(err, store.ErrMessageNotFound) || (err == nil && message.DeletedAt != nil) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to bookmark message", http.StatusInternalServerError)
+		return
+	}
+
+	isInRoom, err := bh.roomStore.IsUserInRoom(ctx, userID, message.Room)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to bookmark message", http.StatusInternalServerError)
+		return
+	}
+	if !isInRoom {
+		http.Error(w, "You are not a member of this room", http.StatusForbidden)
+		return
+	}
+
+	if _, err := bh.bookmarkStore.AddBookmark(ctx, userID, messageID); err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to bookmark message", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(message)
+}
+
+// HandleGetBookmarks returns the caller's saved messages, newest first
+func (bh *BookmarkHandler) HandleGetBookmarks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	userID := params.Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := 0
+	if raw := params.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx := context.Background()
+	page, err := bh.bookmarkStore.GetBookmarks(ctx, userID, params.Get("before"), limit)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve bookmarks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/filter"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+type FilterHandler struct {
+	filterStore store.FilterStore
+	roomStore   store.RoomStore
+	events      *events.Bus
+}
+
+func NewFilterHandler(filterStore store.FilterStore, roomStore store.RoomStore, bus *events.Bus) *FilterHandler {
+	return &FilterHandler{
+		filterStore: filterStore,
+		roomStore:   roomStore,
+		events:      bus,
+	}
+}
+
+// HandleFilters lists a room's filter rules (GET) or adds one (POST).
+// Moderators can see the rules; only admins can change them.
+func (fh *FilterHandler) HandleFilters(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "User ID is required", http.StatusBadRequest)
+			return
+		}
+		if !authorize(w, ctx, fh.roomStore, userID, roomID, store.PermReview, "Only moderators can see the filter rules") {
+			return
+		}
+
+		rules, err := fh.filterStore.GetFilterRules(ctx, roomID)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to retrieve filter rules", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(rules)
+
+	case http.MethodPost:
+		var ruleRequest struct {
+			UserID string `json:"user_id"`
+			Kind   string `json:"kind"`
+			Value  string `json:"value"`
+			Action string `json:"action"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&ruleRequest); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if ruleRequest.UserID == "" {
+			http.Error(w, "User ID is required", http.StatusBadRequest)
+			return
+		}
+
+		rule := &store.FilterRule{
+			RoomID:    roomID,
+			Kind:      ruleRequest.Kind,
+			Value:     ruleRequest.Value,
+			Action:    ruleRequest.Action,
+			CreatedBy: ruleRequest.UserID,
+		}
+		if err := filter.NormalizeRule(rule); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid rule: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if !authorize(w, ctx, fh.roomStore, ruleRequest.UserID, roomID, store.PermChangeSettings, "Only admins can change the filter rules") {
+			return
+		}
+
+		rule, err := fh.filterStore.AddFilterRule(ctx, rule)
+		if errors.Is(err, store.ErrFilterRuleExists) {
+			http.Error(w, "The room already has this rule", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to add filter rule", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleRemoveFilter deletes one of a room's filter rules. Admins only.
+func (fh *FilterHandler) HandleRemoveFilter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, fh.roomStore, userID, roomID, store.PermChangeSettings, "Only admins can change the filter rules") {
+		return
+	}
+
+	err := fh.filterStore.RemoveFilterRule(ctx, roomID, r.PathValue("rule"))
+	if errors.Is(err, store.ErrFilterRuleNotFound) {
+		http.Error(w, "Filter rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to remove filter rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleHeldMessages returns the messages of a room waiting for review,
+// oldest first. Moderators only.
+func (fh *FilterHandler) HandleHeldMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, fh.roomStore, userID, roomID, store.PermReview, "Only moderators can review held messages") {
+		return
+	}
+
+	held, err := fh.filterStore.GetHeldMessages(ctx, roomID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve held messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(held)
+}
+
+// HandleReviewHeldMessage lets a held message through or turns it down. An
+// approved message is sent as if it had just been written; the sender and
+// the room's other reviewers are told either way.
+func (fh *FilterHandler) HandleReviewHeldMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var reviewRequest struct {
+		UserID  string `json:"user_id"`
+		Approve bool   `json:"approve"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reviewRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if reviewRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, fh.roomStore, reviewRequest.UserID, roomID, store.PermReview, "Only moderators can review held messages") {
+		return
+	}
+
+	held, err := fh.filterStore.ReviewHeldMessage(ctx, roomID, r.PathValue("held"), reviewRequest.UserID, reviewRequest.Approve)
+	if errors.Is(err, store.ErrHeldMessageNotFound) {
+		http.Error(w, "Held message not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, store.ErrHeldMessageReviewed) {
+		http.Error(w, "Held message was already reviewed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to review held message", http.StatusInternalServerError)
+		return
+	}
+
+	// The sender may have left or been muted while the message waited; then
+	// it is dropped like a scheduled message would be
+	if held.Approved {
+		if err := fh.roomStore.Authorize(ctx, held.Sender, roomID, store.PermPost); err != nil {
+			fmt.Printf("Dropping held message %s: %v\n", held.ID, err)
+		} else {
+			message := *held.Message
+			message.ID = ""
+			message.Time = time.Now()
+			fh.events.PublishMessage(&message)
+		}
+	}
+
+	reviewers, err := fh.roomStore.GetMembersWith(ctx, roomID, store.PermReview)
+	if err != nil {
+		fmt.Println(err)
+	}
+	fh.events.PublishUser(held.Sender, events.HeldMessageReviewed(held))
+	for _, reviewer := range reviewers {
+		if reviewer != held.Sender {
+			fh.events.PublishUser(reviewer, events.HeldMessageReviewed(held))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(held)
+}
@@ -0,0 +1,437 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// Longest an invite link can stay valid
+const maxInviteLifetime = 30 * 24 * time.Hour
+
+type InviteHandler struct {
+	inviteStore store.InviteStore
+	roomStore   store.RoomStore
+	events      *events.Bus
+}
+
+func NewInviteHandler(inviteStore store.InviteStore, roomStore store.RoomStore, bus *events.Bus) *InviteHandler {
+	return &InviteHandler{
+		inviteStore: inviteStore,
+		roomStore:   roomStore,
+		events:      bus,
+	}
+}
+
+// canInvite answers with an error unless userID may manage who joins roomID
+func (ih *InviteHandler) canInvite(w http.ResponseWriter, ctx context.Context, userID, roomID string) bool {
+	return authorize(w, ctx, ih.roomStore, userID, roomID, store.PermInvite, "Only moderators can manage who joins this room")
+}
+
+// HandleInvites lists a room's invite links on GET and creates one on POST.
+// A new link may expire after expires_in, such as "24h", and allow max_uses
+// joins.
+func (ih *InviteHandler) HandleInvites(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "User ID is required", http.StatusBadRequest)
+			return
+		}
+		if !ih.canInvite(w, ctx, userID, roomID) {
+			return
+		}
+
+		invites, err := ih.inviteStore.GetInvites(ctx, roomID)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to retrieve invites", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(invites)
+
+	case http.MethodPost:
+		var inviteRequest struct {
+			UserID    string `json:"user_id"`
+			ExpiresIn string `json:"expires_in"`
+			MaxUses   int    `json:"max_uses"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&inviteRequest); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if inviteRequest.UserID == "" {
+			http.Error(w, "User ID is required", http.StatusBadRequest)
+			return
+		}
+		if inviteRequest.MaxUses < 0 {
+			http.Error(w, "Invalid max uses", http.StatusBadRequest)
+			return
+		}
+
+		invite := &store.Invite{
+			RoomID:    roomID,
+			CreatedBy: inviteRequest.UserID,
+			MaxUses:   inviteRequest.MaxUses,
+		}
+		if inviteRequest.ExpiresIn != "" {
+			lifetime, err := time.ParseDuration(inviteRequest.ExpiresIn)
+			if err != nil || lifetime <= 0 || lifetime > maxInviteLifetime {
+				http.Error(w, "Invalid expiry", http.StatusBadRequest)
+				return
+			}
+			expiresAt := time.Now().Add(lifetime)
+			invite.ExpiresAt = &expiresAt
+		}
+
+		if !ih.canInvite(w, ctx, inviteRequest.UserID, roomID) {
+			return
+		}
+
+		code := make([]byte, 12)
+		rand.Read(code)
+		invite.Code = base64.RawURLEncoding.EncodeToString(code)
+
+		created, err := ih.inviteStore.CreateInvite(ctx, invite)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleRevokeInvite deletes an invite link
+func (ih *InviteHandler) HandleRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !ih.canInvite(w, ctx, userID, roomID) {
+		return
+	}
+
+	err := ih.inviteStore.RevokeInvite(ctx, roomID, r.PathValue("invite"))
+	if errors.Is(err, store.ErrInviteNotFound) {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to revoke invite", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleJoinWithInvite joins the room behind an invite link
+func (ih *InviteHandler) HandleJoinWithInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var joinRequest struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&joinRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if joinRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	room, err := ih.inviteStore.JoinWithInvite(context.Background(), joinRequest.UserID, r.PathValue("code"))
+	if errors.Is(err, store.ErrInviteNotFound) {
+		http.Error(w, "Invite not found, expired or used up", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, store.ErrBanned) {
+		http.Error(w, "You are banned from this room", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, store.ErrRoomFull) {
+		http.Error(w, "This room is full", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, store.ErrRoomArchived) {
+		http.Error(w, "This room is archived", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to join room", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(room)
+}
+
+// HandleInvitation invites invitee_id to a room on POST and declines the
+// caller's invitation on DELETE
+func (ih *InviteHandler) HandleInvitation(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodPost:
+		var invitationRequest struct {
+			UserID    string `json:"user_id"`
+			InviteeID string `json:"invitee_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&invitationRequest); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if invitationRequest.UserID == "" || invitationRequest.InviteeID == "" {
+			http.Error(w, "User ID and invitee ID are required", http.StatusBadRequest)
+			return
+		}
+
+		if !ih.canInvite(w, ctx, invitationRequest.UserID, roomID) {
+			return
+		}
+
+		invited, err := ih.inviteStore.InviteUser(ctx, roomID, invitationRequest.InviteeID, invitationRequest.UserID)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to invite user", http.StatusInternalServerError)
+			return
+		}
+
+		invitation := &store.Invitation{
+			RoomID:    roomID,
+			UserID:    invitationRequest.InviteeID,
+			InvitedBy: invitationRequest.UserID,
+			CreatedAt: time.Now(),
+		}
+		if invited {
+			ih.events.PublishUser(invitation.UserID, events.RoomInvitation(invitation))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]bool{"invited": invited})
+
+	case http.MethodDelete:
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "User ID is required", http.StatusBadRequest)
+			return
+		}
+
+		err := ih.inviteStore.DeclineInvitation(ctx, roomID, userID)
+		if errors.Is(err, store.ErrInvitationNotFound) {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to decline invitation", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleGetInvitations lists the caller's pending invitations
+func (ih *InviteHandler) HandleGetInvitations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	invitations, err := ih.inviteStore.GetInvitations(context.Background(), userID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve invitations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// HandleJoinRequests knocks on a private room on POST and lists the pending
+// requests on GET
+func (ih *InviteHandler) HandleJoinRequests(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "User ID is required", http.StatusBadRequest)
+			return
+		}
+		if !ih.canInvite(w, ctx, userID, roomID) {
+			return
+		}
+
+		requests, err := ih.inviteStore.GetJoinRequests(ctx, roomID)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to retrieve join requests", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(requests)
+
+	case http.MethodPost:
+		var knockRequest struct {
+			UserID  string `json:"user_id"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&knockRequest); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if knockRequest.UserID == "" {
+			http.Error(w, "User ID is required", http.StatusBadRequest)
+			return
+		}
+		if len(knockRequest.Message) > 500 {
+			http.Error(w, "Message exceeds maximum length of 500 characters", http.StatusBadRequest)
+			return
+		}
+
+		joined, err := ih.inviteStore.RequestToJoin(ctx, roomID, knockRequest.UserID, knockRequest.Message)
+		if errors.Is(err, store.ErrRoomNotFound) {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, store.ErrInvitationRequired) {
+			http.Error(w, "This room is invite only", http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, store.ErrBanned) {
+			http.Error(w, "You are banned from this room", http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, store.ErrRoomFull) {
+			http.Error(w, "This room is full", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, store.ErrRoomArchived) {
+			http.Error(w, "This room is archived", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to request to join", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]bool{"joined": joined})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleAnswerJoinRequest approves or rejects a request to join
+func (ih *InviteHandler) HandleAnswerJoinRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var answerRequest struct {
+		UserID  string `json:"user_id"`
+		Approve bool   `json:"approve"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&answerRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if answerRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	requesterID := r.PathValue("user")
+	if !ih.canInvite(w, ctx, answerRequest.UserID, roomID) {
+		return
+	}
+
+	err := ih.inviteStore.AnswerJoinRequest(ctx, roomID, requesterID, answerRequest.Approve)
+	if errors.Is(err, store.ErrJoinRequestNotFound) {
+		http.Error(w, "Join request not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, store.ErrBanned) {
+		http.Error(w, "That user is banned from this room", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, store.ErrRoomFull) {
+		http.Error(w, "This room is full", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, store.ErrRoomArchived) {
+		http.Error(w, "This room is archived", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to answer join request", http.StatusInternalServerError)
+		return
+	}
+
+	ih.events.PublishUser(requesterID, events.JoinRequestAnswered(roomID, answerRequest.Approve))
+
+	w.WriteHeader(http.StatusNoContent)
+}
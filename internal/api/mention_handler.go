@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+type MentionHandler struct {
+	mentionStore store.MentionStore
+}
+
+func NewMentionHandler(mentionStore store.MentionStore) *MentionHandler {
+	return &MentionHandler{
+		mentionStore: mentionStore,
+	}
+}
+
+// HandleGetMentions returns the caller's mentions inbox, newest first
+func (mh *MentionHandler) HandleGetMentions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	userID := params.Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := 0
+	if raw := params.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx := context.Background()
+	page, err := mh.mentionStore.GetUserMentions(ctx, userID, params.Get("before"), limit)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve mentions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page)
+}
@@ -1,35 +1,208 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/kaczmarekdaniel/gochat/internal/store"
 )
 
 type MessageHandler struct {
 	messageStore store.MessageStore
+	roomStore    store.RoomStore
 }
 
-func NewMessageHandler(messageStore store.MessageStore) *MessageHandler {
+func NewMessageHandler(messageStore store.MessageStore, roomStore store.RoomStore) *MessageHandler {
 	return &MessageHandler{
 		messageStore: messageStore,
+		roomStore:    roomStore,
 	}
 }
 
+// HandleGetMesssages returns a page of a room's history to one of its members
 func (wh *MessageHandler) HandleGetMesssages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID := r.PathValue("id")
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
 
-	messages, err := wh.messageStore.GetMessages("123") // TODO: FIX IT
+	pageQuery, err := readPageQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	isInRoom, err := wh.roomStore.IsUserInRoom(ctx, userID, roomID)
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, "failed to retrieve the messages", http.StatusInternalServerError)
 		return
 	}
+	if !isInRoom {
+		http.Error(w, "You are not a member of this room", http.StatusForbidden)
+		return
+	}
+
+	page, err := wh.messageStore.GetMessagePage(ctx, roomID, pageQuery)
+	if errors.Is(err, store.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "failed to retrieve the messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page)
+}
+
+// HandleGetMessageEdits returns the previous versions of an edited message
+func (wh *MessageHandler) HandleGetMessageEdits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	message, ok := wh.getMemberMessage(ctx, w, userID, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	edits, err := wh.messageStore.GetMessageEdits(ctx, message.ID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve message history", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(edits)
+}
+
+// HandleGetThread returns the root message of a thread with a page of replies
+func (wh *MessageHandler) HandleGetThread(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	pageQuery, err := readPageQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	parent, ok := wh.getMemberMessage(ctx, w, userID, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	replies, err := wh.messageStore.GetThreadPage(ctx, parent.ID, pageQuery)
+	if errors.Is(err, store.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve thread", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"parent":  parent,
+		"replies": replies,
+	})
+}
+
+// getMemberMessage loads a message for a user, answering with 404 when it
+// does not exist and 403 when the user is not in its room
+func (wh *MessageHandler) getMemberMessage(ctx context.Context, w http.ResponseWriter, userID, messageID string) (*store.Message, bool) {
+	message, err := wh.messageStore.GetMessage(ctx, messageID)
+	if errors.Is(err, store.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve message", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	isInRoom, err := wh.roomStore.IsUserInRoom(ctx, userID, message.Room)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve message", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !isInRoom {
+		http.Error(w, "You are not a member of this room", http.StatusForbidden)
+		return nil, false
+	}
+
+	return message, true
+}
+
+// readPageQuery parses the before/after/around/limit paging parameters
+func readPageQuery(r *http.Request) (store.MessagePageQuery, error) {
+	params := r.URL.Query()
+	pageQuery := store.MessagePageQuery{
+		Before: params.Get("before"),
+		After:  params.Get("after"),
+		Around: params.Get("around"),
+	}
+
+	anchors := 0
+	for _, anchor := range []string{pageQuery.Before, pageQuery.After, pageQuery.Around} {
+		if anchor != "" {
+			anchors++
+		}
+	}
+	if anchors > 1 {
+		return pageQuery, errors.New("only one of before, after and around can be used")
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return pageQuery, errors.New("invalid limit")
+		}
+		pageQuery.Limit = n
+	}
+
+	return pageQuery, nil
 }
 
 func (wh *MessageHandler) HandleCreateMessage(messageRaw *store.Message) (*store.Message, error) {
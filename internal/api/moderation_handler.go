@@ -0,0 +1,365 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// Longest a mute can last; anything longer should be a ban
+const maxMuteDuration = 30 * 24 * time.Hour
+
+type ModerationHandler struct {
+	moderationStore store.ModerationStore
+	roomStore       store.RoomStore
+	events          *events.Bus
+}
+
+func NewModerationHandler(moderationStore store.ModerationStore, roomStore store.RoomStore, bus *events.Bus) *ModerationHandler {
+	return &ModerationHandler{
+		moderationStore: moderationStore,
+		roomStore:       roomStore,
+		events:          bus,
+	}
+}
+
+// moderationRequest is the body of a kick, ban or mute. Duration is a Go
+// duration such as "1h"; for bans it is optional and they last forever
+// without one.
+type moderationRequest struct {
+	UserID   string `json:"user_id"`
+	TargetID string `json:"target_id"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+// decodeModeration reads and checks a moderation request body
+func decodeModeration(w http.ResponseWriter, r *http.Request) (*moderationRequest, bool) {
+	var request moderationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	if request.UserID == "" || request.TargetID == "" {
+		http.Error(w, "User ID and target ID are required", http.StatusBadRequest)
+		return nil, false
+	}
+	if request.UserID == request.TargetID {
+		http.Error(w, "You cannot moderate yourself", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(request.Reason) > 500 {
+		http.Error(w, "Reason exceeds maximum length of 500 characters", http.StatusBadRequest)
+		return nil, false
+	}
+	return &request, true
+}
+
+// parseDuration reads a moderation request's duration; zero means none given
+func parseDuration(w http.ResponseWriter, raw string) (time.Duration, bool) {
+	if raw == "" {
+		return 0, true
+	}
+	duration, err := time.ParseDuration(raw)
+	if err != nil || duration <= 0 {
+		http.Error(w, "Invalid duration", http.StatusBadRequest)
+		return 0, false
+	}
+	return duration, true
+}
+
+// canModerate checks that the actor holds the permission and outranks the
+// target, so moderators cannot act against admins or each other
+func canModerate(w http.ResponseWriter, ctx context.Context, roomStore store.RoomStore, actorID, targetID, roomID string, permission store.Permission, denied string) bool {
+	if !authorize(w, ctx, roomStore, actorID, roomID, permission, denied) {
+		return false
+	}
+
+	actorRole, err := roomStore.GetMemberRole(ctx, actorID, roomID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return false
+	}
+	targetRole, err := roomStore.GetMemberRole(ctx, targetID, roomID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return false
+	}
+	if !store.RoleOutranks(actorRole, targetRole) {
+		http.Error(w, "You can only moderate members below you", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// announce tells the room about a moderation action. Users who were taken
+// out of the room no longer receive its events, so they are told directly.
+func announce(bus *events.Bus, entry *store.ModerationEntry, removed bool) {
+	bus.PublishRoom(events.Moderation(entry))
+	if removed {
+		bus.PublishUser(entry.TargetID, events.Moderation(entry))
+	}
+}
+
+// HandleKick removes a member from a room. They can join again.
+func (mh *ModerationHandler) HandleKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request, ok := decodeModeration(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !canModerate(w, ctx, mh.roomStore, request.UserID, request.TargetID, roomID, store.PermKick, "Only moderators can kick members") {
+		return
+	}
+
+	entry, err := mh.moderationStore.Kick(ctx, roomID, request.TargetID, request.UserID, request.Reason)
+	if errors.Is(err, store.ErrNotRoomMember) {
+		http.Error(w, "User is not a member of this room", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to kick user", http.StatusInternalServerError)
+		return
+	}
+
+	announce(mh.events, entry, true)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// HandleBans lists the bans in force on GET and bans a user on POST
+func (mh *ModerationHandler) HandleBans(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "User ID is required", http.StatusBadRequest)
+			return
+		}
+		if !authorize(w, ctx, mh.roomStore, userID, roomID, store.PermViewModeration, "Only admins can see bans") {
+			return
+		}
+
+		bans, err := mh.moderationStore.GetBans(ctx, roomID)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to retrieve bans", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(bans)
+
+	case http.MethodPost:
+		request, ok := decodeModeration(w, r)
+		if !ok {
+			return
+		}
+		duration, ok := parseDuration(w, request.Duration)
+		if !ok {
+			return
+		}
+
+		if !canModerate(w, ctx, mh.roomStore, request.UserID, request.TargetID, roomID, store.PermBan, "Only admins can ban users") {
+			return
+		}
+
+		var expiresAt *time.Time
+		if duration > 0 {
+			expiry := time.Now().Add(duration)
+			expiresAt = &expiry
+		}
+
+		entry, err := mh.moderationStore.Ban(ctx, roomID, request.TargetID, request.UserID, request.Reason, expiresAt)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to ban user", http.StatusInternalServerError)
+			return
+		}
+
+		announce(mh.events, entry, true)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(entry)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleUnban lifts a ban
+func (mh *ModerationHandler) HandleUnban(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, mh.roomStore, userID, roomID, store.PermBan, "Only admins can lift bans") {
+		return
+	}
+
+	entry, err := mh.moderationStore.Unban(ctx, roomID, r.PathValue("user"), userID)
+	if errors.Is(err, store.ErrBanNotFound) {
+		http.Error(w, "Ban not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to lift ban", http.StatusInternalServerError)
+		return
+	}
+
+	announce(mh.events, entry, true)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleMute stops a user posting in a room for a duration
+func (mh *ModerationHandler) HandleMute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request, ok := decodeModeration(w, r)
+	if !ok {
+		return
+	}
+	duration, ok := parseDuration(w, request.Duration)
+	if !ok {
+		return
+	}
+	if duration == 0 || duration > maxMuteDuration {
+		http.Error(w, "A mute needs a duration of at most 30 days", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !canModerate(w, ctx, mh.roomStore, request.UserID, request.TargetID, roomID, store.PermMute, "Only moderators can mute members") {
+		return
+	}
+
+	entry, err := mh.moderationStore.Mute(ctx, roomID, request.TargetID, request.UserID, request.Reason, time.Now().Add(duration))
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to mute user", http.StatusInternalServerError)
+		return
+	}
+
+	announce(mh.events, entry, false)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// HandleUnmute lifts a mute before it runs out
+func (mh *ModerationHandler) HandleUnmute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, mh.roomStore, userID, roomID, store.PermMute, "Only moderators can lift mutes") {
+		return
+	}
+
+	entry, err := mh.moderationStore.Unmute(ctx, roomID, r.PathValue("user"), userID)
+	if errors.Is(err, store.ErrMuteNotFound) {
+		http.Error(w, "Mute not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to lift mute", http.StatusInternalServerError)
+		return
+	}
+
+	announce(mh.events, entry, false)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetModerationLog returns a page of a room's moderation log, newest
+// first. Admins only.
+func (mh *ModerationHandler) HandleGetModerationLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	userID := params.Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := 0
+	if raw := params.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, mh.roomStore, userID, roomID, store.PermViewModeration, "Only admins can see the moderation log") {
+		return
+	}
+
+	page, err := mh.moderationStore.GetModerationLog(ctx, roomID, params.Get("before"), limit)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve moderation log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page)
+}
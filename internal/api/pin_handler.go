@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+type PinHandler struct {
+	pinStore  store.PinStore
+	roomStore store.RoomStore
+}
+
+func NewPinHandler(pinStore store.PinStore, roomStore store.RoomStore) *PinHandler {
+	return &PinHandler{
+		pinStore:  pinStore,
+		roomStore: roomStore,
+	}
+}
+
+// HandleGetPins lists the pinned messages of a room to one of its members
+func (ph *PinHandler) HandleGetPins(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID := r.PathValue("id")
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	isInRoom, err := ph.roomStore.IsUserInRoom(ctx, userID, roomID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve pinned messages", http.StatusInternalServerError)
+		return
+	}
+	if !isInRoom {
+		http.Error(w, "You are not a member of this room", http.StatusForbidden)
+		return
+	}
+
+	pins, err := ph.pinStore.GetPinnedMessages(ctx, roomID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve pinned messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pins)
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+type ReadHandler struct {
+	readStore store.ReadStore
+	events    *events.Bus
+}
+
+func NewReadHandler(readStore store.ReadStore, bus *events.Bus) *ReadHandler {
+	return &ReadHandler{
+		readStore: readStore,
+		events:    bus,
+	}
+}
+
+// HandleMarkRead moves the caller's read marker in a room forward
+func (rh *ReadHandler) HandleMarkRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var readRequest struct {
+		UserID    string `json:"user_id"`
+		MessageID string `json:"message_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&readRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	roomID := r.PathValue("id")
+	if readRequest.UserID == "" || readRequest.MessageID == "" {
+		http.Error(w, "User ID and Message ID are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	state, advanced, err := rh.readStore.MarkRead(ctx, readRequest.UserID, roomID, readRequest.MessageID)
+	if errors.Is(err, store.ErrNotRoomMember) {
+		http.Error(w, "You are not a member of this room", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, store.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to mark room as read", http.StatusInternalServerError)
+		return
+	}
+
+	if advanced {
+		rh.events.PublishRoom(events.Read(state))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(state)
+}
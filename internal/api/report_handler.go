@@ -0,0 +1,447 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+type ReportHandler struct {
+	reportStore     store.ReportStore
+	messageStore    store.MessageStore
+	moderationStore store.ModerationStore
+	roomStore       store.RoomStore
+	events          *events.Bus
+}
+
+func NewReportHandler(reportStore store.ReportStore, messageStore store.MessageStore, moderationStore store.ModerationStore, roomStore store.RoomStore, bus *events.Bus) *ReportHandler {
+	return &ReportHandler{
+		reportStore:     reportStore,
+		messageStore:    messageStore,
+		moderationStore: moderationStore,
+		roomStore:       roomStore,
+		events:          bus,
+	}
+}
+
+// notifyReviewers sends an event to every moderator of a room but one, who
+// already knows because they caused it
+func (rh *ReportHandler) notifyReviewers(ctx context.Context, roomID, except string, event *store.Message) {
+	reviewers, err := rh.roomStore.GetMembersWith(ctx, roomID, store.PermReview)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, reviewer := range reviewers {
+		if reviewer != except {
+			rh.events.PublishUser(reviewer, event)
+		}
+	}
+}
+
+// HandleReports files a report on POST and lists a room's report queue on
+// GET. Any member can report a message or another member; only moderators
+// see the queue. status picks open, claimed or resolved reports and
+// defaults to the unresolved ones.
+func (rh *ReportHandler) HandleReports(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		params := r.URL.Query()
+		userID := params.Get("user_id")
+		if userID == "" {
+			http.Error(w, "User ID is required", http.StatusBadRequest)
+			return
+		}
+
+		status := params.Get("status")
+		switch status {
+		case "", store.ReportOpen, store.ReportClaimed, store.ReportResolved:
+		default:
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+
+		if !authorize(w, ctx, rh.roomStore, userID, roomID, store.PermReview, "Only moderators can see reports") {
+			return
+		}
+
+		reports, err := rh.reportStore.GetReports(ctx, roomID, status)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to retrieve reports", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(reports)
+
+	case http.MethodPost:
+		var reportRequest struct {
+			UserID    string `json:"user_id"`
+			TargetID  string `json:"target_id"`
+			MessageID string `json:"message_id"`
+			Reason    string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&reportRequest); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if reportRequest.UserID == "" {
+			http.Error(w, "User ID is required", http.StatusBadRequest)
+			return
+		}
+		if reportRequest.TargetID == "" && reportRequest.MessageID == "" {
+			http.Error(w, "A message ID or target ID is required", http.StatusBadRequest)
+			return
+		}
+		if reportRequest.Reason == "" {
+			http.Error(w, "Reason is required", http.StatusBadRequest)
+			return
+		}
+		if len(reportRequest.Reason) > 500 {
+			http.Error(w, "Reason exceeds maximum length of 500 characters", http.StatusBadRequest)
+			return
+		}
+
+		// Muted members can still report, so this only asks for membership
+		role, err := rh.roomStore.GetMemberRole(ctx, reportRequest.UserID, roomID)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+			return
+		}
+		if role == "" {
+			http.Error(w, "You are not a member of this room", http.StatusForbidden)
+			return
+		}
+
+		report := &store.Report{
+			RoomID:     roomID,
+			ReporterID: reportRequest.UserID,
+			TargetID:   reportRequest.TargetID,
+			Reason:     reportRequest.Reason,
+		}
+
+		if reportRequest.MessageID != "" {
+			message, err := rh.messageStore.GetMessage(ctx, reportRequest.MessageID)
+			if errors.Is(err, store.ErrMessageNotFound) {
+				http.Error(w, "Message not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				fmt.Println(err)
+				http.Error(w, "Failed to retrieve message", http.StatusInternalServerError)
+				return
+			}
+			if message.Room != roomID || message.DeletedAt != nil {
+				http.Error(w, "Message not found", http.StatusNotFound)
+				return
+			}
+			report.MessageID = message.ID
+			report.MessageContent = message.Content
+			report.TargetID = message.Sender
+		} else {
+			targetRole, err := rh.roomStore.GetMemberRole(ctx, report.TargetID, roomID)
+			if err != nil {
+				fmt.Println(err)
+				http.Error(w, "Failed to retrieve member", http.StatusInternalServerError)
+				return
+			}
+			if targetRole == "" {
+				http.Error(w, "User is not a member of this room", http.StatusNotFound)
+				return
+			}
+		}
+
+		if report.TargetID == report.ReporterID {
+			http.Error(w, "You cannot report yourself", http.StatusBadRequest)
+			return
+		}
+
+		report, err = rh.reportStore.CreateReport(ctx, report)
+		if errors.Is(err, store.ErrReportExists) {
+			http.Error(w, "You already reported this", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to file report", http.StatusInternalServerError)
+			return
+		}
+
+		rh.notifyReviewers(ctx, roomID, report.ReporterID, events.ReportCreated(report))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(report)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleGetReport returns a report with its history. Moderators only.
+func (rh *ReportHandler) HandleGetReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, rh.roomStore, userID, roomID, store.PermReview, "Only moderators can see reports") {
+		return
+	}
+
+	report, err := rh.reportStore.GetReport(ctx, roomID, r.PathValue("report"))
+	if errors.Is(err, store.ErrReportNotFound) {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// HandleClaim claims a report on POST and releases it on DELETE. Admins can
+// take a report over from a moderator who claimed it and went quiet.
+func (rh *ReportHandler) HandleClaim(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	reportID := r.PathValue("report")
+
+	var userID string
+	switch r.Method {
+	case http.MethodPost:
+		var claimRequest struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&claimRequest); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		userID = claimRequest.UserID
+	case http.MethodDelete:
+		userID = r.URL.Query().Get("user_id")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	if !authorize(w, ctx, rh.roomStore, userID, roomID, store.PermReview, "Only moderators can handle reports") {
+		return
+	}
+
+	var report *store.Report
+	var err error
+	if r.Method == http.MethodPost {
+		takeOver := rh.roomStore.Authorize(ctx, userID, roomID, store.PermViewModeration) == nil
+		report, err = rh.reportStore.ClaimReport(ctx, roomID, reportID, userID, takeOver)
+	} else {
+		report, err = rh.reportStore.ReleaseReport(ctx, roomID, reportID, userID)
+	}
+	if errors.Is(err, store.ErrReportNotFound) {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, store.ErrReportResolved) {
+		http.Error(w, "Report was already resolved", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, store.ErrReportClaimed) {
+		http.Error(w, "Report is claimed by another moderator", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, store.ErrReportNotClaimed) {
+		http.Error(w, "Report is not claimed by you", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to update report", http.StatusInternalServerError)
+		return
+	}
+
+	rh.notifyReviewers(ctx, roomID, userID, events.ReportUpdated(report))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// HandleResolve closes a report and carries out what the moderator decided:
+// dismiss it, delete the reported message, or mute or ban the reported
+// member. Mutes and bans follow the same rules as taking them directly and
+// land in the moderation log too.
+func (rh *ReportHandler) HandleResolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resolveRequest struct {
+		UserID   string `json:"user_id"`
+		Action   string `json:"action"`
+		Note     string `json:"note"`
+		Duration string `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&resolveRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if resolveRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if !store.ValidResolution(resolveRequest.Action) {
+		http.Error(w, "Action must be one of dismiss, delete_message, mute or ban", http.StatusBadRequest)
+		return
+	}
+	if len(resolveRequest.Note) > 500 {
+		http.Error(w, "Note exceeds maximum length of 500 characters", http.StatusBadRequest)
+		return
+	}
+	duration, ok := parseDuration(w, resolveRequest.Duration)
+	if !ok {
+		return
+	}
+	if resolveRequest.Action == store.ResolutionMute && (duration == 0 || duration > maxMuteDuration) {
+		http.Error(w, "A mute needs a duration of at most 30 days", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	userID := resolveRequest.UserID
+	if !authorize(w, ctx, rh.roomStore, userID, roomID, store.PermReview, "Only moderators can handle reports") {
+		return
+	}
+
+	report, err := rh.reportStore.GetReport(ctx, roomID, r.PathValue("report"))
+	if errors.Is(err, store.ErrReportNotFound) {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve report", http.StatusInternalServerError)
+		return
+	}
+
+	// Checked up front so nothing is done about a report someone else is
+	// handling; the store checks again when the report is closed
+	if report.Status == store.ReportResolved {
+		http.Error(w, "Report was already resolved", http.StatusConflict)
+		return
+	}
+	if report.Status == store.ReportClaimed && report.ClaimedBy != userID {
+		http.Error(w, "Report is claimed by another moderator", http.StatusConflict)
+		return
+	}
+
+	reason := resolveRequest.Note
+	if reason == "" {
+		reason = report.Reason
+	}
+
+	switch resolveRequest.Action {
+	case store.ResolutionDeleteMessage:
+		if report.MessageID == "" {
+			http.Error(w, "Report is not about a message", http.StatusBadRequest)
+			return
+		}
+		if !authorize(w, ctx, rh.roomStore, userID, roomID, store.PermDeleteOthers, "You are not allowed to delete this message") {
+			return
+		}
+
+		deleted, err := rh.messageStore.DeleteMessage(ctx, report.MessageID, userID)
+		if err != nil && !errors.Is(err, store.ErrMessageNotFound) {
+			fmt.Println(err)
+			http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+			return
+		}
+		// Not found means it was deleted already, which is what was asked
+		if err == nil {
+			rh.events.PublishRoom(events.MessageDeleted(deleted))
+		}
+
+	case store.ResolutionMute:
+		if !canModerate(w, ctx, rh.roomStore, userID, report.TargetID, roomID, store.PermMute, "Only moderators can mute members") {
+			return
+		}
+
+		entry, err := rh.moderationStore.Mute(ctx, roomID, report.TargetID, userID, reason, time.Now().Add(duration))
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to mute user", http.StatusInternalServerError)
+			return
+		}
+		announce(rh.events, entry, false)
+
+	case store.ResolutionBan:
+		if !canModerate(w, ctx, rh.roomStore, userID, report.TargetID, roomID, store.PermBan, "Only admins can ban users") {
+			return
+		}
+
+		var expiresAt *time.Time
+		if duration > 0 {
+			expiry := time.Now().Add(duration)
+			expiresAt = &expiry
+		}
+
+		entry, err := rh.moderationStore.Ban(ctx, roomID, report.TargetID, userID, reason, expiresAt)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to ban user", http.StatusInternalServerError)
+			return
+		}
+		announce(rh.events, entry, true)
+	}
+
+	report, err = rh.reportStore.ResolveReport(ctx, roomID, report.ID, userID, resolveRequest.Action, resolveRequest.Note)
+	if errors.Is(err, store.ErrReportResolved) {
+		http.Error(w, "Report was already resolved", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, store.ErrReportClaimed) {
+		http.Error(w, "Report is claimed by another moderator", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to resolve report", http.StatusInternalServerError)
+		return
+	}
+
+	rh.notifyReviewers(ctx, roomID, userID, events.ReportUpdated(report))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
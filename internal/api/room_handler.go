@@ -3,18 +3,26 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/kaczmarekdaniel/gochat/internal/events"
 	"github.com/kaczmarekdaniel/gochat/internal/store"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type RoomHandler struct {
 	roomStore store.RoomStore
+	events    *events.Bus
 }
 
-func NewRoomHandler(roomStore store.RoomStore) *RoomHandler {
+func NewRoomHandler(roomStore store.RoomStore, bus *events.Bus) *RoomHandler {
 	return &RoomHandler{
 		roomStore: roomStore,
+		events:    bus,
 	}
 }
 
@@ -23,7 +31,7 @@ func (rh *RoomHandler) HandleRooms(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		rh.handleGetRooms(w)
+		rh.handleGetRooms(w, r)
 	case http.MethodPost:
 		rh.handleCreateRoom(w, r)
 	default:
@@ -31,12 +39,51 @@ func (rh *RoomHandler) HandleRooms(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleGetRooms handles GET requests to retrieve all rooms
-func (rh *RoomHandler) handleGetRooms(w http.ResponseWriter) {
+// handleGetRooms serves the room directory: a page of the public channels,
+// optionally searched by name and topic with q. sort is members (default),
+// activity or name, and paging continues from the after cursor. With
+// exclude_joined=true the rooms user_id is in are left out. Private,
+// invite-only and archived rooms never show up here.
+func (rh *RoomHandler) handleGetRooms(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	params := r.URL.Query()
+	query := store.DirectoryQuery{
+		Search: strings.TrimSpace(params.Get("q")),
+		Sort:   params.Get("sort"),
+		After:  params.Get("after"),
+	}
+
+	switch query.Sort {
+	case "", store.DirectoryByMembers, store.DirectoryByActivity, store.DirectoryByName:
+	default:
+		http.Error(w, "Sort must be members, activity or name", http.StatusBadRequest)
+		return
+	}
+	if len(query.Search) > 100 {
+		http.Error(w, "Search exceeds maximum length of 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	if raw := params.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		query.Limit = n
+	}
+
+	if params.Get("exclude_joined") == "true" {
+		query.ExcludeUserID = params.Get("user_id")
+		if query.ExcludeUserID == "" {
+			http.Error(w, "User ID is required to exclude joined rooms", http.StatusBadRequest)
+			return
+		}
+	}
+
 	ctx := context.Background()
-	rooms, err := rh.roomStore.GetRooms(ctx)
+	page, err := rh.roomStore.GetDirectory(ctx, query)
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, "Failed to retrieve rooms", http.StatusInternalServerError)
@@ -45,13 +92,15 @@ func (rh *RoomHandler) handleGetRooms(w http.ResponseWriter) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(rooms)
+	json.NewEncoder(w).Encode(page)
 }
 
 // handleCreateRoom handles POST requests to create a new room
 func (rh *RoomHandler) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
 	var roomRequest struct {
-		Name string `json:"name"`
+		UserID     string `json:"user_id"`
+		Name       string `json:"name"`
+		Visibility string `json:"visibility"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&roomRequest); err != nil {
@@ -63,15 +112,37 @@ func (rh *RoomHandler) handleCreateRoom(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Room name cannot be empty", http.StatusBadRequest)
 		return
 	}
+	if roomRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if roomRequest.Visibility != "" && !validVisibility(roomRequest.Visibility) {
+		http.Error(w, "Visibility must be public, private or invite", http.StatusBadRequest)
+		return
+	}
 
 	ctx := context.Background()
-	room, err := rh.roomStore.CreateRoom(ctx, roomRequest.Name)
+	room, err := rh.roomStore.CreateRoom(ctx, roomRequest.Name, roomRequest.UserID)
+	if errors.Is(err, store.ErrRoomNameTaken) {
+		http.Error(w, "A room with this name already exists", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, "Failed to create room", http.StatusInternalServerError)
 		return
 	}
 
+	if roomRequest.Visibility != "" && roomRequest.Visibility != room.Visibility {
+		err = rh.roomStore.SetVisibility(ctx, room.ID, roomRequest.Visibility)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to create room", http.StatusInternalServerError)
+			return
+		}
+		room.Visibility = roomRequest.Visibility
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(room)
@@ -125,6 +196,30 @@ func (rh *RoomHandler) HandleJoinRoom(w http.ResponseWriter, r *http.Request) {
 
 	ctx := context.Background()
 	err := rh.roomStore.JoinRoom(ctx, joinRequest.UserID, joinRequest.RoomID)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, store.ErrInvitationRequired) {
+		http.Error(w, "This room is invite only", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, store.ErrApprovalRequired) {
+		http.Error(w, "This room is private; request to join instead", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, store.ErrBanned) {
+		http.Error(w, "You are banned from this room", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, store.ErrRoomFull) {
+		http.Error(w, "This room is full", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, store.ErrRoomArchived) {
+		http.Error(w, "This room is archived", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, "Failed to join room", http.StatusInternalServerError)
@@ -160,6 +255,10 @@ func (rh *RoomHandler) HandleLeaveRoom(w http.ResponseWriter, r *http.Request) {
 
 	ctx := context.Background()
 	err := rh.roomStore.LeaveRoom(ctx, leaveRequest.UserID, leaveRequest.RoomID)
+	if errors.Is(err, store.ErrOwnerCannotLeave) {
+		http.Error(w, "Transfer ownership of the room before leaving it", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, "Failed to leave room", http.StatusInternalServerError)
@@ -169,3 +268,600 @@ func (rh *RoomHandler) HandleLeaveRoom(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Successfully left room"})
 }
+
+// HandleMuteRoom mutes or unmutes notifications from a room. Mentions are
+// still delivered while a room is muted.
+func (rh *RoomHandler) HandleMuteRoom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var muteRequest struct {
+		UserID string `json:"user_id"`
+		Muted  bool   `json:"muted"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&muteRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if muteRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	err := rh.roomStore.SetRoomMuted(ctx, muteRequest.UserID, r.PathValue("id"), muteRequest.Muted)
+	if errors.Is(err, store.ErrNotRoomMember) {
+		http.Error(w, "You are not a member of this room", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to update room notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]bool{"muted": muteRequest.Muted})
+}
+
+// HandleSetMessageTTL sets how long new messages in a room live by default.
+// A ttl of zero turns self-destructing messages off.
+func (rh *RoomHandler) HandleSetMessageTTL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ttlRequest struct {
+		UserID string `json:"user_id"`
+		TTL    int    `json:"ttl"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&ttlRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if ttlRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if ttlRequest.TTL < 0 || ttlRequest.TTL > int(store.MaxMessageTTL/time.Second) {
+		http.Error(w, "ttl must be between 0 and 30 days in seconds", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, rh.roomStore, ttlRequest.UserID, roomID, store.PermChangeSettings, "Only admins can change message lifetime") {
+		return
+	}
+
+	err := rh.roomStore.SetMessageTTL(ctx, roomID, time.Duration(ttlRequest.TTL)*time.Second)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to update room", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int{"message_ttl": ttlRequest.TTL})
+}
+
+// HandleSetRetention sets how many days a room keeps its history and whether
+// older messages are deleted or archived. Zero days falls back to the server
+// default.
+func (rh *RoomHandler) HandleSetRetention(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var retentionRequest struct {
+		UserID string `json:"user_id"`
+		Days   int    `json:"days"`
+		Action string `json:"action"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&retentionRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if retentionRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if retentionRequest.Days < 0 {
+		http.Error(w, "Invalid number of days", http.StatusBadRequest)
+		return
+	}
+
+	var policy *store.RetentionPolicy
+	if retentionRequest.Days > 0 {
+		policy = &store.RetentionPolicy{Days: retentionRequest.Days, Action: retentionRequest.Action}
+		switch policy.Action {
+		case "":
+			policy.Action = store.RetentionArchive
+		case store.RetentionDelete, store.RetentionArchive:
+		default:
+			http.Error(w, "Action must be delete or archive", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, rh.roomStore, retentionRequest.UserID, roomID, store.PermChangeSettings, "Only admins can change retention") {
+		return
+	}
+
+	err := rh.roomStore.SetRetentionPolicy(ctx, roomID, policy)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to update room", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]*store.RetentionPolicy{"retention": policy})
+}
+
+func validVisibility(visibility string) bool {
+	switch visibility {
+	case store.VisibilityPublic, store.VisibilityPrivate, store.VisibilityInvite:
+		return true
+	}
+	return false
+}
+
+// HandleSetVisibility makes a room public, private (joined on approval) or
+// invite only
+func (rh *RoomHandler) HandleSetVisibility(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var visibilityRequest struct {
+		UserID     string `json:"user_id"`
+		Visibility string `json:"visibility"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&visibilityRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if visibilityRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if !validVisibility(visibilityRequest.Visibility) {
+		http.Error(w, "Visibility must be public, private or invite", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, rh.roomStore, visibilityRequest.UserID, roomID, store.PermChangeSettings, "Only admins can change who may join") {
+		return
+	}
+
+	err := rh.roomStore.SetVisibility(ctx, roomID, visibilityRequest.Visibility)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to update room", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"visibility": visibilityRequest.Visibility})
+}
+
+// HandleGetMembers lists a room's members and their roles to its members
+func (rh *RoomHandler) HandleGetMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	isInRoom, err := rh.roomStore.IsUserInRoom(ctx, userID, roomID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve members", http.StatusInternalServerError)
+		return
+	}
+	if !isInRoom {
+		http.Error(w, "You are not a member of this room", http.StatusForbidden)
+		return
+	}
+
+	members, err := rh.roomStore.GetRoomMembers(ctx, roomID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve members", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(members)
+}
+
+// HandleSetMemberRole changes a member's role. Callers can only promote or
+// demote members below them, and only to roles below their own.
+func (rh *RoomHandler) HandleSetMemberRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var roleRequest struct {
+		UserID string `json:"user_id"`
+		Role   string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&roleRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if roleRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if !store.ValidRole(roleRequest.Role) || roleRequest.Role == store.RoleOwner {
+		http.Error(w, "Role must be admin, moderator or member", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	memberID := r.PathValue("user")
+	if !authorize(w, ctx, rh.roomStore, roleRequest.UserID, roomID, store.PermManageRoles, "Only admins can change roles") {
+		return
+	}
+
+	role, err := rh.roomStore.GetMemberRole(ctx, roleRequest.UserID, roomID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to change role", http.StatusInternalServerError)
+		return
+	}
+	current, err := rh.roomStore.GetMemberRole(ctx, memberID, roomID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to change role", http.StatusInternalServerError)
+		return
+	}
+	if current == "" {
+		http.Error(w, "User is not a member of this room", http.StatusNotFound)
+		return
+	}
+	if !store.RoleOutranks(role, current) || !store.RoleOutranks(role, roleRequest.Role) {
+		http.Error(w, "You can only change the roles of members below you, to roles below yours", http.StatusForbidden)
+		return
+	}
+
+	err = rh.roomStore.SetMemberRole(ctx, roomID, memberID, roleRequest.Role)
+	if errors.Is(err, store.ErrNotRoomMember) {
+		http.Error(w, "User is not a member of this room", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to change role", http.StatusInternalServerError)
+		return
+	}
+
+	rh.events.PublishRoom(events.MemberRoleChanged(roomID, memberID, roleRequest.Role, roleRequest.UserID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"user_id": memberID, "role": roleRequest.Role})
+}
+
+// HandleTransferOwnership hands the room to another member. Only the owner
+// can do this; they stay on as an admin.
+func (rh *RoomHandler) HandleTransferOwnership(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var transferRequest struct {
+		UserID     string `json:"user_id"`
+		NewOwnerID string `json:"new_owner_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&transferRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if transferRequest.UserID == "" || transferRequest.NewOwnerID == "" {
+		http.Error(w, "User ID and new owner ID are required", http.StatusBadRequest)
+		return
+	}
+	if transferRequest.UserID == transferRequest.NewOwnerID {
+		http.Error(w, "You already own this room", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	err := rh.roomStore.TransferOwnership(ctx, roomID, transferRequest.UserID, transferRequest.NewOwnerID)
+	if errors.Is(err, store.ErrForbidden) {
+		http.Error(w, "Only the owner can transfer the room", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, store.ErrNotRoomMember) {
+		http.Error(w, "The new owner must be a member of this room", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to transfer ownership", http.StatusInternalServerError)
+		return
+	}
+
+	rh.events.PublishRoom(events.MemberRoleChanged(roomID, transferRequest.UserID, store.RoleAdmin, transferRequest.UserID))
+	rh.events.PublishRoom(events.MemberRoleChanged(roomID, transferRequest.NewOwnerID, store.RoleOwner, transferRequest.UserID))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleOpenDirectRoom finds or starts a direct conversation between the
+// caller and participant_ids. Two people make a DM; more make a group, which
+// can have a name.
+func (rh *RoomHandler) HandleOpenDirectRoom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var openRequest struct {
+		UserID         string   `json:"user_id"`
+		ParticipantIDs []string `json:"participant_ids"`
+		Name           string   `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&openRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if openRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if len(openRequest.Name) > 255 {
+		http.Error(w, "Name exceeds maximum length of 255 characters", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	room, created, err := rh.roomStore.OpenDirectRoom(ctx, openRequest.UserID, openRequest.ParticipantIDs, openRequest.Name)
+	if errors.Is(err, store.ErrTooFewParticipants) || errors.Is(err, store.ErrTooManyParticipants) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, store.ErrRoomArchived) {
+		http.Error(w, "This conversation is archived", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to open conversation", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		events.AnnounceRoomOpened(rh.events, room, openRequest.UserID)
+		status = http.StatusCreated
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(room)
+}
+
+// Limits on room metadata
+const (
+	maxTopicLength       = 250
+	maxDescriptionLength = 2000
+	maxAvatarURLLength   = 2048
+	maxSlowMode          = 6 * 60 * 60
+)
+
+// HandleRoom returns a room on GET, changes its settings on PATCH and
+// deletes it on DELETE. Public channels can be looked at by anyone; other
+// rooms only by their members.
+func (rh *RoomHandler) HandleRoom(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rh.handleGetRoom(w, r)
+	case http.MethodPatch:
+		rh.handleUpdateRoom(w, r)
+	case http.MethodDelete:
+		rh.handleDeleteRoom(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (rh *RoomHandler) handleGetRoom(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	room, err := rh.roomStore.GetRoom(ctx, r.PathValue("id"))
+	if errors.Is(err, store.ErrRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve room", http.StatusInternalServerError)
+		return
+	}
+
+	if room.Kind != store.KindChannel || room.Visibility != store.VisibilityPublic {
+		isInRoom, err := rh.roomStore.IsUserInRoom(ctx, userID, room.ID)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Failed to retrieve room", http.StatusInternalServerError)
+			return
+		}
+		if !isInRoom {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(room)
+}
+
+// handleUpdateRoom applies the fields present in the body. Admins only.
+func (rh *RoomHandler) handleUpdateRoom(w http.ResponseWriter, r *http.Request) {
+	var updateRequest struct {
+		UserID string `json:"user_id"`
+		store.RoomUpdate
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&updateRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if updateRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	update := &updateRequest.RoomUpdate
+	if update.Name != nil {
+		trimmed := strings.TrimSpace(*update.Name)
+		if trimmed == "" || len(trimmed) > 255 {
+			http.Error(w, "Room name must be between 1 and 255 characters", http.StatusBadRequest)
+			return
+		}
+		update.Name = &trimmed
+	}
+	if update.Topic != nil && len(*update.Topic) > maxTopicLength {
+		http.Error(w, fmt.Sprintf("Topic exceeds maximum length of %d characters", maxTopicLength), http.StatusBadRequest)
+		return
+	}
+	if update.Description != nil && len(*update.Description) > maxDescriptionLength {
+		http.Error(w, fmt.Sprintf("Description exceeds maximum length of %d characters", maxDescriptionLength), http.StatusBadRequest)
+		return
+	}
+	if update.AvatarURL != nil && *update.AvatarURL != "" && !validAvatarURL(*update.AvatarURL) {
+		http.Error(w, "Avatar must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+	if update.SlowMode != nil && (*update.SlowMode < 0 || *update.SlowMode > maxSlowMode) {
+		http.Error(w, "Slow mode must be between 0 and 21600 seconds", http.StatusBadRequest)
+		return
+	}
+	if update.MemberLimit != nil && *update.MemberLimit < 0 {
+		http.Error(w, "Invalid member limit", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, rh.roomStore, updateRequest.UserID, roomID, store.PermChangeSettings, "Only admins can change room settings") {
+		return
+	}
+
+	room, err := rh.roomStore.UpdateRoom(ctx, roomID, update)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, store.ErrRoomNameTaken) {
+		http.Error(w, "A room with this name already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to update room", http.StatusInternalServerError)
+		return
+	}
+
+	rh.events.PublishRoom(events.RoomUpdated(room))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(room)
+}
+
+// handleDeleteRoom deletes a room for everyone. Owner only. The members are
+// told directly, as the room has no members left to send its events to.
+func (rh *RoomHandler) handleDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	roomID := r.PathValue("id")
+	if !authorize(w, ctx, rh.roomStore, userID, roomID, store.PermDeleteRoom, "Only the owner can delete this room") {
+		return
+	}
+
+	members, err := rh.roomStore.DeleteRoom(ctx, roomID)
+	if errors.Is(err, store.ErrRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to delete room", http.StatusInternalServerError)
+		return
+	}
+
+	for _, member := range members {
+		rh.events.PublishUser(member, events.RoomDeleted(roomID, userID))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validAvatarURL(raw string) bool {
+	if len(raw) > maxAvatarURLLength {
+		return false
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// How far ahead messages and reminders can be scheduled
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+type ScheduleHandler struct {
+	scheduleStore store.ScheduleStore
+	messageStore  store.MessageStore
+	roomStore     store.RoomStore
+}
+
+func NewScheduleHandler(scheduleStore store.ScheduleStore, messageStore store.MessageStore, roomStore store.RoomStore) *ScheduleHandler {
+	return &ScheduleHandler{
+		scheduleStore: scheduleStore,
+		messageStore:  messageStore,
+		roomStore:     roomStore,
+	}
+}
+
+// HandleScheduleMessage queues a message to be sent to a room later
+func (sh *ScheduleHandler) HandleScheduleMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var scheduleRequest struct {
+		UserID   string    `json:"user_id"`
+		Content  string    `json:"content"`
+		Format   string    `json:"format"`
+		ParentID string    `json:"parent_id"`
+		SendAt   time.Time `json:"send_at"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&scheduleRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	roomID := r.PathValue("id")
+	content := strings.TrimSpace(scheduleRequest.Content)
+	if scheduleRequest.UserID == "" || content == "" {
+		http.Error(w, "User ID and content are required", http.StatusBadRequest)
+		return
+	}
+	if len(content) > 1000 {
+		http.Error(w, "Message content exceeds maximum length of 1000 characters", http.StatusBadRequest)
+		return
+	}
+	switch scheduleRequest.Format {
+	case "", store.FormatPlain, store.FormatMarkdown:
+	default:
+		http.Error(w, "Unsupported message format", http.StatusBadRequest)
+		return
+	}
+	if !validScheduleTime(w, scheduleRequest.SendAt) {
+		return
+	}
+
+	ctx := context.Background()
+	if !authorize(w, ctx, sh.roomStore, scheduleRequest.UserID, roomID, store.PermPost, "You are not allowed to post in this room") {
+		return
+	}
+
+	if scheduleRequest.ParentID != "" {
+		parent, err := sh.messageStore.GetMessage(ctx, scheduleRequest.ParentID)
+		if err != nil || parent.Room != roomID || parent.DeletedAt != nil || parent.ParentID != "" {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	scheduled, err := sh.scheduleStore.CreateScheduledMessage(ctx, &store.ScheduledMessage{
+		UserID:   scheduleRequest.UserID,
+		RoomID:   roomID,
+		Content:  content,
+		Format:   scheduleRequest.Format,
+		ParentID: scheduleRequest.ParentID,
+		SendAt:   scheduleRequest.SendAt,
+	})
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to schedule message", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(scheduled)
+}
+
+// HandleGetScheduled lists the caller's messages that have not been sent yet
+func (sh *ScheduleHandler) HandleGetScheduled(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	scheduled, err := sh.scheduleStore.GetScheduledMessages(context.Background(), userID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve scheduled messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(scheduled)
+}
+
+// HandleCancelScheduled cancels one of the caller's scheduled messages
+func (sh *ScheduleHandler) HandleCancelScheduled(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	err := sh.scheduleStore.CancelScheduledMessage(context.Background(), userID, r.PathValue("id"))
+	if errors.Is(err, store.ErrScheduledMessageNotFound) {
+		http.Error(w, "Scheduled message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to cancel scheduled message", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleCreateReminder sets a reminder about a message, either at remind_at
+// or after a delay such as "2h" given in in
+func (sh *ScheduleHandler) HandleCreateReminder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var reminderRequest struct {
+		UserID   string    `json:"user_id"`
+		RemindAt time.Time `json:"remind_at"`
+		In       string    `json:"in"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&reminderRequest); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if reminderRequest.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	remindAt := reminderRequest.RemindAt
+	if reminderRequest.In != "" {
+		delay, err := time.ParseDuration(reminderRequest.In)
+		if err != nil {
+			http.Error(w, "Invalid reminder delay", http.StatusBadRequest)
+			return
+		}
+		remindAt = time.Now().Add(delay)
+	}
+	if !validScheduleTime(w, remindAt) {
+		return
+	}
+
+	ctx := context.Background()
+	messageID := r.PathValue("id")
+	message, err := sh.messageStore.GetMessage(ctx, messageID)
+	if errors.Is(err, store.ErrMessageNotFound) || (err == nil && message.DeletedAt != nil) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to set reminder", http.StatusInternalServerError)
+		return
+	}
+
+	isInRoom, err := sh.roomStore.IsUserInRoom(ctx, reminderRequest.UserID, message.Room)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to set reminder", http.StatusInternalServerError)
+		return
+	}
+	if !isInRoom {
+		http.Error(w, "You are not a member of this room", http.StatusForbidden)
+		return
+	}
+
+	reminder, err := sh.scheduleStore.CreateReminder(ctx, &store.Reminder{
+		UserID:    reminderRequest.UserID,
+		MessageID: messageID,
+		RemindAt:  remindAt,
+	})
+	if errors.Is(err, store.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to set reminder", http.StatusInternalServerError)
+		return
+	}
+	reminder.Message = message
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(reminder)
+}
+
+// HandleGetReminders lists the caller's pending reminders
+func (sh *ScheduleHandler) HandleGetReminders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	reminders, err := sh.scheduleStore.GetReminders(context.Background(), userID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to retrieve reminders", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(reminders)
+}
+
+// HandleCancelReminder cancels one of the caller's reminders
+func (sh *ScheduleHandler) HandleCancelReminder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	err := sh.scheduleStore.CancelReminder(context.Background(), userID, r.PathValue("id"))
+	if errors.Is(err, store.ErrReminderNotFound) {
+		http.Error(w, "Reminder not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to cancel reminder", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validScheduleTime answers with 400 unless at is in the future and within
+// maxScheduleAhead
+func validScheduleTime(w http.ResponseWriter, at time.Time) bool {
+	now := time.Now()
+	if !at.After(now) {
+		http.Error(w, "Time must be in the future", http.StatusBadRequest)
+		return false
+	}
+	if at.After(now.Add(maxScheduleAhead)) {
+		http.Error(w, "Time is too far in the future", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+type SearchHandler struct {
+	messageStore store.MessageStore
+}
+
+func NewSearchHandler(messageStore store.MessageStore) *SearchHandler {
+	return &SearchHandler{
+		messageStore: messageStore,
+	}
+}
+
+// HandleSearch searches the messages of every room the caller is in
+func (sh *SearchHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	userID := params.Get("user_id")
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	query := store.SearchQuery{
+		Text:     strings.TrimSpace(params.Get("q")),
+		RoomID:   params.Get("room"),
+		SenderID: params.Get("sender"),
+		OrderBy:  params.Get("order"),
+	}
+	if query.Text == "" {
+		http.Error(w, "Search text is required", http.StatusBadRequest)
+		return
+	}
+	if query.OrderBy == "" {
+		query.OrderBy = store.SearchByRelevance
+	}
+	if query.OrderBy != store.SearchByRelevance && query.OrderBy != store.SearchByRecency {
+		http.Error(w, "Order must be relevance or recent", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if query.From, err = readTimeParam(params.Get("from")); err != nil {
+		http.Error(w, "Invalid from date", http.StatusBadRequest)
+		return
+	}
+	if query.To, err = readTimeParam(params.Get("to")); err != nil {
+		http.Error(w, "Invalid to date", http.StatusBadRequest)
+		return
+	}
+
+	if raw := params.Get("has_attachment"); raw != "" {
+		hasAttachment, err := strconv.ParseBool(raw)
+		if err != nil {
+			http.Error(w, "Invalid has_attachment", http.StatusBadRequest)
+			return
+		}
+		query.HasAttachment = &hasAttachment
+	}
+
+	if query.Offset, err = readIntParam(params.Get("offset"), 0); err != nil {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	if query.Limit, err = readIntParam(params.Get("limit"), 1); err != nil {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	page, err := sh.messageStore.SearchMessages(ctx, userID, query)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page)
+}
+
+// readTimeParam parses an optional RFC 3339 timestamp
+func readTimeParam(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+// readIntParam parses an optional integer that must be at least minimum
+func readIntParam(raw string, minimum int) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < minimum {
+		return 0, fmt.Errorf("invalid integer %q", raw)
+	}
+	return n, nil
+}
@@ -1,28 +1,71 @@
 package app
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/kaczmarekdaniel/gochat/internal/api"
+	"github.com/kaczmarekdaniel/gochat/internal/blob"
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/expiry"
+	"github.com/kaczmarekdaniel/gochat/internal/filter"
+	"github.com/kaczmarekdaniel/gochat/internal/partition"
+	"github.com/kaczmarekdaniel/gochat/internal/retention"
+	"github.com/kaczmarekdaniel/gochat/internal/scheduler"
 	"github.com/kaczmarekdaniel/gochat/internal/store"
+	"github.com/kaczmarekdaniel/gochat/internal/unfurl"
 	"github.com/kaczmarekdaniel/gochat/migrations"
 )
 
 type Application struct {
-	Logger         *log.Logger
-	MessageHandler *api.MessageHandler
-	DB             *sql.DB
-	MessageStore   store.MessageStore
-	RoomStore      store.RoomStore
-	RoomHandler    *api.RoomHandler
-	UserHandler    *api.UserHandler
-	SessionHandler *api.SessionHandler
-	AuthHandler    *api.AuthHandler
+	Logger            *log.Logger
+	MessageHandler    *api.MessageHandler
+	DB                *sql.DB
+	MessageStore      store.MessageStore
+	RoomStore         store.RoomStore
+	ReadStore         store.ReadStore
+	ReactionStore     store.ReactionStore
+	MentionStore      store.MentionStore
+	AttachmentStore   store.AttachmentStore
+	LinkPreviewStore  store.LinkPreviewStore
+	PinStore          store.PinStore
+	BookmarkStore     store.BookmarkStore
+	ScheduleStore     store.ScheduleStore
+	RetentionStore    store.RetentionStore
+	PartitionStore    store.PartitionStore
+	InviteStore       store.InviteStore
+	ModerationStore   store.ModerationStore
+	FilterStore       store.FilterStore
+	ReportStore       store.ReportStore
+	BlobStore         blob.BlobStore
+	Events            *events.Bus
+	Unfurler          *unfurl.Worker
+	Scheduler         *scheduler.Scheduler
+	Purger            *expiry.Purger
+	Retention         *retention.Worker
+	Partitions        *partition.Maintainer
+	Filters           *filter.Pipeline
+	RoomHandler       *api.RoomHandler
+	ReadHandler       *api.ReadHandler
+	MentionHandler    *api.MentionHandler
+	SearchHandler     *api.SearchHandler
+	AttachmentHandler *api.AttachmentHandler
+	PinHandler        *api.PinHandler
+	BookmarkHandler   *api.BookmarkHandler
+	ScheduleHandler   *api.ScheduleHandler
+	InviteHandler     *api.InviteHandler
+	ModerationHandler *api.ModerationHandler
+	FilterHandler     *api.FilterHandler
+	ReportHandler     *api.ReportHandler
+	UserHandler       *api.UserHandler
+	SessionHandler    *api.SessionHandler
+	AuthHandler       *api.AuthHandler
 }
 
 type RoomHandler struct {
@@ -52,7 +95,8 @@ func (rh *RoomHandler) HandleRooms(w http.ResponseWriter, r *http.Request) {
 	case "POST":
 		// Create a new room
 		var roomReq struct {
-			Name string `json:"name"`
+			Name   string `json:"name"`
+			UserID string `json:"user_id"`
 		}
 
 		err := json.NewDecoder(r.Body).Decode(&roomReq)
@@ -61,7 +105,7 @@ func (rh *RoomHandler) HandleRooms(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		room, err := rh.roomStore.CreateRoom(r.Context(), roomReq.Name)
+		room, err := rh.roomStore.CreateRoom(r.Context(), roomReq.Name, roomReq.UserID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -95,24 +139,105 @@ func NewApplication() (*Application, error) {
 	roomStore := store.NewPostgresRoomStore(pgDB)
 	userStore := store.NewPostgresUserStore(pgDB)
 	sessionStore := store.NewPostgresSessionStore(pgDB)
+	readStore := store.NewPostgresReadStore(pgDB)
+	reactionStore := store.NewPostgresReactionStore(pgDB)
+	mentionStore := store.NewPostgresMentionStore(pgDB)
+	attachmentStore := store.NewPostgresAttachmentStore(pgDB)
+	linkPreviewStore := store.NewPostgresLinkPreviewStore(pgDB)
+	pinStore := store.NewPostgresPinStore(pgDB)
+	bookmarkStore := store.NewPostgresBookmarkStore(pgDB)
+	scheduleStore := store.NewPostgresScheduleStore(pgDB)
+	retentionStore := store.NewPostgresRetentionStore(pgDB)
+	partitionStore := store.NewPostgresPartitionStore(pgDB)
+	inviteStore := store.NewPostgresInviteStore(pgDB)
+	moderationStore := store.NewPostgresModerationStore(pgDB)
+	filterStore := store.NewPostgresFilterStore(pgDB)
+	reportStore := store.NewPostgresReportStore(pgDB)
+
+	blobStore, err := newBlobStore()
+	if err != nil {
+		return nil, err
+	}
+
+	bus := events.NewBus()
+	unfurler := unfurl.NewWorker(linkPreviewStore, messageStore, bus)
+	purger := expiry.NewPurger(messageStore, blobStore, bus)
+
+	defaultRetention, err := retentionPolicy()
+	if err != nil {
+		return nil, err
+	}
+	retentionWorker := retention.NewWorker(retentionStore, roomStore, blobStore, defaultRetention)
+
+	keepMonths, err := partitionKeepMonths()
+	if err != nil {
+		return nil, err
+	}
+	partitionMaintainer := partition.NewMaintainer(partitionStore, keepMonths)
+	contentFilters := filter.NewPipeline(filterStore, messageFilters()...)
+	messageScheduler := scheduler.NewScheduler(scheduleStore, roomStore, filterStore, contentFilters, bus)
 
 	// Create handlers
-	roomHandler := api.NewRoomHandler(roomStore)
-	messageHandler := api.NewMessageHandler(messageStore)
+	roomHandler := api.NewRoomHandler(roomStore, bus)
+	messageHandler := api.NewMessageHandler(messageStore, roomStore)
 	userHandler := api.NewUserHandler(userStore)
 	sessionHandler := api.NewSessionHandler(sessionStore)
+	readHandler := api.NewReadHandler(readStore, bus)
+	mentionHandler := api.NewMentionHandler(mentionStore)
+	searchHandler := api.NewSearchHandler(messageStore)
+	attachmentHandler := api.NewAttachmentHandler(attachmentStore, roomStore, blobStore, urlSigningKey(logger))
+	pinHandler := api.NewPinHandler(pinStore, roomStore)
+	bookmarkHandler := api.NewBookmarkHandler(bookmarkStore, messageStore, roomStore)
+	scheduleHandler := api.NewScheduleHandler(scheduleStore, messageStore, roomStore)
+	inviteHandler := api.NewInviteHandler(inviteStore, roomStore, bus)
+	moderationHandler := api.NewModerationHandler(moderationStore, roomStore, bus)
+	filterHandler := api.NewFilterHandler(filterStore, roomStore, bus)
+	reportHandler := api.NewReportHandler(reportStore, messageStore, moderationStore, roomStore, bus)
 
 	authHandler := api.NewAuthHandler(userStore, sessionStore)
 
 	app := &Application{
-		MessageStore: messageStore,
-		RoomStore:    roomStore,
+		MessageStore:     messageStore,
+		RoomStore:        roomStore,
+		ReadStore:        readStore,
+		ReactionStore:    reactionStore,
+		MentionStore:     mentionStore,
+		AttachmentStore:  attachmentStore,
+		LinkPreviewStore: linkPreviewStore,
+		PinStore:         pinStore,
+		ScheduleStore:    scheduleStore,
+		RetentionStore:   retentionStore,
+		PartitionStore:   partitionStore,
+		InviteStore:      inviteStore,
+		ModerationStore:  moderationStore,
+		FilterStore:      filterStore,
+		ReportStore:      reportStore,
+		BookmarkStore:    bookmarkStore,
+		BlobStore:        blobStore,
+		Events:           bus,
+		Purger:           purger,
+		Retention:        retentionWorker,
+		Partitions:       partitionMaintainer,
+		Filters:          contentFilters,
+		Scheduler:        messageScheduler,
+		Unfurler:         unfurler,
 
-		MessageHandler: messageHandler,
-		UserHandler:    userHandler,
-		SessionHandler: sessionHandler,
-		AuthHandler:    authHandler,
-		RoomHandler:    roomHandler,
+		MessageHandler:    messageHandler,
+		UserHandler:       userHandler,
+		SessionHandler:    sessionHandler,
+		AuthHandler:       authHandler,
+		RoomHandler:       roomHandler,
+		ReadHandler:       readHandler,
+		MentionHandler:    mentionHandler,
+		SearchHandler:     searchHandler,
+		AttachmentHandler: attachmentHandler,
+		PinHandler:        pinHandler,
+		ScheduleHandler:   scheduleHandler,
+		BookmarkHandler:   bookmarkHandler,
+		InviteHandler:     inviteHandler,
+		ModerationHandler: moderationHandler,
+		FilterHandler:     filterHandler,
+		ReportHandler:     reportHandler,
 
 		DB:     pgDB,
 		Logger: logger,
@@ -121,6 +246,93 @@ func NewApplication() (*Application, error) {
 	return app, nil
 }
 
+// messageFilters lists the content filters new messages go through, cheapest
+// first. CLASSIFIER_URL adds an external classifier at the end.
+func messageFilters() []filter.Filter {
+	filters := []filter.Filter{filter.NewSpam(), filter.NewBlocklist(), filter.NewLinks()}
+	if url := os.Getenv("CLASSIFIER_URL"); url != "" {
+		filters = append(filters, filter.NewClassifier(url))
+	}
+	return filters
+}
+
+// newBlobStore picks where uploaded files are kept. BLOB_STORE=s3 selects an
+// S3-compatible bucket (MinIO works) configured through the S3_* variables;
+// otherwise files go to the local directory in BLOB_DIR.
+func newBlobStore() (blob.BlobStore, error) {
+	if os.Getenv("BLOB_STORE") == "s3" {
+		return blob.NewS3Store(blob.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	}
+
+	dir := os.Getenv("BLOB_DIR")
+	if dir == "" {
+		dir = "./data/blobs"
+	}
+	return blob.NewLocalStore(dir)
+}
+
+// retentionPolicy reads the retention default for rooms without a policy of
+// their own: RETENTION_DAYS, and RETENTION_ACTION as delete or archive
+// (the default). Without RETENTION_DAYS history is kept forever.
+func retentionPolicy() (*store.RetentionPolicy, error) {
+	days := os.Getenv("RETENTION_DAYS")
+	if days == "" {
+		return nil, nil
+	}
+
+	policy := &store.RetentionPolicy{Action: store.RetentionArchive}
+	var err error
+	policy.Days, err = strconv.Atoi(days)
+	if err != nil || policy.Days <= 0 {
+		return nil, fmt.Errorf("invalid RETENTION_DAYS %q", days)
+	}
+
+	switch action := os.Getenv("RETENTION_ACTION"); action {
+	case "":
+	case store.RetentionDelete, store.RetentionArchive:
+		policy.Action = action
+	default:
+		return nil, fmt.Errorf("invalid RETENTION_ACTION %q", action)
+	}
+
+	return policy, nil
+}
+
+// partitionKeepMonths reads MESSAGE_PARTITION_KEEP_MONTHS, the number of
+// monthly message partitions kept attached, the current one included. Older
+// months are detached into tables of their own. Unset keeps every month.
+func partitionKeepMonths() (int, error) {
+	months := os.Getenv("MESSAGE_PARTITION_KEEP_MONTHS")
+	if months == "" {
+		return 0, nil
+	}
+
+	keep, err := strconv.Atoi(months)
+	if err != nil || keep < 0 {
+		return 0, fmt.Errorf("invalid MESSAGE_PARTITION_KEEP_MONTHS %q", months)
+	}
+	return keep, nil
+}
+
+// urlSigningKey returns the key download links are signed with. Without
+// ATTACHMENT_URL_SECRET a random key is used, so links die with the process.
+func urlSigningKey(logger *log.Logger) []byte {
+	if secret := os.Getenv("ATTACHMENT_URL_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	logger.Println("ATTACHMENT_URL_SECRET is not set, using a random key for download links")
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
 func (a *Application) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "status is available")
 }
@@ -0,0 +1,22 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps opaque binary objects under string keys. Keys are built by
+// the application from IDs, never taken from user input.
+type BlobStore interface {
+	// Store size bytes from r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Open an object for reading; the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Remove an object; removing a missing object is not an error
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,106 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, store)
+}
+
+func TestLocalStoreSizeMismatch(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put(ctx, "short", strings.NewReader("abc"), 10, "text/plain")
+	if err == nil {
+		t.Fatal("Put with a wrong size succeeded")
+	}
+
+	// The partial upload must not be visible
+	if _, err = store.Get(ctx, "short"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after failed Put: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalStoreRejectsEscapingKeys(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "../outside", "a/../../outside"} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), 1, "text/plain"); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if _, err := store.Get(ctx, key); err == nil {
+			t.Errorf("Get(%q) succeeded", key)
+		}
+	}
+}
+
+// testRoundTrip runs the behaviour every BlobStore must have
+func testRoundTrip(t *testing.T, store BlobStore) {
+	t.Helper()
+	ctx := context.Background()
+	key := "attachments/room/file"
+	content := "hello, blob"
+
+	err := store.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	reader, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(got) != content {
+		t.Fatalf("Get returned %q, want %q", got, content)
+	}
+
+	// Put replaces an existing object
+	replaced := "replaced"
+	err = store.Put(ctx, key, strings.NewReader(replaced), int64(len(replaced)), "text/plain")
+	if err != nil {
+		t.Fatalf("Put again: %v", err)
+	}
+	reader, err = store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get after replace: %v", err)
+	}
+	got, _ = io.ReadAll(reader)
+	reader.Close()
+	if string(got) != replaced {
+		t.Fatalf("Get after replace returned %q, want %q", got, replaced)
+	}
+
+	if err = store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err = store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after Delete: got %v, want ErrNotFound", err)
+	}
+
+	// Deleting a missing object is not an error
+	if err = store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete of missing object: %v", err)
+	}
+}
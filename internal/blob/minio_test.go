@@ -0,0 +1,34 @@
+//go:build minio
+
+package blob
+
+import (
+	"os"
+	"testing"
+)
+
+// Runs the S3 store against a real MinIO, such as the one in
+// docker-compose.yml, with the same variables the server reads:
+//
+//	S3_ENDPOINT=http://localhost:9000 S3_BUCKET=chat-test \
+//	S3_ACCESS_KEY=minio S3_SECRET_KEY=minio123 go test -tags minio ./internal/blob
+//
+// The bucket must exist.
+func TestS3StoreAgainstMinIO(t *testing.T) {
+	config := S3Config{
+		Endpoint:  os.Getenv("S3_ENDPOINT"),
+		Region:    os.Getenv("S3_REGION"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+	}
+	if config.Endpoint == "" || config.Bucket == "" {
+		t.Skip("S3_ENDPOINT and S3_BUCKET are not set")
+	}
+
+	store, err := NewS3Store(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, store)
+}
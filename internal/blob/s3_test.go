@@ -0,0 +1,135 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is just enough of the S3 API for the store: path-style PUT, GET
+// and DELETE on one bucket. It refuses requests that aren't signed.
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{
+		bucket:  bucket,
+		objects: make(map[string][]byte),
+		types:   make(map[string]string),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") ||
+		!strings.Contains(auth, "SignedHeaders=host;x-amz-content-sha256;x-amz-date") ||
+		r.Header.Get("X-Amz-Date") == "" ||
+		r.Header.Get("X-Amz-Content-Sha256") != unsignedPayload {
+		http.Error(w, "unsigned", http.StatusForbidden)
+		return
+	}
+
+	prefix := "/" + f.bucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+		f.types[key] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		// S3 answers 204 whether or not the key existed
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestS3Store(t *testing.T, endpoint string) *S3Store {
+	t.Helper()
+	store, err := NewS3Store(S3Config{
+		Endpoint:  endpoint,
+		Bucket:    "chat",
+		AccessKey: "key",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestS3StoreRoundTrip(t *testing.T) {
+	fake := newFakeS3("chat")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	testRoundTrip(t, newTestS3Store(t, server.URL))
+}
+
+func TestS3StoreSendsContentType(t *testing.T) {
+	fake := newFakeS3("chat")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store := newTestS3Store(t, server.URL)
+	err := store.Put(context.Background(), "a b/c", strings.NewReader("x"), 1, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fake.types["a b/c"]; got != "image/png" {
+		t.Fatalf("stored content type %q, want image/png", got)
+	}
+}
+
+func TestS3StoreErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<Error>SlowDown</Error>", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	store := newTestS3Store(t, server.URL)
+	_, err := store.Get(context.Background(), "key")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get against a failing server: got %v, want a server error", err)
+	}
+	if !strings.Contains(err.Error(), "SlowDown") {
+		t.Fatalf("error %q does not carry the response body", err)
+	}
+}
+
+func TestNewS3StoreRequiresBucket(t *testing.T) {
+	if _, err := NewS3Store(S3Config{Endpoint: "http://localhost:9000"}); err == nil {
+		t.Fatal("NewS3Store without a bucket succeeded")
+	}
+}
@@ -0,0 +1,263 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// UserEvent is an event addressed to every connection of a single user
+type UserEvent struct {
+	UserID  string
+	Message *store.Message
+}
+
+// Bus carries real-time events from REST handlers and background workers to
+// the websocket hub, which is the only place that knows who is connected
+type Bus struct {
+	room    chan *store.Message
+	user    chan UserEvent
+	message chan *store.Message
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		room:    make(chan *store.Message, 256),
+		user:    make(chan UserEvent, 256),
+		message: make(chan *store.Message, 256),
+	}
+}
+
+// PublishRoom fans the event out to every connected member of message.Room
+func (b *Bus) PublishRoom(message *store.Message) {
+	b.room <- message
+}
+
+// PublishUser delivers the event to every connection of a single user
+func (b *Bus) PublishUser(userID string, message *store.Message) {
+	b.user <- UserEvent{UserID: userID, Message: message}
+}
+
+// PublishMessage hands a new chat message to the hub, which stores and
+// delivers it exactly like one sent over a connection
+func (b *Bus) PublishMessage(message *store.Message) {
+	b.message <- message
+}
+
+// Room returns the stream of room-wide events
+func (b *Bus) Room() <-chan *store.Message {
+	return b.room
+}
+
+// User returns the stream of user-addressed events
+func (b *Bus) User() <-chan UserEvent {
+	return b.user
+}
+
+// Messages returns the stream of chat messages sent on users' behalf
+func (b *Bus) Messages() <-chan *store.Message {
+	return b.message
+}
+
+// New builds an event frame. Like the room list sent on register, the payload
+// travels JSON-encoded in the message content.
+func New(eventType, room string, payload any) *store.Message {
+	content, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", eventType, err)
+	}
+
+	return &store.Message{
+		Type:    eventType,
+		Room:    room,
+		Content: string(content),
+		Sender:  "system",
+		Time:    time.Now(),
+	}
+}
+
+// Read tells the other members of a room how far a user has read, so clients
+// can render "seen by"
+func Read(state *store.ReadState) *store.Message {
+	return New("read", state.RoomID, map[string]any{
+		"user_id":    state.UserID,
+		"message_id": state.LastReadMessageID,
+		"seq":        state.LastReadSeq,
+	})
+}
+
+// MessageUpdated carries the new version of an edited message so clients can
+// replace it in place
+func MessageUpdated(message *store.Message) *store.Message {
+	return New("message_updated", message.Room, message)
+}
+
+// MessageDeleted tells clients to remove a message from the timeline
+func MessageDeleted(message *store.Message) *store.Message {
+	return New("message_deleted", message.Room, map[string]any{
+		"id":         message.ID,
+		"room":       message.Room,
+		"deleted_at": message.DeletedAt,
+	})
+}
+
+// ThreadReply notifies a thread participant that someone replied
+func ThreadReply(reply *store.Message) *store.Message {
+	return New("thread_reply", reply.Room, reply)
+}
+
+// Thread answers an open_thread command with the root message and a page of
+// its replies
+func Thread(parent *store.Message, replies *store.MessagePage) *store.Message {
+	return New("thread", parent.Room, map[string]any{
+		"parent":  parent,
+		"replies": replies,
+	})
+}
+
+// ReactionAdded and ReactionRemoved keep reaction counts live on every client
+func ReactionAdded(message *store.Message, userID, emoji string) *store.Message {
+	return reaction("reaction_added", message, userID, emoji)
+}
+
+func ReactionRemoved(message *store.Message, userID, emoji string) *store.Message {
+	return reaction("reaction_removed", message, userID, emoji)
+}
+
+func reaction(eventType string, message *store.Message, userID, emoji string) *store.Message {
+	return New(eventType, message.Room, map[string]any{
+		"message_id": message.ID,
+		"user_id":    userID,
+		"emoji":      emoji,
+	})
+}
+
+// Mention alerts a user that a message called them out
+func Mention(mention *store.Mention) *store.Message {
+	return New("mention", mention.Message.Room, mention)
+}
+
+// MessagePinned tells a room that a message was pinned to its top
+func MessagePinned(pin *store.Pin) *store.Message {
+	return New("message_pinned", pin.Message.Room, pin)
+}
+
+// MessageUnpinned tells a room that a pin was taken down
+func MessageUnpinned(message *store.Message, userID string) *store.Message {
+	return New("message_unpinned", message.Room, map[string]any{
+		"message_id":  message.ID,
+		"unpinned_by": userID,
+	})
+}
+
+// Reminder brings a message back to the attention of the user who asked
+func Reminder(reminder *store.Reminder) *store.Message {
+	return New("reminder", reminder.Message.Room, reminder)
+}
+
+// RoomInvitation tells a user they were invited to a room
+func RoomInvitation(invitation *store.Invitation) *store.Message {
+	return New("room_invitation", invitation.RoomID, invitation)
+}
+
+// JoinRequestAnswered tells a user whether a moderator let them in
+func JoinRequestAnswered(roomID string, approved bool) *store.Message {
+	return New("join_request_answered", roomID, map[string]any{
+		"room_id":  roomID,
+		"approved": approved,
+	})
+}
+
+// MemberRoleChanged tells a room that one of its members has a new role
+func MemberRoleChanged(roomID, userID, role, changedBy string) *store.Message {
+	return New("member_role_changed", roomID, map[string]any{
+		"user_id":    userID,
+		"role":       role,
+		"changed_by": changedBy,
+	})
+}
+
+// Moderation tells a room that a moderator kicked, banned or muted someone,
+// or lifted a ban or mute
+func Moderation(entry *store.ModerationEntry) *store.Message {
+	return New("moderation", entry.RoomID, entry)
+}
+
+// RoomOpened tells a user about a direct conversation someone started with
+// them. Participants lists everyone in it but the recipient.
+func RoomOpened(room *store.Room) *store.Message {
+	return New("room_opened", room.ID, room)
+}
+
+// AnnounceRoomOpened sends RoomOpened to everyone in a new direct
+// conversation besides the user who opened it, each with their own view of
+// who else is in it
+func AnnounceRoomOpened(bus *Bus, room *store.Room, openedBy string) {
+	everyone := append([]string{openedBy}, room.Participants...)
+	for _, recipient := range room.Participants {
+		view := *room
+		view.Participants = []string{}
+		for _, participant := range everyone {
+			if participant != recipient {
+				view.Participants = append(view.Participants, participant)
+			}
+		}
+		bus.PublishUser(recipient, RoomOpened(&view))
+	}
+}
+
+// RoomUpdated tells a room that its name, topic or settings changed
+func RoomUpdated(room *store.Room) *store.Message {
+	return New("room_updated", room.ID, room)
+}
+
+// MessageRejected tells a sender that a content filter stopped their message
+func MessageRejected(room, filter, reason string) *store.Message {
+	return New("message_rejected", room, map[string]any{
+		"filter": filter,
+		"reason": reason,
+	})
+}
+
+// MessageHeld tells a sender, and the room's reviewers, that a message is
+// waiting for review
+func MessageHeld(held *store.HeldMessage) *store.Message {
+	return New("message_held", held.RoomID, held)
+}
+
+// HeldMessageReviewed tells a sender, and the room's reviewers, whether a
+// held message was let through
+func HeldMessageReviewed(held *store.HeldMessage) *store.Message {
+	return New("held_message_reviewed", held.RoomID, held)
+}
+
+// ReportCreated tells a room's moderators about a new report
+func ReportCreated(report *store.Report) *store.Message {
+	return New("report_created", report.RoomID, report)
+}
+
+// ReportUpdated tells a room's moderators that a report was claimed,
+// released or resolved, so their queues stay in step
+func ReportUpdated(report *store.Report) *store.Message {
+	return New("report_updated", report.RoomID, report)
+}
+
+// RateLimited tells a client it is going too fast: the frame it sent was
+// dropped and can be tried again after the wait. Reason says which limit it
+// ran into.
+func RateLimited(room, reason string, retryAfter time.Duration) *store.Message {
+	return New("rate_limited", room, map[string]any{
+		"reason":         reason,
+		"retry_after_ms": retryAfter.Milliseconds(),
+	})
+}
+
+// RoomDeleted tells a former member that a room is gone
+func RoomDeleted(roomID, deletedBy string) *store.Message {
+	return New("room_deleted", roomID, map[string]any{
+		"room_id":    roomID,
+		"deleted_by": deletedBy,
+	})
+}
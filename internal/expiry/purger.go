@@ -0,0 +1,84 @@
+// Package expiry removes self-destructing messages once their time is up.
+package expiry
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/blob"
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+const (
+	// How often expired messages are looked for. Reads hide them right
+	// away, so this only bounds how long they stay on disk.
+	purgeInterval = 30 * time.Second
+
+	// Messages deleted per transaction
+	batchSize = 500
+)
+
+// Purger deletes expired messages with their files and tells the rooms
+type Purger struct {
+	messageStore store.MessageStore
+	blobStore    blob.BlobStore
+	events       *events.Bus
+}
+
+func NewPurger(messageStore store.MessageStore, blobStore blob.BlobStore, bus *events.Bus) *Purger {
+	return &Purger{
+		messageStore: messageStore,
+		blobStore:    blobStore,
+		events:       bus,
+	}
+}
+
+// Start purges in the background for as long as the process runs
+func (p *Purger) Start() {
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			p.purge()
+		}
+	}()
+}
+
+func (p *Purger) purge() {
+	ctx := context.Background()
+
+	for {
+		messages, err := p.messageStore.PurgeExpiredMessages(ctx, batchSize)
+		if err != nil {
+			log.Printf("Error purging expired messages: %v", err)
+			return
+		}
+
+		now := time.Now()
+		for _, message := range messages {
+			message.DeletedAt = &now
+			p.events.PublishRoom(events.MessageDeleted(message))
+			p.deleteFiles(ctx, message)
+		}
+
+		if len(messages) < batchSize {
+			return
+		}
+	}
+}
+
+func (p *Purger) deleteFiles(ctx context.Context, message *store.Message) {
+	for _, attachment := range message.Attachments {
+		for _, key := range []string{attachment.BlobKey, attachment.ThumbnailKey} {
+			if key == "" {
+				continue
+			}
+			if err := p.blobStore.Delete(ctx, key); err != nil {
+				log.Printf("Error deleting %s: %v", key, err)
+			}
+		}
+	}
+}
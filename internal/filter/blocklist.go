@@ -0,0 +1,127 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// Longest pattern or word a rule can hold, like the column
+const maxRuleLength = 255
+
+// Blocklist matches a room's blocked words and patterns against what a
+// message says
+type Blocklist struct{}
+
+func NewBlocklist() *Blocklist {
+	return &Blocklist{}
+}
+
+func (b *Blocklist) Name() string {
+	return "blocklist"
+}
+
+// Check applies the strongest action among the rules a message breaks. When
+// that is masking, every masking match is blanked out.
+func (b *Blocklist) Check(ctx context.Context, message *store.Message, rules []*store.FilterRule) (Verdict, error) {
+	var matched []*store.FilterRule
+	var masks []*regexp.Regexp
+	for _, rule := range rules {
+		if rule.Kind != store.RuleWord && rule.Kind != store.RulePattern {
+			continue
+		}
+
+		pattern, err := compileRule(rule)
+		if err != nil {
+			// Rules are checked when added, so this is a bad row
+			log.Printf("Error compiling filter rule %s: %v", rule.ID, err)
+			continue
+		}
+		if !pattern.MatchString(message.Content) {
+			continue
+		}
+
+		matched = append(matched, rule)
+		if rule.Action == store.FilterMask {
+			masks = append(masks, pattern)
+		}
+	}
+
+	action := strongest(matched)
+	if action == Allow {
+		return Verdict{Action: Allow}, nil
+	}
+
+	if action == Mask {
+		for _, pattern := range masks {
+			message.Content = pattern.ReplaceAllStringFunc(message.Content, blank)
+		}
+	}
+	return Verdict{Action: action, Reason: "Message contains words that are not allowed here"}, nil
+}
+
+// compileRule turns a word or pattern rule into the expression it matches.
+// Words match whole and in any case; patterns are taken as written.
+func compileRule(rule *store.FilterRule) (*regexp.Regexp, error) {
+	if rule.Kind == store.RulePattern {
+		return regexp.Compile(rule.Value)
+	}
+
+	pattern := regexp.QuoteMeta(rule.Value)
+	first, _ := utf8.DecodeRuneInString(rule.Value)
+	last, _ := utf8.DecodeLastRuneInString(rule.Value)
+	if isWordRune(first) {
+		pattern = `\b` + pattern
+	}
+	if isWordRune(last) {
+		pattern += `\b`
+	}
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// isWordRune reports whether \b treats r as part of a word
+func isWordRune(r rune) bool {
+	return r < utf8.RuneSelf && (r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// blank replaces every character of s with an asterisk
+func blank(s string) string {
+	return strings.Repeat("*", utf8.RuneCountInString(s))
+}
+
+// NormalizeRule checks a rule before it is stored and puts its value in the
+// form the filters expect: words trimmed, domains lowercased and bare
+func NormalizeRule(rule *store.FilterRule) error {
+	if _, ok := ParseAction(rule.Action); !ok || rule.Action == Allow.String() {
+		return fmt.Errorf("invalid action: %s", rule.Action)
+	}
+
+	value := strings.TrimSpace(rule.Value)
+	switch rule.Kind {
+	case store.RuleWord:
+	case store.RulePattern:
+		if _, err := regexp.Compile(value); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	case store.RuleAllowDomain, store.RuleDenyDomain:
+		value = strings.Trim(strings.ToLower(value), ".")
+		value = strings.TrimPrefix(value, "*.")
+		if strings.ContainsAny(value, "/:@ ") {
+			return fmt.Errorf("invalid domain: %s", rule.Value)
+		}
+	default:
+		return fmt.Errorf("invalid kind: %s", rule.Kind)
+	}
+
+	if value == "" || len(value) > maxRuleLength {
+		return fmt.Errorf("value must be between 1 and %d characters", maxRuleLength)
+	}
+	rule.Value = value
+	return nil
+}
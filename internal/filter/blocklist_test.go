@@ -0,0 +1,124 @@
+package filter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+func rule(kind, value, action string) *store.FilterRule {
+	return &store.FilterRule{ID: kind + ":" + value, Kind: kind, Value: value, Action: action}
+}
+
+func TestBlocklist(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []*store.FilterRule
+		content string
+		action  Action
+		masked  string
+	}{
+		{
+			name:    "no rules",
+			content: "anything goes",
+			action:  Allow,
+			masked:  "anything goes",
+		},
+		{
+			name:    "word in any case",
+			rules:   []*store.FilterRule{rule(store.RuleWord, "darn", store.FilterReject)},
+			content: "well DARN it",
+			action:  Reject,
+		},
+		{
+			name:    "only whole words",
+			rules:   []*store.FilterRule{rule(store.RuleWord, "ass", store.FilterReject)},
+			content: "a classic passage",
+			action:  Allow,
+			masked:  "a classic passage",
+		},
+		{
+			name:    "words are not patterns",
+			rules:   []*store.FilterRule{rule(store.RuleWord, "a.c", store.FilterReject)},
+			content: "abc",
+			action:  Allow,
+			masked:  "abc",
+		},
+		{
+			name:    "mask keeps the length",
+			rules:   []*store.FilterRule{rule(store.RuleWord, "heck", store.FilterMask)},
+			content: "what the heck, Heck",
+			action:  Mask,
+			masked:  "what the ****, ****",
+		},
+		{
+			name:    "pattern",
+			rules:   []*store.FilterRule{rule(store.RulePattern, `\d{4}-\d{4}-\d{4}-\d{4}`, store.FilterHold)},
+			content: "card 1234-5678-9012-3456",
+			action:  Hold,
+		},
+		{
+			name: "strongest rule wins",
+			rules: []*store.FilterRule{
+				rule(store.RuleWord, "heck", store.FilterMask),
+				rule(store.RuleWord, "darn", store.FilterReject),
+			},
+			content: "heck and darn",
+			action:  Reject,
+		},
+		{
+			name:    "domain rules are not words",
+			rules:   []*store.FilterRule{rule(store.RuleDenyDomain, "example.com", store.FilterReject)},
+			content: "example.com",
+			action:  Allow,
+			masked:  "example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &store.Message{Content: tt.content}
+			verdict, err := NewBlocklist().Check(context.Background(), message, tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verdict.Action != tt.action {
+				t.Fatalf("action %s, want %s", verdict.Action, tt.action)
+			}
+			if tt.masked != "" && message.Content != tt.masked {
+				t.Fatalf("content %q, want %q", message.Content, tt.masked)
+			}
+		})
+	}
+}
+
+func TestNormalizeRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  *store.FilterRule
+		value string
+		ok    bool
+	}{
+		{"word is trimmed", rule(store.RuleWord, "  darn ", store.FilterReject), "darn", true},
+		{"domain is bare", rule(store.RuleAllowDomain, "*.Example.COM.", store.FilterMask), "example.com", true},
+		{"domain with a path", rule(store.RuleDenyDomain, "example.com/x", store.FilterReject), "", false},
+		{"bad pattern", rule(store.RulePattern, "(", store.FilterReject), "", false},
+		{"empty", rule(store.RuleWord, "   ", store.FilterReject), "", false},
+		{"unknown kind", rule("phrase", "x", store.FilterReject), "", false},
+		{"allow is not an action", rule(store.RuleWord, "x", "allow"), "", false},
+		{"unknown action", rule(store.RuleWord, "x", "shadowban"), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NormalizeRule(tt.rule)
+			if (err == nil) != tt.ok {
+				t.Fatalf("error %v, want ok=%v", err, tt.ok)
+			}
+			if tt.ok && tt.rule.Value != tt.value {
+				t.Fatalf("value %q, want %q", tt.rule.Value, tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// Past this the message goes out without a verdict from the classifier
+const classifierTimeout = 3 * time.Second
+
+// Classifier asks an external HTTP service about each message. It receives
+// a POST with a JSON body
+//
+//	{"room": "...", "sender": "...", "content": "..."}
+//
+// and answers with
+//
+//	{"action": "allow|mask|hold|reject", "reason": "...", "content": "..."}
+//
+// where content is the masked message and only needed for mask. The URL is
+// the operator's choice, so unlike the unfurler it may point at localhost.
+type Classifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewClassifier(url string) *Classifier {
+	return &Classifier{
+		url:    url,
+		client: &http.Client{Timeout: classifierTimeout},
+	}
+}
+
+func (c *Classifier) Name() string {
+	return "classifier"
+}
+
+type classifierRequest struct {
+	Room    string `json:"room"`
+	Sender  string `json:"sender"`
+	Content string `json:"content"`
+}
+
+type classifierResponse struct {
+	Action  string `json:"action"`
+	Reason  string `json:"reason"`
+	Content string `json:"content"`
+}
+
+func (c *Classifier) Check(ctx context.Context, message *store.Message, rules []*store.FilterRule) (Verdict, error) {
+	body, err := json.Marshal(classifierRequest{
+		Room:    message.Room,
+		Sender:  message.Sender,
+		Content: message.Content,
+	})
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return Verdict{}, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return Verdict{}, fmt.Errorf("classifier answered %s", response.Status)
+	}
+
+	var answer classifierResponse
+	if err := json.NewDecoder(response.Body).Decode(&answer); err != nil {
+		return Verdict{}, err
+	}
+
+	action, ok := ParseAction(answer.Action)
+	if !ok {
+		return Verdict{}, fmt.Errorf("classifier answered with unknown action %q", answer.Action)
+	}
+	if action == Mask {
+		if answer.Content == "" {
+			return Verdict{}, errors.New("classifier masked a message without sending the masked content")
+		}
+		message.Content = answer.Content
+	}
+
+	return Verdict{Action: action, Reason: answer.Reason}, nil
+}
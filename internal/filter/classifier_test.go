@@ -0,0 +1,164 @@
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// classifierServer answers every request with status and body, and hands
+// the request it got to the test
+func classifierServer(t *testing.T, status int, body string, got *classifierRequest) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if got != nil {
+			json.NewDecoder(r.Body).Decode(got)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClassifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		action  Action
+		reason  string
+		content string
+		fails   bool
+	}{
+		{
+			name:    "allow",
+			status:  http.StatusOK,
+			body:    `{"action": "allow"}`,
+			action:  Allow,
+			content: "you are all idiots",
+		},
+		{
+			name:    "reject with reason",
+			status:  http.StatusOK,
+			body:    `{"action": "reject", "reason": "Insult"}`,
+			action:  Reject,
+			reason:  "Insult",
+			content: "you are all idiots",
+		},
+		{
+			name:    "hold",
+			status:  http.StatusOK,
+			body:    `{"action": "hold", "reason": "Unsure"}`,
+			action:  Hold,
+			reason:  "Unsure",
+			content: "you are all idiots",
+		},
+		{
+			name:    "mask replaces the content",
+			status:  http.StatusOK,
+			body:    `{"action": "mask", "content": "you are all ******"}`,
+			action:  Mask,
+			content: "you are all ******",
+		},
+		{
+			name:   "mask without content",
+			status: http.StatusOK,
+			body:   `{"action": "mask"}`,
+			fails:  true,
+		},
+		{
+			name:   "unknown action",
+			status: http.StatusOK,
+			body:   `{"action": "shadowban"}`,
+			fails:  true,
+		},
+		{
+			name:   "server error",
+			status: http.StatusInternalServerError,
+			body:   `{"action": "reject"}`,
+			fails:  true,
+		},
+		{
+			name:   "not json",
+			status: http.StatusOK,
+			body:   `<html>`,
+			fails:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got classifierRequest
+			server := classifierServer(t, tt.status, tt.body, &got)
+
+			message := &store.Message{Room: "room", Sender: "alice", Content: "you are all idiots"}
+			verdict, err := NewClassifier(server.URL).Check(context.Background(), message, nil)
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("no error, verdict %+v", verdict)
+				}
+				if message.Content != "you are all idiots" {
+					t.Fatalf("a failed check changed the content to %q", message.Content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != (classifierRequest{Room: "room", Sender: "alice", Content: "you are all idiots"}) {
+				t.Fatalf("classifier was sent %+v", got)
+			}
+			if verdict.Action != tt.action || verdict.Reason != tt.reason {
+				t.Fatalf("verdict %+v, want %s %q", verdict, tt.action, tt.reason)
+			}
+			if message.Content != tt.content {
+				t.Fatalf("content %q, want %q", message.Content, tt.content)
+			}
+		})
+	}
+}
+
+func TestClassifierTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	classifier := NewClassifier(server.URL)
+	classifier.client.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err := classifier.Check(context.Background(), &store.Message{Content: "slow"}, nil)
+	if err == nil {
+		t.Fatal("a classifier that never answers gave a verdict")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("gave up after %s", elapsed)
+	}
+}
+
+func TestClassifierUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	if _, err := NewClassifier(url).Check(context.Background(), &store.Message{Content: "hi"}, nil); err == nil {
+		t.Fatal("an unreachable classifier gave a verdict")
+	}
+}
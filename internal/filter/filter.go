@@ -0,0 +1,140 @@
+// Package filter runs new messages through content filters before they are
+// sent: per-room blocklists and link rules, spam detection and an optional
+// external classifier. Each filter lets a message through, masks part of
+// it, holds it for a moderator or rejects it.
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// Action is what a filter decided. Actions are ordered: when filters
+// disagree, the later action wins.
+type Action int
+
+const (
+	Allow Action = iota
+	Mask
+	Hold
+	Reject
+)
+
+var actionNames = map[Action]string{
+	Allow:  "allow",
+	Mask:   store.FilterMask,
+	Hold:   store.FilterHold,
+	Reject: store.FilterReject,
+}
+
+// ParseAction reads an action name as stored with a rule
+func ParseAction(name string) (Action, bool) {
+	for action, actionName := range actionNames {
+		if actionName == name {
+			return action, true
+		}
+	}
+	return Allow, false
+}
+
+func (a Action) String() string {
+	return actionNames[a]
+}
+
+func (a Action) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// Verdict is the outcome of filtering a message
+type Verdict struct {
+	Action Action `json:"action"`
+	Filter string `json:"filter,omitempty"` // Which filter decided
+	Reason string `json:"reason,omitempty"` // Shown to the sender and to moderators
+
+	original string // The content before any filter masked it
+}
+
+// Filter checks a message. Masking filters change the message content in
+// place. rules are the filter rules of the message's room.
+type Filter interface {
+	Name() string
+	Check(ctx context.Context, message *store.Message, rules []*store.FilterRule) (Verdict, error)
+}
+
+// Recorder is a filter that keeps track of what users send. Checking a
+// message doesn't count it as sent, since a later filter or the room's slow
+// mode may still turn it down; Record is told once it really goes out.
+type Recorder interface {
+	Record(message *store.Message)
+}
+
+// Pipeline runs a message through a list of filters in order
+type Pipeline struct {
+	filterStore store.FilterStore
+	filters     []Filter
+}
+
+func NewPipeline(filterStore store.FilterStore, filters ...Filter) *Pipeline {
+	return &Pipeline{
+		filterStore: filterStore,
+		filters:     filters,
+	}
+}
+
+// Run filters a message and returns the strongest verdict. It stops at the
+// first filter that holds or rejects; masks from earlier filters stay
+// applied. A filter that fails is skipped, so a broken classifier doesn't
+// stop a room from talking.
+func (p *Pipeline) Run(ctx context.Context, message *store.Message) Verdict {
+	rules, err := p.filterStore.GetFilterRules(ctx, message.Room)
+	if err != nil {
+		log.Printf("Error loading filter rules of room %s: %v", message.Room, err)
+	}
+
+	result := Verdict{Action: Allow}
+	original := message.Content
+	for _, filter := range p.filters {
+		verdict, err := filter.Check(ctx, message, rules)
+		if err != nil {
+			log.Printf("Error running %s filter: %v", filter.Name(), err)
+			continue
+		}
+		if verdict.Action > result.Action {
+			verdict.Filter = filter.Name()
+			result = verdict
+		}
+		if result.Action >= Hold {
+			break
+		}
+	}
+
+	result.original = original
+	return result
+}
+
+// Accepted tells the filters that keep track of what users send that a
+// message passed the verdict Run gave it and was sent or held. They see it
+// as the user wrote it, before any masking.
+func (p *Pipeline) Accepted(message *store.Message, verdict Verdict) {
+	sent := *message
+	sent.Content = verdict.original
+	for _, filter := range p.filters {
+		if recorder, ok := filter.(Recorder); ok {
+			recorder.Record(&sent)
+		}
+	}
+}
+
+// strongest returns the strongest action of the rules that matched
+func strongest(matched []*store.FilterRule) Action {
+	result := Allow
+	for _, rule := range matched {
+		if action, ok := ParseAction(rule.Action); ok && action > result {
+			result = action
+		}
+	}
+	return result
+}
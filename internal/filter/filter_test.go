@@ -0,0 +1,95 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// fakeFilterStore serves fixed rules. Methods the pipeline doesn't use are
+// left to the embedded nil interface.
+type fakeFilterStore struct {
+	store.FilterStore
+	rules []*store.FilterRule
+	err   error
+}
+
+func (f *fakeFilterStore) GetFilterRules(ctx context.Context, roomID string) ([]*store.FilterRule, error) {
+	return f.rules, f.err
+}
+
+func TestPipeline(t *testing.T) {
+	rules := []*store.FilterRule{
+		rule(store.RuleWord, "heck", store.FilterMask),
+		rule(store.RuleWord, "darn", store.FilterHold),
+		rule(store.RuleDenyDomain, "spam.test", store.FilterReject),
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		action  Action
+		filter  string
+		masked  string
+	}{
+		{"clean", "hello", Allow, "", "hello"},
+		{"masked", "heck yes", Mask, "blocklist", "**** yes"},
+		{"stronger later verdict", "heck, https://spam.test", Reject, "links", ""},
+		{"stops at hold", "darn https://spam.test", Hold, "blocklist", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := NewPipeline(&fakeFilterStore{rules: rules}, NewSpam(), NewBlocklist(), NewLinks())
+			message := &store.Message{Room: "room", Sender: "alice", Content: tt.content}
+
+			verdict := pipeline.Run(context.Background(), message)
+			if verdict.Action != tt.action || verdict.Filter != tt.filter {
+				t.Fatalf("verdict %+v, want %s from %q", verdict, tt.action, tt.filter)
+			}
+			if tt.action == Mask && message.Content != tt.masked {
+				t.Fatalf("content %q, want %q", message.Content, tt.masked)
+			}
+		})
+	}
+}
+
+func TestPipelineSkipsBrokenFilters(t *testing.T) {
+	server := classifierServer(t, http.StatusBadGateway, "", nil)
+	pipeline := NewPipeline(&fakeFilterStore{err: errors.New("database is down")}, NewBlocklist(), NewClassifier(server.URL))
+
+	verdict := pipeline.Run(context.Background(), &store.Message{Room: "room", Content: "hello"})
+	if verdict.Action != Allow {
+		t.Fatalf("verdict %+v, want allow when filters fail", verdict)
+	}
+}
+
+func TestPipelineRecordsAcceptedMessagesAsWritten(t *testing.T) {
+	ctx := context.Background()
+	spam := NewSpam()
+	pipeline := NewPipeline(&fakeFilterStore{rules: []*store.FilterRule{
+		rule(store.RuleWord, "heck", store.FilterMask),
+	}}, spam, NewBlocklist())
+
+	send := func() Verdict {
+		message := &store.Message{Room: "room", Sender: "alice", Content: "heck"}
+		verdict := pipeline.Run(ctx, message)
+		if verdict.Action < Hold {
+			pipeline.Accepted(message, verdict)
+		}
+		return verdict
+	}
+
+	// Masking doesn't change what the spam filter counts
+	for i := 0; i < spamRepeats-1; i++ {
+		if verdict := send(); verdict.Action != Mask {
+			t.Fatalf("message %d: verdict %+v, want mask", i+1, verdict)
+		}
+	}
+	if verdict := send(); verdict.Action != Reject || verdict.Filter != "spam" {
+		t.Fatalf("repeat: verdict %+v, want reject from spam", verdict)
+	}
+}
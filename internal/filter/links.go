@@ -0,0 +1,113 @@
+package filter
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// What a masked link is replaced with
+const maskedLink = "[link removed]"
+
+// The same links the unfurler previews
+var linkPattern = regexp.MustCompile("https?://[^\\s<>\"'`]+")
+
+// Links checks the links in a message against a room's allowed and denied
+// domains. Domains cover their subdomains. Once a room allows any domain,
+// links to everywhere else break the allow rules.
+type Links struct{}
+
+func NewLinks() *Links {
+	return &Links{}
+}
+
+func (l *Links) Name() string {
+	return "links"
+}
+
+func (l *Links) Check(ctx context.Context, message *store.Message, rules []*store.FilterRule) (Verdict, error) {
+	var allowed, denied []*store.FilterRule
+	for _, rule := range rules {
+		switch rule.Kind {
+		case store.RuleAllowDomain:
+			allowed = append(allowed, rule)
+		case store.RuleDenyDomain:
+			denied = append(denied, rule)
+		}
+	}
+	if len(allowed) == 0 && len(denied) == 0 {
+		return Verdict{Action: Allow}, nil
+	}
+
+	var matched []*store.FilterRule
+	masked := make(map[string]bool)
+	for _, link := range findLinks(message.Content) {
+		host := linkHost(link)
+		if host == "" {
+			continue
+		}
+
+		var broken []*store.FilterRule
+		for _, rule := range denied {
+			if inDomain(host, rule.Value) {
+				broken = append(broken, rule)
+			}
+		}
+		if len(allowed) > 0 && !anyDomain(host, allowed) {
+			broken = append(broken, allowed...)
+		}
+
+		matched = append(matched, broken...)
+		if len(broken) > 0 && strongest(broken) == Mask {
+			masked[link] = true
+		}
+	}
+
+	action := strongest(matched)
+	if action == Allow {
+		return Verdict{Action: Allow}, nil
+	}
+
+	if action == Mask {
+		for link := range masked {
+			message.Content = strings.ReplaceAll(message.Content, link, maskedLink)
+		}
+	}
+	return Verdict{Action: action, Reason: "Message links to a site that is not allowed here"}, nil
+}
+
+// findLinks returns the http(s) URLs in a message, without the punctuation
+// that usually follows one in a sentence
+func findLinks(content string) []string {
+	var links []string
+	for _, match := range linkPattern.FindAllString(content, -1) {
+		links = append(links, strings.TrimRight(match, ".,;:!?)]*_"))
+	}
+	return links
+}
+
+// linkHost returns the lowercased host of a link, or "" if it has none
+func linkHost(link string) string {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+}
+
+// inDomain reports whether host is domain or one of its subdomains
+func inDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func anyDomain(host string, rules []*store.FilterRule) bool {
+	for _, rule := range rules {
+		if inDomain(host, rule.Value) {
+			return true
+		}
+	}
+	return false
+}
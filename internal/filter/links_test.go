@@ -0,0 +1,83 @@
+package filter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+func TestLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []*store.FilterRule
+		content string
+		action  Action
+		masked  string
+	}{
+		{
+			name:    "no domain rules",
+			rules:   []*store.FilterRule{rule(store.RuleWord, "darn", store.FilterReject)},
+			content: "see https://anywhere.test",
+			action:  Allow,
+		},
+		{
+			name:    "denied domain",
+			rules:   []*store.FilterRule{rule(store.RuleDenyDomain, "spam.test", store.FilterReject)},
+			content: "buy at https://spam.test/deal",
+			action:  Reject,
+		},
+		{
+			name:    "denied domain covers subdomains",
+			rules:   []*store.FilterRule{rule(store.RuleDenyDomain, "spam.test", store.FilterHold)},
+			content: "https://shop.SPAM.test.",
+			action:  Hold,
+		},
+		{
+			name:    "similar names are other domains",
+			rules:   []*store.FilterRule{rule(store.RuleDenyDomain, "spam.test", store.FilterReject)},
+			content: "https://notspam.test and https://spam.test.example",
+			action:  Allow,
+		},
+		{
+			name:    "allowed domains only",
+			rules:   []*store.FilterRule{rule(store.RuleAllowDomain, "docs.test", store.FilterReject)},
+			content: "https://docs.test/a is fine",
+			action:  Allow,
+		},
+		{
+			name:    "link outside the allowed domains",
+			rules:   []*store.FilterRule{rule(store.RuleAllowDomain, "docs.test", store.FilterMask)},
+			content: "read https://docs.test/a, not https://other.test/b.",
+			action:  Mask,
+			masked:  "read https://docs.test/a, not [link removed].",
+		},
+		{
+			name:    "text without links",
+			rules:   []*store.FilterRule{rule(store.RuleAllowDomain, "docs.test", store.FilterReject)},
+			content: "docs.test is down",
+			action:  Allow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &store.Message{Content: tt.content}
+			verdict, err := NewLinks().Check(context.Background(), message, tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verdict.Action != tt.action {
+				t.Fatalf("action %s, want %s", verdict.Action, tt.action)
+			}
+
+			want := tt.masked
+			if want == "" {
+				want = tt.content
+			}
+			if message.Content != want {
+				t.Fatalf("content %q, want %q", message.Content, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package filter
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+const (
+	// Sending the same thing this many times within the window is spam
+	spamRepeats = 3
+	spamWindow  = time.Minute
+)
+
+// Spam rejects a message a user already sent to the same room several times
+// in the last minute. Case and spacing don't make a message different. Only
+// messages that went out count, so it runs first, on what the user wrote.
+type Spam struct {
+	mu     sync.Mutex
+	recent map[uint64][]time.Time // When each message was sent, by spamKey
+	pruned time.Time
+}
+
+func NewSpam() *Spam {
+	return &Spam{
+		recent: make(map[uint64][]time.Time),
+		pruned: time.Now(),
+	}
+}
+
+func (s *Spam) Name() string {
+	return "spam"
+}
+
+func (s *Spam) Check(ctx context.Context, message *store.Message, rules []*store.FilterRule) (Verdict, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.pruned) > spamWindow {
+		s.prune(now)
+	}
+
+	key := spamKey(message)
+	times, ok := s.recent[key]
+	if !ok {
+		return Verdict{Action: Allow}, nil
+	}
+
+	sent := recentTimes(times, now)
+	s.recent[key] = sent
+	if len(sent) >= spamRepeats-1 {
+		return Verdict{Action: Reject, Reason: "You already sent this message several times"}, nil
+	}
+
+	return Verdict{Action: Allow}, nil
+}
+
+// Record counts a message that went out
+func (s *Spam) Record(message *store.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	key := spamKey(message)
+	s.recent[key] = append(recentTimes(s.recent[key], now), now)
+}
+
+// spamKey identifies a message by sender, room and normalized content
+func spamKey(message *store.Message) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(message.Sender))
+	hash.Write([]byte{0})
+	hash.Write([]byte(message.Room))
+	hash.Write([]byte{0})
+	hash.Write([]byte(strings.ToLower(strings.Join(strings.Fields(message.Content), " "))))
+	return hash.Sum64()
+}
+
+// recentTimes drops the times that fell out of the window
+func recentTimes(times []time.Time, now time.Time) []time.Time {
+	kept := times[:0]
+	for _, t := range times {
+		if now.Sub(t) < spamWindow {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+func (s *Spam) prune(now time.Time) {
+	for key, times := range s.recent {
+		if times = recentTimes(times, now); len(times) == 0 {
+			delete(s.recent, key)
+		} else {
+			s.recent[key] = times
+		}
+	}
+	s.pruned = now
+}
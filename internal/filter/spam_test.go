@@ -0,0 +1,85 @@
+package filter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+func TestSpam(t *testing.T) {
+	hello := &store.Message{Sender: "alice", Room: "room", Content: "hello"}
+
+	tests := []struct {
+		name     string
+		sent     []*store.Message // Recorded as sent before the check
+		checked  []*store.Message // Only checked, then turned down elsewhere
+		message  *store.Message
+		rejected bool
+	}{
+		{
+			name:    "first time",
+			message: hello,
+		},
+		{
+			name:    "second time",
+			sent:    []*store.Message{hello},
+			message: hello,
+		},
+		{
+			name:     "third time",
+			sent:     []*store.Message{hello, hello},
+			message:  hello,
+			rejected: true,
+		},
+		{
+			name:     "case and spacing don't matter",
+			sent:     []*store.Message{hello, {Sender: "alice", Room: "room", Content: "  HELLO "}},
+			message:  &store.Message{Sender: "alice", Room: "room", Content: "Hello"},
+			rejected: true,
+		},
+		{
+			name:    "messages turned down don't count",
+			checked: []*store.Message{hello, hello, hello},
+			message: hello,
+		},
+		{
+			name:    "other senders",
+			sent:    []*store.Message{hello, hello},
+			message: &store.Message{Sender: "bob", Room: "room", Content: "hello"},
+		},
+		{
+			name:    "other rooms",
+			sent:    []*store.Message{hello, hello},
+			message: &store.Message{Sender: "alice", Room: "lobby", Content: "hello"},
+		},
+		{
+			name:    "other content",
+			sent:    []*store.Message{hello, hello},
+			message: &store.Message{Sender: "alice", Room: "room", Content: "hello again"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			spam := NewSpam()
+			for _, message := range tt.sent {
+				spam.Record(message)
+			}
+			for _, message := range tt.checked {
+				if _, err := spam.Check(ctx, message, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			verdict, err := spam.Check(ctx, tt.message, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rejected := verdict.Action == Reject; rejected != tt.rejected {
+				t.Fatalf("rejected %v, want %v", rejected, tt.rejected)
+			}
+		})
+	}
+}
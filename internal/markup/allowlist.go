@@ -0,0 +1,89 @@
+package markup
+
+import (
+	"html"
+	"net/url"
+	"strings"
+)
+
+// The only tags rendered HTML may contain, each with the attributes it may
+// carry. Anything else is dropped by the writer, whatever the parser asks for.
+var allowedTags = map[string]map[string]bool{
+	"p":      {},
+	"br":     {},
+	"strong": {},
+	"em":     {},
+	"code":   {"class": true},
+	"pre":    {},
+	"ul":     {},
+	"ol":     {},
+	"li":     {},
+	"a":      {"href": true, "rel": true, "target": true},
+}
+
+// Tags without content or a closing tag
+var voidTags = map[string]bool{
+	"br": true,
+}
+
+// Link targets that can't run script in the reader's browser
+var allowedSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+// safeURL reports whether a link target may be rendered as an href
+func safeURL(raw string) bool {
+	if raw == "" || strings.ContainsAny(raw, " \t\n\"'<>`") {
+		return false
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return allowedSchemes[strings.ToLower(u.Scheme)]
+}
+
+// writer builds HTML through the allowlist: text is always escaped, tags and
+// attributes that are not allowed are left out, and hrefs must be safe.
+type writer struct {
+	b strings.Builder
+}
+
+// open writes a start tag; attrs alternate between names and values
+func (w *writer) open(tag string, attrs ...string) bool {
+	allowedAttrs, ok := allowedTags[tag]
+	if !ok {
+		return false
+	}
+
+	w.b.WriteString("<" + tag)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		name, value := attrs[i], attrs[i+1]
+		if !allowedAttrs[name] {
+			continue
+		}
+		if name == "href" && !safeURL(value) {
+			continue
+		}
+		w.b.WriteString(" " + name + `="` + html.EscapeString(value) + `"`)
+	}
+	w.b.WriteString(">")
+	return true
+}
+
+func (w *writer) close(tag string) {
+	if _, ok := allowedTags[tag]; ok && !voidTags[tag] {
+		w.b.WriteString("</" + tag + ">")
+	}
+}
+
+func (w *writer) text(s string) {
+	w.b.WriteString(html.EscapeString(s))
+}
+
+func (w *writer) String() string {
+	return w.b.String()
+}
@@ -0,0 +1,105 @@
+package markup
+
+import "testing"
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		safe bool
+	}{
+		{"https://a.test/path?q=1#top", true},
+		{"http://a.test", true},
+		{"HTTPS://A.TEST", true},
+		{"mailto:a@a.test", true},
+		{"", false},
+		{"javascript:alert(1)", false},
+		{"JaVaScRiPt:alert(1)", false},
+		{"data:text/html,<script>alert(1)</script>", false},
+		{"data:image/png;base64,iVBORw0KGgo=", false},
+		{"vbscript:msgbox", false},
+		{"file:///etc/passwd", false},
+		{"//a.test/x", false},
+		{"/relative", false},
+		{"java\tscript:alert(1)", false},
+		{" https://a.test", false},
+		{`https://a.test/"onmouseover="x`, false},
+		{"https://a.test/'x'", false},
+		{"https://a.test/<x>", false},
+		{"https://a.test/`x`", false},
+		{"https://a.test/\nx", false},
+		{"https://a.test/%zz", false},
+	}
+
+	for _, tt := range tests {
+		if got := safeURL(tt.url); got != tt.safe {
+			t.Errorf("safeURL(%q) = %v, want %v", tt.url, got, tt.safe)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w *writer)
+		html  string
+	}{
+		{
+			name: "disallowed tag",
+			write: func(w *writer) {
+				w.open("script")
+				w.text("alert(1)")
+				w.close("script")
+			},
+			html: "alert(1)",
+		},
+		{
+			name: "disallowed attribute",
+			write: func(w *writer) {
+				w.open("p", "onclick", "alert(1)", "style", "x")
+				w.close("p")
+			},
+			html: "<p></p>",
+		},
+		{
+			name: "unsafe href",
+			write: func(w *writer) {
+				w.open("a", "href", "javascript:alert(1)", "rel", "nofollow")
+				w.close("a")
+			},
+			html: `<a rel="nofollow"></a>`,
+		},
+		{
+			name: "attribute value is escaped",
+			write: func(w *writer) {
+				w.open("code", "class", `x" onmouseover="alert(1)`)
+				w.close("code")
+			},
+			html: `<code class="x&#34; onmouseover=&#34;alert(1)"></code>`,
+		},
+		{
+			name: "text is escaped",
+			write: func(w *writer) {
+				w.text(`<img src=x onerror="alert('1')">&`)
+			},
+			html: "&lt;img src=x onerror=&#34;alert(&#39;1&#39;)&#34;&gt;&amp;",
+		},
+		{
+			name: "void tag",
+			write: func(w *writer) {
+				w.open("br")
+				w.close("br")
+			},
+			html: "<br>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &writer{}
+			tt.write(w)
+			if got := w.String(); got != tt.html {
+				t.Fatalf("got %s, want %s", got, tt.html)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+// Package markup renders the markdown subset chat messages can be written in.
+package markup
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	bulletItem   = regexp.MustCompile(`^\s{0,3}[-*+]\s+(.*)$`)
+	numberedItem = regexp.MustCompile(`^\s{0,3}\d{1,9}[.)]\s+(.*)$`)
+	fenceLang    = regexp.MustCompile(`^[A-Za-z0-9_+#-]{1,32}$`)
+)
+
+// Characters a backslash turns back into plain text
+const escapable = "\\`*_[]()#+-.!<>"
+
+// Render turns markdown into HTML. Supported are fenced code blocks, bullet
+// and numbered lists, paragraphs, inline code, links, bold and italic; raw
+// HTML in the source is shown as text.
+func Render(source string) string {
+	w := &writer{}
+	lines := strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case isFence(line):
+			i = renderCode(w, lines, i)
+		case listTag(line) != "":
+			i = renderList(w, lines, i)
+		default:
+			i = renderParagraph(w, lines, i)
+		}
+	}
+
+	return w.String()
+}
+
+func isFence(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "```")
+}
+
+// listItem returns the list a line is an item of, ul or ol, and its text
+func listItem(line string) (string, string) {
+	if m := bulletItem.FindStringSubmatch(line); m != nil {
+		return "ul", m[1]
+	}
+	if m := numberedItem.FindStringSubmatch(line); m != nil {
+		return "ol", m[1]
+	}
+	return "", ""
+}
+
+func listTag(line string) string {
+	tag, _ := listItem(line)
+	return tag
+}
+
+// renderCode writes the fenced block starting at lines[start]; an unclosed
+// fence runs to the end of the message
+func renderCode(w *writer, lines []string, start int) int {
+	lang := strings.TrimSpace(strings.TrimSpace(lines[start])[3:])
+
+	i := start + 1
+	var body []string
+	for ; i < len(lines) && !isFence(lines[i]); i++ {
+		body = append(body, lines[i])
+	}
+
+	w.open("pre")
+	if fenceLang.MatchString(lang) {
+		w.open("code", "class", "language-"+lang)
+	} else {
+		w.open("code")
+	}
+	w.text(strings.Join(body, "\n"))
+	w.close("code")
+	w.close("pre")
+
+	return i + 1
+}
+
+func renderList(w *writer, lines []string, start int) int {
+	tag := listTag(lines[start])
+
+	w.open(tag)
+	i := start
+	for ; i < len(lines); i++ {
+		itemTag, text := listItem(lines[i])
+		if itemTag != tag {
+			break
+		}
+		w.open("li")
+		renderInline(w, text, true)
+		w.close("li")
+	}
+	w.close(tag)
+
+	return i
+}
+
+// renderParagraph writes lines up to the next blank line or other block,
+// keeping the line breaks
+func renderParagraph(w *writer, lines []string, start int) int {
+	w.open("p")
+	i := start
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if i > start && (strings.TrimSpace(line) == "" || isFence(line) || listTag(line) != "") {
+			break
+		}
+		if i > start {
+			w.open("br")
+		}
+		renderInline(w, strings.TrimSpace(line), true)
+	}
+	w.close("p")
+
+	return i
+}
+
+// renderInline writes the spans of a single block. Link text is rendered
+// with links off so links never nest.
+func renderInline(w *writer, s string, links bool) {
+	var plain strings.Builder
+	flush := func() {
+		w.text(plain.String())
+		plain.Reset()
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(escapable, s[i+1]) >= 0:
+			plain.WriteByte(s[i+1])
+			i += 2
+			continue
+
+		case c == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end > 0 {
+				flush()
+				w.open("code")
+				w.text(s[i+1 : i+1+end])
+				w.close("code")
+				i += end + 2
+				continue
+			}
+
+		case c == '[' && links:
+			if text, target, n, ok := parseLink(s[i:]); ok {
+				flush()
+				writeLink(w, target, func() { renderInline(w, text, false) })
+				i += n
+				continue
+			}
+
+		case c == '*' || c == '_':
+			if inner, n, strong, ok := parseEmphasis(s, i); ok {
+				flush()
+				tag := "em"
+				if strong {
+					tag = "strong"
+				}
+				w.open(tag)
+				renderInline(w, inner, links)
+				w.close(tag)
+				i += n
+				continue
+			}
+
+		case c == 'h' && links && (i == 0 || s[i-1] == ' ' || s[i-1] == '('):
+			if target := autolink(s[i:]); target != "" {
+				flush()
+				writeLink(w, target, func() { w.text(target) })
+				i += len(target)
+				continue
+			}
+		}
+
+		plain.WriteByte(c)
+		i++
+	}
+
+	flush()
+}
+
+func writeLink(w *writer, target string, label func()) {
+	w.open("a", "href", target, "rel", "noopener noreferrer nofollow", "target", "_blank")
+	label()
+	w.close("a")
+}
+
+// parseLink reads a [text](target) link at the start of s. Links to anything
+// but the allowed schemes stay plain text.
+func parseLink(s string) (text, target string, n int, ok bool) {
+	mid := strings.Index(s, "](")
+	if mid < 1 {
+		return "", "", 0, false
+	}
+	end := strings.IndexByte(s[mid+2:], ')')
+	if end < 1 {
+		return "", "", 0, false
+	}
+
+	text = s[1:mid]
+	target = s[mid+2 : mid+2+end]
+	if strings.ContainsAny(text, "[]") || !safeURL(target) {
+		return "", "", 0, false
+	}
+
+	return text, target, mid + 3 + end, true
+}
+
+// parseEmphasis reads *em*, _em_, **strong** or __strong__ starting at
+// s[i]. Underscores inside words, as in snake_case, are left alone.
+func parseEmphasis(s string, i int) (inner string, n int, strong bool, ok bool) {
+	delim := s[i : i+1]
+	if strings.HasPrefix(s[i:], delim+delim) {
+		delim += delim
+		strong = true
+	}
+	underscore := delim[0] == '_'
+
+	open := i + len(delim)
+	if open >= len(s) || s[open] == ' ' {
+		return "", 0, false, false
+	}
+	if underscore && i > 0 && isWordChar(s[i-1]) {
+		return "", 0, false, false
+	}
+
+	end := strings.Index(s[open:], delim)
+	if end < 1 {
+		return "", 0, false, false
+	}
+	closeAt := open + end
+	if s[closeAt-1] == ' ' {
+		return "", 0, false, false
+	}
+
+	after := closeAt + len(delim)
+	if underscore && after < len(s) && isWordChar(s[after]) {
+		return "", 0, false, false
+	}
+
+	return s[open:closeAt], after - i, strong, true
+}
+
+// autolink returns the bare http(s) URL at the start of s, without trailing
+// punctuation that belongs to the sentence
+func autolink(s string) string {
+	if !strings.HasPrefix(s, "https://") && !strings.HasPrefix(s, "http://") {
+		return ""
+	}
+
+	end := strings.IndexAny(s, " \t\n<")
+	if end < 0 {
+		end = len(s)
+	}
+	target := strings.TrimRight(s[:end], ".,;:!?)'\"")
+	if strings.HasSuffix(target, "://") || !safeURL(target) {
+		return ""
+	}
+
+	return target
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,129 @@
+package markup
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const linkAttrs = `rel="noopener noreferrer nofollow" target="_blank"`
+
+var (
+	tagPattern  = regexp.MustCompile(`<(/?)([^\s>/]*)([^>]*)>`)
+	attrPattern = regexp.MustCompile(`\s([^\s=]+)="([^"]*)"`)
+)
+
+// checkAllowed fails the test if html has a tag, an attribute or an href the
+// allowlist doesn't let through, whatever the expected output says
+func checkAllowed(t *testing.T, html string) {
+	t.Helper()
+	for _, tag := range tagPattern.FindAllStringSubmatch(html, -1) {
+		allowedAttrs, ok := allowedTags[tag[2]]
+		if !ok {
+			t.Fatalf("tag %q in %s", tag[0], html)
+		}
+		attrs := attrPattern.FindAllStringSubmatch(tag[3], -1)
+		if rest := attrPattern.ReplaceAllString(tag[3], ""); strings.TrimSpace(rest) != "" {
+			t.Fatalf("stray %q in tag %q", rest, tag[0])
+		}
+		for _, attr := range attrs {
+			if !allowedAttrs[attr[1]] {
+				t.Fatalf("attribute %q in %s", attr[1], html)
+			}
+			if attr[1] == "href" && !strings.HasPrefix(attr[2], "https://") && !strings.HasPrefix(attr[2], "http://") && !strings.HasPrefix(attr[2], "mailto:") {
+				t.Fatalf("href %q in %s", attr[2], html)
+			}
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		html   string
+	}{
+		// Link targets
+		{"link", "[site](https://a.test/?a=1&b=2)", `<p><a href="https://a.test/?a=1&amp;b=2" ` + linkAttrs + `>site</a></p>`},
+		{"mailto", "[mail](mailto:a@a.test)", `<p><a href="mailto:a@a.test" ` + linkAttrs + `>mail</a></p>`},
+		{"javascript", "[x](javascript:alert(1))", `<p>[x](javascript:alert(1))</p>`},
+		{"javascript mixed case", "[x](JaVaScRiPt:alert(1))", `<p>[x](JaVaScRiPt:alert(1))</p>`},
+		{"javascript with leading space", "[x]( javascript:alert(1))", `<p>[x]( javascript:alert(1))</p>`},
+		{"javascript with entity", "[x](java&#x73;cript:alert(1))", `<p>[x](java&amp;#x73;cript:alert(1))</p>`},
+		{"data", "[x](data:text/html;base64,PHNjcmlwdD4=)", `<p>[x](data:text/html;base64,PHNjcmlwdD4=)</p>`},
+		{"vbscript", "[x](vbscript:msgbox)", `<p>[x](vbscript:msgbox)</p>`},
+		{"relative", "[x](/admin)", `<p>[x](/admin)</p>`},
+		{"bare javascript", "javascript:alert(1)", `<p>javascript:alert(1)</p>`},
+
+		// Quotes and angle brackets in hrefs
+		{"quote in href", `[x](https://a.test/"onmouseover="alert(1))`, `<p>[x](https://a.test/&#34;onmouseover=&#34;alert(1))</p>`},
+		{"single quote in href", `[x](https://a.test/'onmouseover='alert(1))`, `<p>[x](https://a.test/&#39;onmouseover=&#39;alert(1))</p>`},
+		{"backtick in href", "[x](https://a.test/`x`)", `<p>[x](https://a.test/<code>x</code>)</p>`},
+		{"tag in href", "[x](https://a.test/<script>)", `<p>[x](<a href="https://a.test/" ` + linkAttrs + `>https://a.test/</a>&lt;script&gt;)</p>`},
+		{"quote in autolink", `https://a.test/"><script>`, `<p>https://a.test/&#34;&gt;&lt;script&gt;</p>`},
+		{"link text is escaped", `[<b>"hi"</b>](https://a.test)`, `<p><a href="https://a.test" ` + linkAttrs + `>&lt;b&gt;&#34;hi&#34;&lt;/b&gt;</a></p>`},
+
+		// Fence languages
+		{"fence language", "```go\nfmt.Println()\n```", `<pre><code class="language-go">fmt.Println()</code></pre>`},
+		{"fence language with quotes", "```js\" onload=\"alert(1)\nx\n```", `<pre><code>x</code></pre>`},
+		{"fence language with tag", "```<script>\nx\n```", `<pre><code>x</code></pre>`},
+		{"fence language with angle brackets", "```c>\nx\n```", `<pre><code>x</code></pre>`},
+
+		// Raw HTML
+		{"script", "<script>alert(1)</script>", `<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>`},
+		{"img onerror", `<img src=x onerror="alert(1)">`, `<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>`},
+		{"script in code span", "`<script>alert(1)</script>`", `<p><code>&lt;script&gt;alert(1)&lt;/script&gt;</code></p>`},
+		{"img in code span", "`<img src=x onerror=alert(1)>`", `<p><code>&lt;img src=x onerror=alert(1)&gt;</code></p>`},
+		{"script in fence", "```\n<script>alert(1)</script>\n```", `<pre><code>&lt;script&gt;alert(1)&lt;/script&gt;</code></pre>`},
+		{"img in fence", "```html\n<img src=x onerror=alert(1)>\n```", `<pre><code class="language-html">&lt;img src=x onerror=alert(1)&gt;</code></pre>`},
+		{"script in emphasis", "*<script>*", `<p><em>&lt;script&gt;</em></p>`},
+		{"script in list", "- <script>", `<ul><li>&lt;script&gt;</li></ul>`},
+		{"escaped bracket", `\<script>`, `<p>&lt;script&gt;</p>`},
+
+		// Emphasis
+		{"em", "*em* and _em_", `<p><em>em</em> and <em>em</em></p>`},
+		{"strong", "**strong** and __strong__", `<p><strong>strong</strong> and <strong>strong</strong></p>`},
+		{"nested", "**bold _and em_**", `<p><strong>bold <em>and em</em></strong></p>`},
+		{"nested same delimiter", "*_x_*", `<p><em><em>x</em></em></p>`},
+		{"triple", "***x***", `<p><strong>*x</strong>*</p>`},
+		{"unbalanced strong", "**bold", `<p>**bold</p>`},
+		{"unbalanced em", "*em", `<p>*em</p>`},
+		{"unbalanced mixed", "*a _b* c_", `<p><em>a _b</em> c_</p>`},
+		{"spaced", "* not em *", `<ul><li>not em *</li></ul>`},
+		{"spaced inline", "a * b * c", `<p>a * b * c</p>`},
+		{"snake case", "snake_case_name", `<p>snake_case_name</p>`},
+		{"escaped", `\*not em\*`, `<p>*not em*</p>`},
+		{"lone delimiters", "** __ * _", `<p>** __ * _</p>`},
+
+		// Code
+		{"code span keeps markup", "`*not em*`", `<p><code>*not em*</code></p>`},
+		{"unclosed code span", "`open", "<p>`open</p>"},
+		{"unclosed fence", "```\n<b>open\n**x**", `<pre><code>&lt;b&gt;open` + "\n" + `**x**</code></pre>`},
+		{"empty fence", "```", `<pre><code></code></pre>`},
+
+		// Autolinks
+		{"autolink", "see https://a.test/x", `<p>see <a href="https://a.test/x" ` + linkAttrs + `>https://a.test/x</a></p>`},
+		{"autolink period", "see https://a.test/x.", `<p>see <a href="https://a.test/x" ` + linkAttrs + `>https://a.test/x</a>.</p>`},
+		{"autolink punctuation", "https://a.test/x?!", `<p><a href="https://a.test/x" ` + linkAttrs + `>https://a.test/x</a>?!</p>`},
+		{"autolink parens", "(https://a.test/x)", `<p>(<a href="https://a.test/x" ` + linkAttrs + `>https://a.test/x</a>)</p>`},
+		{"autolink quotes", `'https://a.test/x'`, `<p>&#39;https://a.test/x&#39;</p>`},
+		{"autolink mid word", "xhttps://a.test", `<p>xhttps://a.test</p>`},
+		{"autolink scheme only", "https://", `<p>https://</p>`},
+		{"no autolink in link text", "[https://a.test](https://b.test)", `<p><a href="https://b.test" ` + linkAttrs + `>https://a.test</a></p>`},
+
+		// Blocks
+		{"paragraphs", "one\ntwo\n\nthree", `<p>one<br>two</p><p>three</p>`},
+		{"lists", "- a\n- b\n1. c", `<ul><li>a</li><li>b</li></ul><ol><li>c</li></ol>`},
+		{"empty", "", ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Render(tt.source)
+			checkAllowed(t, got)
+			if got != tt.html {
+				t.Fatalf("Render(%q)\n got %s\nwant %s", tt.source, got, tt.html)
+			}
+		})
+	}
+}
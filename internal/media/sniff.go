@@ -0,0 +1,27 @@
+package media
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// Sniff detects the content type of a stream from its first 512 bytes, the
+// same way browsers do, and returns a reader that still yields the whole
+// stream. Parameters such as charset are dropped.
+func Sniff(r io.Reader) (string, io.Reader, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", nil, err
+	}
+	head = head[:n]
+
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		contentType = "application/octet-stream"
+	}
+
+	return contentType, io.MultiReader(bytes.NewReader(head), r), nil
+}
@@ -0,0 +1,61 @@
+package media
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	long := bytes.Repeat([]byte("plain text "), 100)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"png", png, "image/png"},
+		{"pdf", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"text drops charset", []byte("just words"), "text/plain"},
+		{"html is not text", []byte("<html><script>alert(1)</script>"), "text/html"},
+		{"longer than the sniffed head", long, "text/plain"},
+		{"empty", nil, "text/plain"},
+		{"binary", []byte{0x00, 0x01, 0x02, 0xff}, "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType, reader, err := Sniff(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentType != tt.want {
+				t.Errorf("content type %q, want %q", contentType, tt.want)
+			}
+
+			// Sniffing must not eat the start of the stream
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("stream changed: got %d bytes, want %d", len(got), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestSniffReadError(t *testing.T) {
+	_, _, err := Sniff(io.MultiReader(strings.NewReader("ab"), errReader{}))
+	if err == nil {
+		t.Fatal("Sniff ignored a read error")
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
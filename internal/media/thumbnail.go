@@ -0,0 +1,109 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	// Register the formats thumbnails can be made from
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Largest side of a generated thumbnail
+const ThumbnailSize = 320
+
+// Images bigger than this are not decoded, so a tiny file that claims huge
+// dimensions cannot exhaust memory
+const maxPixels = 40_000_000
+
+var ErrTooLarge = errors.New("media: image too large to thumbnail")
+
+// Thumbnail holds an encoded JPEG preview and the original image dimensions
+type Thumbnail struct {
+	Data   []byte
+	Width  int
+	Height int
+}
+
+// CanThumbnail reports whether thumbnails can be made for a content type
+func CanThumbnail(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// MakeThumbnail decodes an image and scales it to fit a ThumbnailSize square,
+// flattening transparency onto white
+func MakeThumbnail(data []byte) (*Thumbnail, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, scale(src, ThumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+
+	return &Thumbnail{Data: buf.Bytes(), Width: config.Width, Height: config.Height}, nil
+}
+
+// scale shrinks src to fit a size x size box by averaging the source pixels
+// that fall into each destination pixel. Smaller images are left as is.
+func scale(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	dw, dh := w, h
+	if w > size || h > size {
+		if w >= h {
+			dw, dh = size, max(1, h*size/w)
+		} else {
+			dw, dh = max(1, w*size/h), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0 := bounds.Min.Y + y*h/dh
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*h/dh)
+		for x := 0; x < dw; x++ {
+			x0 := bounds.Min.X + x*w/dw
+			x1 := max(x0+1, bounds.Min.X+(x+1)*w/dw)
+
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					// Composite over white
+					white := 0xffff - uint64(ca)
+					r += uint64(cr) + white
+					g += uint64(cg) + white
+					b += uint64(cb) + white
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: 0xffff,
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,90 @@
+// Package partition keeps the monthly partitions of the messages table in
+// step with the calendar.
+package partition
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+const (
+	// Partitions only change once a month; a daily run leaves plenty of slack
+	runInterval = 24 * time.Hour
+
+	// Months created ahead of time, so inserts never depend on the job
+	monthsAhead = 3
+)
+
+// Maintainer creates partitions for the coming months and, when keepMonths
+// is set, detaches the months before the last keepMonths. Detached months
+// stay in the database as tables of their own to be archived or dropped.
+type Maintainer struct {
+	partitionStore store.PartitionStore
+	keepMonths     int
+}
+
+func NewMaintainer(partitionStore store.PartitionStore, keepMonths int) *Maintainer {
+	return &Maintainer{
+		partitionStore: partitionStore,
+		keepMonths:     keepMonths,
+	}
+}
+
+// Start maintains the partitions now and then daily for as long as the
+// process runs
+func (m *Maintainer) Start() {
+	go func() {
+		ticker := time.NewTicker(runInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := m.Run(context.Background()); err != nil {
+				log.Printf("Error maintaining message partitions: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Run does one round of maintenance. A month that can't be created is
+// logged and tried again next run; it doesn't hold up the others.
+func (m *Maintainer) Run(ctx context.Context) error {
+	now := time.Now().UTC()
+	for i := 0; i <= monthsAhead; i++ {
+		month := now.AddDate(0, i, 1-now.Day())
+		created, err := m.partitionStore.CreateMessagePartition(ctx, month)
+		if err != nil {
+			log.Printf("Error creating message partition for %s: %v", month.Format("2006-01"), err)
+			continue
+		}
+		if created {
+			log.Printf("Created message partition for %s", month.Format("2006-01"))
+		}
+	}
+
+	if m.keepMonths <= 0 {
+		return nil
+	}
+
+	partitions, err := m.partitionStore.GetMessagePartitions(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The current month counts as the first month kept
+	oldestKept := time.Date(now.Year(), now.Month()-time.Month(m.keepMonths-1), 1, 0, 0, 0, 0, time.UTC)
+	for _, partition := range partitions {
+		if !partition.Month.Before(oldestKept) {
+			break
+		}
+		if err := m.partitionStore.DetachMessagePartition(ctx, partition); err != nil {
+			return err
+		}
+		log.Printf("Detached message partition %s", partition.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,307 @@
+// Package retention removes messages that rooms no longer keep, either for
+// good or into compressed archives in the blob store, and clears out deleted
+// rooms.
+package retention
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kaczmarekdaniel/gochat/internal/blob"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+const (
+	// Retention works in days, so there is no point in looking more often
+	runInterval = time.Hour
+
+	// Threads removed per transaction, and so per archive file
+	batchSize = 500
+
+	// Deleted rooms are out of sight already, so they can wait a little
+	deletionInterval = time.Minute
+)
+
+// A deleted room keeps nothing, however recent
+var (
+	deletedRoomPolicy = &store.RetentionPolicy{Action: store.RetentionDelete}
+	endOfTime         = time.Date(9999, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Worker applies the retention policies of all rooms. Rooms without a policy
+// of their own use the default, if there is one.
+type Worker struct {
+	retentionStore store.RetentionStore
+	roomStore      store.RoomStore
+	blobStore      blob.BlobStore
+	fallback       *store.RetentionPolicy
+}
+
+func NewWorker(retentionStore store.RetentionStore, roomStore store.RoomStore, blobStore blob.BlobStore, fallback *store.RetentionPolicy) *Worker {
+	return &Worker{
+		retentionStore: retentionStore,
+		roomStore:      roomStore,
+		blobStore:      blobStore,
+		fallback:       fallback,
+	}
+}
+
+// Start applies the policies in the background for as long as the process runs
+func (w *Worker) Start() {
+	go func() {
+		ticker := time.NewTicker(runInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := w.Run(context.Background()); err != nil {
+				log.Printf("Error applying retention policies: %v", err)
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(deletionInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := w.PurgeDeletedRooms(context.Background()); err != nil {
+				log.Printf("Error purging deleted rooms: %v", err)
+			}
+		}
+	}()
+}
+
+// policies returns the policy in force for every room that has one
+func (w *Worker) policies(ctx context.Context) (map[string]*store.RetentionPolicy, error) {
+	rooms, err := w.roomStore.GetAllRooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make(map[string]*store.RetentionPolicy)
+	for _, room := range rooms {
+		switch {
+		case room.Retention != nil:
+			policies[room.ID] = room.Retention
+		case w.fallback != nil:
+			policies[room.ID] = w.fallback
+		}
+	}
+	return policies, nil
+}
+
+// Report is the dry run of Run: what every policy would remove right now
+func (w *Worker) Report(ctx context.Context) ([]*store.RetentionReport, error) {
+	policies, err := w.policies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	reports := []*store.RetentionReport{}
+	for roomID, policy := range policies {
+		report, err := w.retentionStore.PreviewRetention(ctx, roomID, policy.Cutoff(now))
+		if err != nil {
+			return nil, err
+		}
+		report.Policy = policy
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// Run applies every policy once and returns how many messages were removed
+func (w *Worker) Run(ctx context.Context) (int, error) {
+	policies, err := w.policies(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	removed := 0
+	for roomID, policy := range policies {
+		n, err := w.apply(ctx, roomID, policy, policy.Cutoff(now))
+		removed += n
+		if err != nil {
+			return removed, fmt.Errorf("room %s: %w", roomID, err)
+		}
+	}
+
+	return removed, nil
+}
+
+// PurgeDeletedRooms removes what is left of deleted rooms: their messages
+// batch by batch along with their files, their archive files, and then the
+// rooms themselves. It returns how many messages were removed.
+func (w *Worker) PurgeDeletedRooms(ctx context.Context) (int, error) {
+	roomIDs, err := w.roomStore.GetDeletedRooms(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, roomID := range roomIDs {
+		n, err := w.apply(ctx, roomID, deletedRoomPolicy, endOfTime)
+		removed += n
+		if err != nil {
+			return removed, fmt.Errorf("room %s: %w", roomID, err)
+		}
+
+		archives, err := w.retentionStore.GetArchives(ctx, roomID)
+		if err != nil {
+			return removed, fmt.Errorf("room %s: %w", roomID, err)
+		}
+		for _, archive := range archives {
+			if err := w.blobStore.Delete(ctx, archive.BlobKey); err != nil {
+				log.Printf("Error deleting %s: %v", archive.BlobKey, err)
+			}
+		}
+
+		if err = w.roomStore.PurgeRoom(ctx, roomID); err != nil {
+			return removed, fmt.Errorf("room %s: %w", roomID, err)
+		}
+	}
+
+	return removed, nil
+}
+
+// apply removes a room's old threads batch by batch
+func (w *Worker) apply(ctx context.Context, roomID string, policy *store.RetentionPolicy, cutoff time.Time) (int, error) {
+	removed := 0
+	for {
+		var written *store.MessageArchive
+		archive := func(messages []*store.ArchivedMessage) (*store.MessageArchive, error) {
+			if policy.Action != store.RetentionArchive {
+				return nil, nil
+			}
+			var err error
+			written, err = w.writeArchive(ctx, roomID, messages)
+			return written, err
+		}
+
+		messages, err := w.retentionStore.ClaimOldMessages(ctx, roomID, cutoff, batchSize, archive)
+		if err != nil {
+			// The rows are still there, so the file would be a stray copy
+			if written != nil {
+				w.blobStore.Delete(ctx, written.BlobKey)
+			}
+			return removed, err
+		}
+		removed += len(messages)
+
+		// Archived messages keep their files for a restore
+		if policy.Action == store.RetentionDelete {
+			w.deleteFiles(ctx, messages)
+		}
+
+		if len(messages) == 0 {
+			return removed, nil
+		}
+	}
+}
+
+// writeArchive stores messages as gzipped JSON lines, one message per line
+func (w *Worker) writeArchive(ctx context.Context, roomID string, messages []*store.ArchivedMessage) (*store.MessageArchive, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+
+	archive := &store.MessageArchive{
+		ID:           uuid.New().String(),
+		RoomID:       roomID,
+		MessageCount: len(messages),
+		Oldest:       messages[0].Time,
+		Newest:       messages[0].Time,
+	}
+	for _, message := range messages {
+		if err := encoder.Encode(message); err != nil {
+			return nil, err
+		}
+		if message.Time.Before(archive.Oldest) {
+			archive.Oldest = message.Time
+		}
+		if message.Time.After(archive.Newest) {
+			archive.Newest = message.Time
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	archive.BlobKey = fmt.Sprintf("archives/%s/%s.jsonl.gz", roomID, archive.ID)
+	err := w.blobStore.Put(ctx, archive.BlobKey, &buf, int64(buf.Len()), "application/gzip")
+	if err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// Restore puts the messages of an archive back and returns how many were
+// missing. The file is kept, so an archive can be restored again.
+func (w *Worker) Restore(ctx context.Context, archiveID string) (int, error) {
+	archive, err := w.retentionStore.GetArchive(ctx, archiveID)
+	if err != nil {
+		return 0, err
+	}
+
+	messages, err := w.readArchive(ctx, archive)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.retentionStore.RestoreArchive(ctx, archive.ID, messages)
+}
+
+func (w *Worker) readArchive(ctx context.Context, archive *store.MessageArchive) ([]*store.ArchivedMessage, error) {
+	file, err := w.blobStore.Get(ctx, archive.BlobKey)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var messages []*store.ArchivedMessage
+	lines := bufio.NewScanner(gz)
+	lines.Buffer(nil, 4<<20)
+	for lines.Scan() {
+		message := &store.ArchivedMessage{}
+		if err := json.Unmarshal(lines.Bytes(), message); err != nil {
+			return nil, fmt.Errorf("archive %s line %d: %w", archive.ID, len(messages)+1, err)
+		}
+		messages = append(messages, message)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (w *Worker) deleteFiles(ctx context.Context, messages []*store.ArchivedMessage) {
+	for _, message := range messages {
+		for _, attachment := range message.Attachments {
+			for _, key := range []string{attachment.BlobKey, attachment.ThumbnailKey} {
+				if key == "" {
+					continue
+				}
+				if err := w.blobStore.Delete(ctx, key); err != nil {
+					log.Printf("Error deleting %s: %v", key, err)
+				}
+			}
+		}
+	}
+}
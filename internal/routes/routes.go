@@ -26,6 +26,54 @@ func SetupRoutes(app *app.Application) {
 	http.HandleFunc("/leave-room", middleware.Chain(app.RoomHandler.HandleLeaveRoom, standardMiddleware...))
 	http.HandleFunc("/user-rooms", middleware.Chain(app.RoomHandler.HandleUserRooms, standardMiddleware...))
 	http.HandleFunc("/rooms", middleware.Chain(app.RoomHandler.HandleRooms, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}", middleware.Chain(app.RoomHandler.HandleRoom, standardMiddleware...))
+	http.HandleFunc("/direct-rooms", middleware.Chain(app.RoomHandler.HandleOpenDirectRoom, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/read", middleware.Chain(app.ReadHandler.HandleMarkRead, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/messages", middleware.Chain(app.MessageHandler.HandleGetMesssages, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/mute", middleware.Chain(app.RoomHandler.HandleMuteRoom, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/ttl", middleware.Chain(app.RoomHandler.HandleSetMessageTTL, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/retention", middleware.Chain(app.RoomHandler.HandleSetRetention, standardMiddleware...))
+	http.HandleFunc("/messages/{id}/edits", middleware.Chain(app.MessageHandler.HandleGetMessageEdits, standardMiddleware...))
+	http.HandleFunc("/messages/{id}/thread", middleware.Chain(app.MessageHandler.HandleGetThread, standardMiddleware...))
+	http.HandleFunc("/mentions", middleware.Chain(app.MentionHandler.HandleGetMentions, standardMiddleware...))
+	http.HandleFunc("/search", middleware.Chain(app.SearchHandler.HandleSearch, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/attachments", middleware.Chain(app.AttachmentHandler.HandleUpload, standardMiddleware...))
+	http.HandleFunc("/attachments/{id}", middleware.Chain(app.AttachmentHandler.HandleGetAttachment, standardMiddleware...))
+	http.HandleFunc("/attachments/{id}/download", app.AttachmentHandler.HandleDownload)
+	http.HandleFunc("/rooms/{id}/pins", middleware.Chain(app.PinHandler.HandleGetPins, standardMiddleware...))
+	http.HandleFunc("/messages/{id}/bookmark", middleware.Chain(app.BookmarkHandler.HandleBookmark, standardMiddleware...))
+	http.HandleFunc("/bookmarks", middleware.Chain(app.BookmarkHandler.HandleGetBookmarks, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/scheduled", middleware.Chain(app.ScheduleHandler.HandleScheduleMessage, standardMiddleware...))
+	http.HandleFunc("/scheduled", middleware.Chain(app.ScheduleHandler.HandleGetScheduled, standardMiddleware...))
+	http.HandleFunc("/scheduled/{id}", middleware.Chain(app.ScheduleHandler.HandleCancelScheduled, standardMiddleware...))
+	http.HandleFunc("/messages/{id}/reminders", middleware.Chain(app.ScheduleHandler.HandleCreateReminder, standardMiddleware...))
+	http.HandleFunc("/reminders", middleware.Chain(app.ScheduleHandler.HandleGetReminders, standardMiddleware...))
+	http.HandleFunc("/reminders/{id}", middleware.Chain(app.ScheduleHandler.HandleCancelReminder, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/members", middleware.Chain(app.RoomHandler.HandleGetMembers, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/members/{user}/role", middleware.Chain(app.RoomHandler.HandleSetMemberRole, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/owner", middleware.Chain(app.RoomHandler.HandleTransferOwnership, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/kick", middleware.Chain(app.ModerationHandler.HandleKick, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/bans", middleware.Chain(app.ModerationHandler.HandleBans, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/bans/{user}", middleware.Chain(app.ModerationHandler.HandleUnban, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/mutes", middleware.Chain(app.ModerationHandler.HandleMute, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/mutes/{user}", middleware.Chain(app.ModerationHandler.HandleUnmute, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/moderation-log", middleware.Chain(app.ModerationHandler.HandleGetModerationLog, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/filters", middleware.Chain(app.FilterHandler.HandleFilters, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/filters/{rule}", middleware.Chain(app.FilterHandler.HandleRemoveFilter, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/held-messages", middleware.Chain(app.FilterHandler.HandleHeldMessages, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/held-messages/{held}", middleware.Chain(app.FilterHandler.HandleReviewHeldMessage, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/reports", middleware.Chain(app.ReportHandler.HandleReports, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/reports/{report}", middleware.Chain(app.ReportHandler.HandleGetReport, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/reports/{report}/claim", middleware.Chain(app.ReportHandler.HandleClaim, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/reports/{report}/resolve", middleware.Chain(app.ReportHandler.HandleResolve, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/visibility", middleware.Chain(app.RoomHandler.HandleSetVisibility, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/invites", middleware.Chain(app.InviteHandler.HandleInvites, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/invites/{invite}", middleware.Chain(app.InviteHandler.HandleRevokeInvite, standardMiddleware...))
+	http.HandleFunc("/invites/{code}/join", middleware.Chain(app.InviteHandler.HandleJoinWithInvite, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/invitations", middleware.Chain(app.InviteHandler.HandleInvitation, standardMiddleware...))
+	http.HandleFunc("/invitations", middleware.Chain(app.InviteHandler.HandleGetInvitations, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/join-requests", middleware.Chain(app.InviteHandler.HandleJoinRequests, standardMiddleware...))
+	http.HandleFunc("/rooms/{id}/join-requests/{user}", middleware.Chain(app.InviteHandler.HandleAnswerJoinRequest, standardMiddleware...))
 	// http.HandleFunc("/init", withMiddleware(app.MessageHandler.HandleGetMesssages))
 
 }
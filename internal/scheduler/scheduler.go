@@ -0,0 +1,201 @@
+// Package scheduler sends scheduled messages and reminders when they fall due.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/filter"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+const (
+	// How often the tables are polled for due work
+	pollInterval = 5 * time.Second
+
+	// Rows claimed per transaction
+	batchSize = 50
+
+	// How long a message from a muted author waits before it is tried again
+	mutedRetry = time.Minute
+)
+
+// Scheduler polls for due work. Claiming uses SKIP LOCKED, so any number of
+// instances can run one against the same database.
+type Scheduler struct {
+	scheduleStore store.ScheduleStore
+	roomStore     store.RoomStore
+	filterStore   store.FilterStore
+	filters       *filter.Pipeline
+	events        *events.Bus
+}
+
+func NewScheduler(scheduleStore store.ScheduleStore, roomStore store.RoomStore, filterStore store.FilterStore, filters *filter.Pipeline, bus *events.Bus) *Scheduler {
+	return &Scheduler{
+		scheduleStore: scheduleStore,
+		roomStore:     roomStore,
+		filterStore:   filterStore,
+		filters:       filters,
+		events:        bus,
+	}
+}
+
+// Start polls in the background for as long as the process runs
+func (s *Scheduler) Start() {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.poll()
+		}
+	}()
+}
+
+// checked is a due message that passed its checks, as it will be sent, and
+// what the content filters made of it
+type checked struct {
+	message *store.Message
+	verdict filter.Verdict
+}
+
+// poll drains everything that is due, a batch at a time
+func (s *Scheduler) poll() {
+	ctx := context.Background()
+
+	// Only messages that were sent count: a batch of messages that had to
+	// wait or failed their check would otherwise be claimed over and over
+	for {
+		passed := make(map[string]checked)
+		due, err := s.scheduleStore.ClaimDueMessages(ctx, batchSize, func(scheduled *store.ScheduledMessage) error {
+			message, verdict, err := s.checkMessage(ctx, scheduled)
+			if err == nil {
+				passed[scheduled.ID] = checked{message: message, verdict: verdict}
+			}
+			return err
+		})
+		if err != nil {
+			log.Printf("Error sending scheduled messages: %v", err)
+			break
+		}
+		for _, scheduled := range due {
+			s.sendMessage(ctx, passed[scheduled.ID])
+		}
+		if len(due) < batchSize {
+			break
+		}
+	}
+
+	for {
+		due, err := s.scheduleStore.ClaimDueReminders(ctx, batchSize)
+		if err != nil {
+			log.Printf("Error sending reminders: %v", err)
+			break
+		}
+		for _, reminder := range due {
+			s.remind(reminder)
+		}
+		if len(due) < batchSize {
+			break
+		}
+	}
+}
+
+// checkMessage decides whether a due message can go out now, putting it
+// through what a message typed into a connection goes through. Someone who
+// left the room or lost the right to post in the meantime can't post there,
+// and nobody can post to an archived room, so the message is dropped, as is
+// one the content filters reject. A muted author's message waits for the
+// mute to run out, and one held back by slow mode waits for its turn.
+func (s *Scheduler) checkMessage(ctx context.Context, scheduled *store.ScheduledMessage) (*store.Message, filter.Verdict, error) {
+	err := s.roomStore.Authorize(ctx, scheduled.UserID, scheduled.RoomID, store.PermPost)
+	if errors.Is(err, store.ErrNotRoomMember) || errors.Is(err, store.ErrForbidden) || errors.Is(err, store.ErrRoomArchived) {
+		log.Printf("Dropping scheduled message %s: %v", scheduled.ID, err)
+		return nil, filter.Verdict{}, store.ErrScheduledDropped
+	}
+	if errors.Is(err, store.ErrMuted) {
+		scheduled.SendAt = time.Now().Add(mutedRetry)
+		return nil, filter.Verdict{}, store.ErrScheduledPostponed
+	}
+	if err != nil {
+		return nil, filter.Verdict{}, err
+	}
+
+	message := &store.Message{
+		Type:     "chat",
+		Room:     scheduled.RoomID,
+		Content:  scheduled.Content,
+		Format:   scheduled.Format,
+		Sender:   scheduled.UserID,
+		ParentID: scheduled.ParentID,
+	}
+	verdict := s.filters.Run(ctx, message)
+	if verdict.Action == filter.Reject {
+		log.Printf("Dropping scheduled message %s: rejected by the %s filter", scheduled.ID, verdict.Filter)
+		s.events.PublishUser(scheduled.UserID, events.MessageRejected(scheduled.RoomID, verdict.Filter, verdict.Reason))
+		return nil, filter.Verdict{}, store.ErrScheduledDropped
+	}
+
+	// Last, so a message that was turned down doesn't start the wait
+	wait, err := s.roomStore.TakeSlowModeTurn(ctx, scheduled.UserID, scheduled.RoomID)
+	if err != nil {
+		return nil, filter.Verdict{}, err
+	}
+	if wait > 0 {
+		scheduled.SendAt = time.Now().Add(wait)
+		return nil, filter.Verdict{}, store.ErrScheduledPostponed
+	}
+
+	return message, verdict, nil
+}
+
+// sendMessage posts a scheduled message through the hub as if its author had
+// just sent it, or holds it for review if a filter asked for that
+func (s *Scheduler) sendMessage(ctx context.Context, due checked) {
+	message := due.message
+	message.Time = time.Now()
+
+	s.filters.Accepted(message, due.verdict)
+	if due.verdict.Action == filter.Hold {
+		s.holdMessage(ctx, message, due.verdict)
+		return
+	}
+
+	s.events.PublishMessage(message)
+}
+
+// holdMessage puts a message in its room's review queue and tells its
+// author and the room's reviewers about it
+func (s *Scheduler) holdMessage(ctx context.Context, message *store.Message, verdict filter.Verdict) {
+	held, err := s.filterStore.HoldMessage(ctx, &store.HeldMessage{
+		RoomID:  message.Room,
+		Sender:  message.Sender,
+		Message: message,
+		Filter:  verdict.Filter,
+		Reason:  verdict.Reason,
+	})
+	if err != nil {
+		log.Printf("Error holding scheduled message for review: %v", err)
+		return
+	}
+
+	s.events.PublishUser(message.Sender, events.MessageHeld(held))
+
+	reviewers, err := s.roomStore.GetMembersWith(ctx, message.Room, store.PermReview)
+	if err != nil {
+		log.Printf("Error getting reviewers of room %s: %v", message.Room, err)
+		return
+	}
+	for _, reviewer := range reviewers {
+		if reviewer != message.Sender {
+			s.events.PublishUser(reviewer, events.MessageHeld(held))
+		}
+	}
+}
+
+func (s *Scheduler) remind(reminder *store.Reminder) {
+	s.events.PublishUser(reminder.UserID, events.Reminder(reminder))
+}
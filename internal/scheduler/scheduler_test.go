@@ -0,0 +1,270 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/filter"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// fakeScheduleStore claims due messages the way the Postgres store does:
+// soonest first, up to limit, removing the ones that passed or were dropped
+// and moving the postponed ones
+type fakeScheduleStore struct {
+	store.ScheduleStore
+	messages []*store.ScheduledMessage
+	claims   int
+}
+
+func (f *fakeScheduleStore) ClaimDueMessages(ctx context.Context, limit int, check func(*store.ScheduledMessage) error) ([]*store.ScheduledMessage, error) {
+	f.claims++
+	sort.SliceStable(f.messages, func(i, j int) bool {
+		return f.messages[i].SendAt.Before(f.messages[j].SendAt)
+	})
+
+	now := time.Now()
+	var passed, kept []*store.ScheduledMessage
+	for _, scheduled := range f.messages {
+		if limit == 0 || scheduled.SendAt.After(now) {
+			kept = append(kept, scheduled)
+			continue
+		}
+		limit--
+
+		err := check(scheduled)
+		switch {
+		case err == nil:
+			passed = append(passed, scheduled)
+		case errors.Is(err, store.ErrScheduledDropped):
+		default:
+			kept = append(kept, scheduled)
+		}
+	}
+	f.messages = kept
+	return passed, nil
+}
+
+func (f *fakeScheduleStore) ClaimDueReminders(ctx context.Context, limit int) ([]*store.Reminder, error) {
+	return nil, nil
+}
+
+// fakeRoomStore answers Authorize with a fixed error per user and keeps a
+// fixed slow mode wait for everyone
+type fakeRoomStore struct {
+	store.RoomStore
+	denied map[string]error
+	wait   time.Duration
+}
+
+func (f *fakeRoomStore) Authorize(ctx context.Context, userID, roomID string, permission store.Permission) error {
+	return f.denied[userID]
+}
+
+func (f *fakeRoomStore) TakeSlowModeTurn(ctx context.Context, userID, roomID string) (time.Duration, error) {
+	return f.wait, nil
+}
+
+func (f *fakeRoomStore) GetMembersWith(ctx context.Context, roomID string, permission store.Permission) ([]string, error) {
+	return []string{"alice", "mod"}, nil
+}
+
+// fakeFilterStore serves fixed rules and keeps what was held
+type fakeFilterStore struct {
+	store.FilterStore
+	rules []*store.FilterRule
+	held  []*store.HeldMessage
+}
+
+func (f *fakeFilterStore) GetFilterRules(ctx context.Context, roomID string) ([]*store.FilterRule, error) {
+	return f.rules, nil
+}
+
+func (f *fakeFilterStore) HoldMessage(ctx context.Context, held *store.HeldMessage) (*store.HeldMessage, error) {
+	f.held = append(f.held, held)
+	return held, nil
+}
+
+func newTestScheduler(schedules *fakeScheduleStore, rooms *fakeRoomStore, filters *fakeFilterStore) (*Scheduler, *events.Bus) {
+	if filters == nil {
+		filters = &fakeFilterStore{}
+	}
+	bus := events.NewBus()
+	pipeline := filter.NewPipeline(filters, filter.NewSpam(), filter.NewBlocklist(), filter.NewLinks())
+	return NewScheduler(schedules, rooms, filters, pipeline, bus), bus
+}
+
+// userEvents drains the events published to users so far
+func userEvents(bus *events.Bus) map[string][]string {
+	got := make(map[string][]string)
+	for {
+		select {
+		case event := <-bus.User():
+			got[event.UserID] = append(got[event.UserID], event.Message.Type)
+		default:
+			return got
+		}
+	}
+}
+
+func TestCheckMessage(t *testing.T) {
+	broken := errors.New("database is down")
+	tests := []struct {
+		name  string
+		err   error
+		want  error
+		later bool
+	}{
+		{name: "allowed"},
+		{name: "left the room", err: store.ErrNotRoomMember, want: store.ErrScheduledDropped},
+		{name: "lost the right to post", err: store.ErrForbidden, want: store.ErrScheduledDropped},
+		{name: "archived room", err: store.ErrRoomArchived, want: store.ErrScheduledDropped},
+		{name: "muted", err: store.ErrMuted, want: store.ErrScheduledPostponed, later: true},
+		{name: "failed check", err: broken, want: broken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rooms := &fakeRoomStore{denied: map[string]error{"alice": tt.err}}
+			scheduler, _ := newTestScheduler(&fakeScheduleStore{}, rooms, nil)
+
+			sendAt := time.Now()
+			scheduled := &store.ScheduledMessage{UserID: "alice", RoomID: "room", Content: "hello", SendAt: sendAt}
+			if _, _, err := scheduler.checkMessage(context.Background(), scheduled); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if later := scheduled.SendAt.After(sendAt); later != tt.later {
+				t.Fatalf("moved to %s", scheduled.SendAt)
+			}
+		})
+	}
+}
+
+func TestPollGetsPastMessagesThatCantBeSent(t *testing.T) {
+	schedules := &fakeScheduleStore{}
+	past := time.Now().Add(-time.Hour)
+
+	// A whole batch of undeliverable messages, all due before the one that
+	// can be sent
+	for i := 0; i < batchSize/2; i++ {
+		schedules.messages = append(schedules.messages,
+			&store.ScheduledMessage{ID: "muted", UserID: "muted", RoomID: "room", SendAt: past},
+			&store.ScheduledMessage{ID: "archived", UserID: "archived", RoomID: "room", SendAt: past},
+		)
+	}
+	schedules.messages = append(schedules.messages, &store.ScheduledMessage{
+		ID: "hello", UserID: "alice", RoomID: "room", Content: "hello", SendAt: past.Add(time.Minute),
+	})
+
+	rooms := &fakeRoomStore{denied: map[string]error{
+		"muted":    store.ErrMuted,
+		"archived": store.ErrRoomArchived,
+	}}
+	scheduler, bus := newTestScheduler(schedules, rooms, nil)
+
+	// A batch that sent nothing ends the poll instead of being claimed
+	// again straight away; the next poll gets past it
+	scheduler.poll()
+	if schedules.claims != 1 {
+		t.Fatalf("first poll claimed %d batches", schedules.claims)
+	}
+	scheduler.poll()
+
+	select {
+	case message := <-bus.Messages():
+		if message.Content != "hello" || message.Sender != "alice" {
+			t.Fatalf("sent %+v", message)
+		}
+	default:
+		t.Fatal("the message behind the undeliverable ones was not sent")
+	}
+
+	// The muted author's messages wait; the archived room's are gone
+	if len(schedules.messages) != batchSize/2 {
+		t.Fatalf("%d messages left, want %d", len(schedules.messages), batchSize/2)
+	}
+	for _, scheduled := range schedules.messages {
+		if scheduled.UserID != "muted" || !scheduled.SendAt.After(time.Now()) {
+			t.Fatalf("left %+v", scheduled)
+		}
+	}
+}
+
+func TestPollFiltersMessages(t *testing.T) {
+	filters := &fakeFilterStore{rules: []*store.FilterRule{
+		{Kind: store.RuleWord, Value: "heck", Action: store.FilterMask},
+		{Kind: store.RuleWord, Value: "darn", Action: store.FilterHold},
+		{Kind: store.RuleDenyDomain, Value: "spam.test", Action: store.FilterReject},
+	}}
+
+	tests := []struct {
+		name    string
+		content string
+		sent    string
+		held    bool
+		events  []string // What the author is told
+	}{
+		{name: "clean", content: "hello", sent: "hello"},
+		{name: "masked", content: "heck yes", sent: "**** yes"},
+		{name: "held", content: "darn it", held: true, events: []string{"message_held"}},
+		{name: "rejected", content: "see https://spam.test", events: []string{"message_rejected"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters.held = nil
+			schedules := &fakeScheduleStore{messages: []*store.ScheduledMessage{
+				{ID: "1", UserID: "alice", RoomID: "room", Content: tt.content, SendAt: time.Now().Add(-time.Minute)},
+			}}
+			scheduler, bus := newTestScheduler(schedules, &fakeRoomStore{}, filters)
+			scheduler.poll()
+
+			select {
+			case message := <-bus.Messages():
+				if message.Content != tt.sent {
+					t.Fatalf("sent %q, want %q", message.Content, tt.sent)
+				}
+			default:
+				if tt.sent != "" {
+					t.Fatal("nothing was sent")
+				}
+			}
+
+			if held := len(filters.held) > 0; held != tt.held {
+				t.Fatalf("held %v, want %v", held, tt.held)
+			}
+			if len(schedules.messages) != 0 {
+				t.Fatalf("left %+v", schedules.messages[0])
+			}
+
+			got := userEvents(bus)
+			if len(got["alice"]) != len(tt.events) || (len(tt.events) > 0 && got["alice"][0] != tt.events[0]) {
+				t.Fatalf("author was told %v, want %v", got["alice"], tt.events)
+			}
+			if tt.held && len(got["mod"]) != 1 {
+				t.Fatalf("reviewer was told %v", got["mod"])
+			}
+		})
+	}
+}
+
+func TestPollWaitsForSlowMode(t *testing.T) {
+	schedules := &fakeScheduleStore{messages: []*store.ScheduledMessage{
+		{ID: "1", UserID: "alice", RoomID: "room", Content: "hello", SendAt: time.Now().Add(-time.Minute)},
+	}}
+	scheduler, bus := newTestScheduler(schedules, &fakeRoomStore{wait: 30 * time.Second}, nil)
+	scheduler.poll()
+
+	select {
+	case message := <-bus.Messages():
+		t.Fatalf("sent %+v during slow mode", message)
+	default:
+	}
+	if len(schedules.messages) != 1 || !schedules.messages[0].SendAt.After(time.Now().Add(20*time.Second)) {
+		t.Fatalf("message was not moved to its next turn: %+v", schedules.messages)
+	}
+}
@@ -0,0 +1,178 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// Attachment is the metadata of an uploaded file. The file itself lives in
+// the blob store and is only reachable through signed download URLs.
+type Attachment struct {
+	ID           string    `json:"id"`
+	RoomID       string    `json:"room_id"`
+	UploaderID   string    `json:"uploader_id"`
+	MessageID    string    `json:"message_id,omitempty"`
+	Filename     string    `json:"filename"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	HasThumbnail bool      `json:"has_thumbnail"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	BlobKey      string `json:"-"`
+	ThumbnailKey string `json:"-"`
+}
+
+type AttachmentStore interface {
+	// Record an uploaded file; the ID is chosen by the caller with the blob key
+	CreateAttachment(ctx context.Context, attachment *Attachment) (*Attachment, error)
+
+	// Get an attachment by ID
+	GetAttachment(ctx context.Context, attachmentID string) (*Attachment, error)
+}
+
+type PostgresAttachmentStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAttachmentStore(db *sql.DB) *PostgresAttachmentStore {
+	return &PostgresAttachmentStore{db: db}
+}
+
+const attachmentColumns = `id, room_id, uploader_id, message_id, filename, content_type,
+        size, width, height, blob_key, thumbnail_key, created_at`
+
+func scanAttachment(row rowScanner) (*Attachment, error) {
+	attachment := &Attachment{}
+	var messageID, thumbnailKey sql.NullString
+	var width, height sql.NullInt64
+	err := row.Scan(
+		&attachment.ID,
+		&attachment.RoomID,
+		&attachment.UploaderID,
+		&messageID,
+		&attachment.Filename,
+		&attachment.ContentType,
+		&attachment.Size,
+		&width,
+		&height,
+		&attachment.BlobKey,
+		&thumbnailKey,
+		&attachment.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment.MessageID = messageID.String
+	attachment.ThumbnailKey = thumbnailKey.String
+	attachment.HasThumbnail = thumbnailKey.Valid
+	attachment.Width = int(width.Int64)
+	attachment.Height = int(height.Int64)
+
+	return attachment, nil
+}
+
+// CreateAttachment stores the metadata of an uploaded file
+func (s *PostgresAttachmentStore) CreateAttachment(ctx context.Context, attachment *Attachment) (*Attachment, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO attachments (id, room_id, uploader_id, filename, content_type,
+                                 size, width, height, blob_key, thumbnail_key)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING created_at
+    `
+	err = tx.QueryRowContext(
+		ctx,
+		query,
+		attachment.ID,
+		attachment.RoomID,
+		attachment.UploaderID,
+		attachment.Filename,
+		attachment.ContentType,
+		attachment.Size,
+		sql.NullInt64{Int64: int64(attachment.Width), Valid: attachment.Width > 0},
+		sql.NullInt64{Int64: int64(attachment.Height), Valid: attachment.Height > 0},
+		attachment.BlobKey,
+		nullString(attachment.ThumbnailKey),
+	).Scan(&attachment.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	attachment.HasThumbnail = attachment.ThumbnailKey != ""
+	return attachment, nil
+}
+
+// GetAttachment returns the metadata of an attachment
+func (s *PostgresAttachmentStore) GetAttachment(ctx context.Context, attachmentID string) (*Attachment, error) {
+	query := `
+        SELECT ` + attachmentColumns + `
+        FROM attachments
+        WHERE id = $1
+    `
+	attachment, err := scanAttachment(s.db.QueryRowContext(ctx, query, attachmentID))
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+// attachAttachments loads the attachments of a batch of messages in a single
+// query, in upload order
+func attachAttachments(ctx context.Context, q queryer, messages []*Message) error {
+	byID := make(map[string]*Message)
+	ids := []string{}
+	for _, message := range messages {
+		if message.HasAttachments && message.DeletedAt == nil {
+			byID[message.ID] = message
+			ids = append(ids, message.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+        SELECT ` + attachmentColumns + `
+        FROM attachments
+        WHERE message_id = ANY($1)
+        ORDER BY created_at, id
+    `
+	rows, err := q.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return err
+		}
+		if message, ok := byID[attachment.MessageID]; ok {
+			message.Attachments = append(message.Attachments, attachment)
+		}
+	}
+
+	return rows.Err()
+}
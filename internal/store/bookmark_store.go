@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Bookmark is a message a user saved for later
+type Bookmark struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Message   *Message  `json:"message"`
+}
+
+// BookmarkPage is a page of a user's bookmarks, newest first. Before is the
+// cursor for the next page, empty on the last one.
+type BookmarkPage struct {
+	Bookmarks []*Bookmark `json:"bookmarks"`
+	Before    string      `json:"before,omitempty"`
+}
+
+type BookmarkStore interface {
+	// Save a message for a user; reports false if it was already saved
+	AddBookmark(ctx context.Context, userID, messageID string) (bool, error)
+
+	// Forget a saved message; reports false if it was not saved
+	RemoveBookmark(ctx context.Context, userID, messageID string) (bool, error)
+
+	// Page through a user's bookmarks, newest first
+	GetBookmarks(ctx context.Context, userID, before string, limit int) (*BookmarkPage, error)
+}
+
+type PostgresBookmarkStore struct {
+	db *sql.DB
+}
+
+func NewPostgresBookmarkStore(db *sql.DB) *PostgresBookmarkStore {
+	return &PostgresBookmarkStore{db: db}
+}
+
+// AddBookmark saves a message. Saving twice is a no-op.
+func (s *PostgresBookmarkStore) AddBookmark(ctx context.Context, userID, messageID string) (bool, error) {
+	query := `
+        INSERT INTO bookmarks (user_id, message_id)
+        SELECT $1, $2
+        WHERE EXISTS (SELECT 1 FROM messages WHERE id = $2)
+        ON CONFLICT (user_id, message_id) DO NOTHING
+    `
+	result, err := s.db.ExecContext(ctx, query, userID, messageID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// RemoveBookmark deletes a bookmark
+func (s *PostgresBookmarkStore) RemoveBookmark(ctx context.Context, userID, messageID string) (bool, error) {
+	query := `
+        DELETE FROM bookmarks
+        WHERE user_id = $1 AND message_id = $2
+    `
+	result, err := s.db.ExecContext(ctx, query, userID, messageID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// GetBookmarks pages through a user's bookmarks. Like the mentions inbox it
+// skips deleted messages and rooms the user has left.
+func (s *PostgresBookmarkStore) GetBookmarks(ctx context.Context, userID, before string, limit int) (*BookmarkPage, error) {
+	if limit <= 0 {
+		limit = DefaultMessagePageSize
+	}
+	if limit > MaxMessagePageSize {
+		limit = MaxMessagePageSize
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT b.id, b.created_at, m.*
+        FROM bookmarks b
+        JOIN LATERAL (
+            SELECT ` + messageColumns + `
+            FROM messages
+            WHERE id = b.message_id AND deleted_at IS NULL AND ` + notExpired + `
+        ) m ON true
+        JOIN room_memberships rm ON rm.room_id = m.room AND rm.user_id = b.user_id
+        WHERE b.user_id = $1
+          AND ($2 = '' OR (b.created_at, b.id) < (
+              SELECT created_at, id FROM bookmarks WHERE id::text = $2 AND user_id = $1
+          ))
+        ORDER BY b.created_at DESC, b.id DESC
+        LIMIT $3
+    `
+	rows, err := tx.QueryContext(ctx, query, userID, before, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &BookmarkPage{Bookmarks: []*Bookmark{}}
+	for rows.Next() {
+		bookmark := &Bookmark{}
+		bookmark.Message, err = scanMessage(prefixScanner{
+			row:  rows,
+			dest: []any{&bookmark.ID, &bookmark.CreatedAt},
+		})
+		if err != nil {
+			return nil, err
+		}
+		page.Bookmarks = append(page.Bookmarks, bookmark)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Bookmarks) > limit {
+		page.Bookmarks = page.Bookmarks[:limit]
+		page.Before = page.Bookmarks[limit-1].ID
+	}
+
+	messages := make([]*Message, 0, len(page.Bookmarks))
+	for _, bookmark := range page.Bookmarks {
+		messages = append(messages, bookmark.Message)
+	}
+	err = attachDetails(ctx, tx, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
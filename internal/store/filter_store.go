@@ -0,0 +1,270 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrFilterRuleExists    = errors.New("the room already has this rule")
+	ErrFilterRuleNotFound  = errors.New("filter rule not found")
+	ErrHeldMessageNotFound = errors.New("held message not found")
+	ErrHeldMessageReviewed = errors.New("held message was already reviewed")
+)
+
+// What a filter rule matches
+const (
+	RuleWord        = "word"         // A whole word, in any case
+	RulePattern     = "pattern"      // A regular expression
+	RuleAllowDomain = "allow_domain" // Links may only go to these domains
+	RuleDenyDomain  = "deny_domain"  // Links may not go to these domains
+)
+
+// What happens to a message that breaks a rule
+const (
+	FilterReject = "reject" // It is not sent
+	FilterMask   = "mask"   // It is sent with the offending part blanked out
+	FilterHold   = "hold"   // It waits for a moderator
+)
+
+// FilterRule is one entry of a room's blocklists or link lists
+type FilterRule struct {
+	ID        string    `json:"id"`
+	RoomID    string    `json:"room_id"`
+	Kind      string    `json:"kind"`
+	Value     string    `json:"value"`
+	Action    string    `json:"action"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HeldMessage is a message waiting in a room's review queue
+type HeldMessage struct {
+	ID      string   `json:"id"`
+	RoomID  string   `json:"room_id"`
+	Sender  string   `json:"sender"`
+	Message *Message `json:"message"`
+
+	// The filter that held it and why
+	Filter string `json:"filter"`
+	Reason string `json:"reason,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedBy string     `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	Approved   bool       `json:"approved"`
+}
+
+type FilterStore interface {
+	// Get the filter rules of a room
+	GetFilterRules(ctx context.Context, roomID string) ([]*FilterRule, error)
+
+	// Add a rule to a room's filters
+	AddFilterRule(ctx context.Context, rule *FilterRule) (*FilterRule, error)
+
+	// Remove a rule from a room's filters
+	RemoveFilterRule(ctx context.Context, roomID, ruleID string) error
+
+	// Put a message in its room's review queue
+	HoldMessage(ctx context.Context, held *HeldMessage) (*HeldMessage, error)
+
+	// Get the messages of a room waiting for review, oldest first
+	GetHeldMessages(ctx context.Context, roomID string) ([]*HeldMessage, error)
+
+	// Let a held message through or turn it down
+	ReviewHeldMessage(ctx context.Context, roomID, heldID, reviewerID string, approve bool) (*HeldMessage, error)
+}
+
+type PostgresFilterStore struct {
+	db *sql.DB
+}
+
+func NewPostgresFilterStore(db *sql.DB) *PostgresFilterStore {
+	return &PostgresFilterStore{db: db}
+}
+
+// GetFilterRules returns a room's rules in the order they were added
+func (s *PostgresFilterStore) GetFilterRules(ctx context.Context, roomID string) ([]*FilterRule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, room_id, kind, value, action, created_by, created_at
+        FROM room_filter_rules
+        WHERE room_id = $1
+        ORDER BY created_at
+    `, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*FilterRule{}
+	for rows.Next() {
+		rule := &FilterRule{}
+		err = rows.Scan(&rule.ID, &rule.RoomID, &rule.Kind, &rule.Value, &rule.Action, &rule.CreatedBy, &rule.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// AddFilterRule stores a rule. The same value can only be listed once per
+// kind in a room.
+func (s *PostgresFilterStore) AddFilterRule(ctx context.Context, rule *FilterRule) (*FilterRule, error) {
+	err := s.db.QueryRowContext(ctx, `
+        INSERT INTO room_filter_rules (room_id, kind, value, action, created_by)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at
+    `, rule.RoomID, rule.Kind, rule.Value, rule.Action, rule.CreatedBy).Scan(&rule.ID, &rule.CreatedAt)
+	if err != nil && strings.Contains(err.Error(), "room_filter_rules_room_id_kind_value_key") {
+		return nil, ErrFilterRuleExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// RemoveFilterRule deletes a rule of a room
+func (s *PostgresFilterStore) RemoveFilterRule(ctx context.Context, roomID, ruleID string) error {
+	result, err := s.db.ExecContext(ctx, `
+        DELETE FROM room_filter_rules WHERE id::text = $1 AND room_id = $2
+    `, ruleID, roomID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrFilterRuleNotFound
+	}
+
+	return nil
+}
+
+const heldMessageColumns = `id, room_id, sender, message, filter, reason, created_at, reviewed_by, reviewed_at, approved`
+
+func scanHeldMessage(row rowScanner) (*HeldMessage, error) {
+	held := &HeldMessage{}
+	var message []byte
+	var reason, reviewedBy sql.NullString
+	var reviewedAt sql.NullTime
+	var approved sql.NullBool
+	err := row.Scan(
+		&held.ID,
+		&held.RoomID,
+		&held.Sender,
+		&message,
+		&held.Filter,
+		&reason,
+		&held.CreatedAt,
+		&reviewedBy,
+		&reviewedAt,
+		&approved,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	held.Message = &Message{}
+	if err = json.Unmarshal(message, held.Message); err != nil {
+		return nil, err
+	}
+	held.Reason = reason.String
+	held.ReviewedBy = reviewedBy.String
+	held.ReviewedAt = nullTime(reviewedAt)
+	held.Approved = approved.Bool
+	return held, nil
+}
+
+// HoldMessage stores a message for review. The message keeps everything
+// needed to send it later, the attachments it carries included.
+func (s *PostgresFilterStore) HoldMessage(ctx context.Context, held *HeldMessage) (*HeldMessage, error) {
+	message, err := json.Marshal(held.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanHeldMessage(s.db.QueryRowContext(ctx, `
+        INSERT INTO held_messages (room_id, sender, message, filter, reason)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING `+heldMessageColumns+`
+    `, held.RoomID, held.Sender, string(message), held.Filter, nullString(held.Reason)))
+}
+
+// GetHeldMessages returns the messages of a room that nobody reviewed yet
+func (s *PostgresFilterStore) GetHeldMessages(ctx context.Context, roomID string) ([]*HeldMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+heldMessageColumns+`
+        FROM held_messages
+        WHERE room_id = $1 AND reviewed_at IS NULL
+        ORDER BY created_at
+    `, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*HeldMessage{}
+	for rows.Next() {
+		held, err := scanHeldMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, held)
+	}
+
+	return messages, rows.Err()
+}
+
+// ReviewHeldMessage records a moderator's decision. A message is only
+// reviewed once; the row stays as the record of who decided what.
+func (s *PostgresFilterStore) ReviewHeldMessage(ctx context.Context, roomID, heldID, reviewerID string, approve bool) (*HeldMessage, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var reviewed bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT reviewed_at IS NOT NULL FROM held_messages
+        WHERE id::text = $1 AND room_id = $2
+        FOR UPDATE
+    `, heldID, roomID).Scan(&reviewed)
+	if err == sql.ErrNoRows {
+		return nil, ErrHeldMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reviewed {
+		return nil, ErrHeldMessageReviewed
+	}
+
+	held, err := scanHeldMessage(tx.QueryRowContext(ctx, `
+        UPDATE held_messages
+        SET reviewed_by = $2, reviewed_at = CURRENT_TIMESTAMP, approved = $3
+        WHERE id::text = $1
+        RETURNING `+heldMessageColumns+`
+    `, heldID, reviewerID, approve))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return held, nil
+}
@@ -0,0 +1,428 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInviteNotFound      = errors.New("invite not found, expired or used up")
+	ErrInvitationNotFound  = errors.New("invitation not found")
+	ErrJoinRequestNotFound = errors.New("join request not found")
+)
+
+// Invite is a shareable link into a room
+type Invite struct {
+	ID        string     `json:"id"`
+	RoomID    string     `json:"room_id"`
+	Code      string     `json:"code"`
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   int        `json:"max_uses,omitempty"` // Zero for unlimited
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Invitation asks one user to join a room
+type Invitation struct {
+	RoomID    string    `json:"room_id"`
+	UserID    string    `json:"user_id"`
+	InvitedBy string    `json:"invited_by"`
+	CreatedAt time.Time `json:"created_at"`
+	Room      *Room     `json:"room,omitempty"`
+}
+
+// JoinRequest is a user knocking on a private room
+type JoinRequest struct {
+	RoomID    string    `json:"room_id"`
+	UserID    string    `json:"user_id"`
+	Message   string    `json:"message,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type InviteStore interface {
+	// Create an invite link; the code is chosen by the caller
+	CreateInvite(ctx context.Context, invite *Invite) (*Invite, error)
+
+	// Get a room's invite links, newest first
+	GetInvites(ctx context.Context, roomID string) ([]*Invite, error)
+
+	// Delete one of a room's invite links
+	RevokeInvite(ctx context.Context, roomID, inviteID string) error
+
+	// Join the room an invite link leads to
+	JoinWithInvite(ctx context.Context, userID, code string) (*Room, error)
+
+	// Invite a user; reports false if they were invited already
+	InviteUser(ctx context.Context, roomID, userID, invitedBy string) (bool, error)
+
+	// Get a user's pending invitations, newest first
+	GetInvitations(ctx context.Context, userID string) ([]*Invitation, error)
+
+	// Turn down an invitation
+	DeclineInvitation(ctx context.Context, roomID, userID string) error
+
+	// Ask to join a room; public rooms are joined right away
+	RequestToJoin(ctx context.Context, roomID, userID, message string) (joined bool, err error)
+
+	// Get the pending requests to join a room, oldest first
+	GetJoinRequests(ctx context.Context, roomID string) ([]*JoinRequest, error)
+
+	// Let the user behind a request in, or turn them away
+	AnswerJoinRequest(ctx context.Context, roomID, userID string, approve bool) error
+}
+
+type PostgresInviteStore struct {
+	db *sql.DB
+}
+
+func NewPostgresInviteStore(db *sql.DB) *PostgresInviteStore {
+	return &PostgresInviteStore{db: db}
+}
+
+const inviteColumns = `id, room_id, code, created_by, expires_at, max_uses, uses, created_at`
+
+func scanInvite(row rowScanner) (*Invite, error) {
+	invite := &Invite{}
+	var expiresAt sql.NullTime
+	var maxUses sql.NullInt64
+	err := row.Scan(
+		&invite.ID,
+		&invite.RoomID,
+		&invite.Code,
+		&invite.CreatedBy,
+		&expiresAt,
+		&maxUses,
+		&invite.Uses,
+		&invite.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	invite.ExpiresAt = nullTime(expiresAt)
+	invite.MaxUses = int(maxUses.Int64)
+	return invite, nil
+}
+
+// CreateInvite stores an invite link
+func (s *PostgresInviteStore) CreateInvite(ctx context.Context, invite *Invite) (*Invite, error) {
+	query := `
+        INSERT INTO room_invites (room_id, code, created_by, expires_at, max_uses)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING ` + inviteColumns + `
+    `
+	return scanInvite(s.db.QueryRowContext(
+		ctx,
+		query,
+		invite.RoomID,
+		invite.Code,
+		invite.CreatedBy,
+		invite.ExpiresAt,
+		sql.NullInt64{Int64: int64(invite.MaxUses), Valid: invite.MaxUses > 0},
+	))
+}
+
+// GetInvites lists a room's invite links, spent ones included
+func (s *PostgresInviteStore) GetInvites(ctx context.Context, roomID string) ([]*Invite, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+inviteColumns+`
+        FROM room_invites
+        WHERE room_id = $1
+        ORDER BY created_at DESC
+    `, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []*Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+// RevokeInvite deletes an invite link so its code stops working
+func (s *PostgresInviteStore) RevokeInvite(ctx context.Context, roomID, inviteID string) error {
+	result, err := s.db.ExecContext(ctx, `
+        DELETE FROM room_invites WHERE id::text = $1 AND room_id = $2
+    `, inviteID, roomID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInviteNotFound
+	}
+
+	return nil
+}
+
+// JoinWithInvite lets a user in through an invite link whatever the room's
+// visibility. The link is locked while its uses are counted, and a user who
+// is a member already does not use it up.
+func (s *PostgresInviteStore) JoinWithInvite(ctx context.Context, userID, code string) (*Room, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var inviteID, roomID string
+	var isMember bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT i.id, i.room_id, EXISTS(
+            SELECT 1 FROM room_memberships WHERE room_id = i.room_id AND user_id = $2
+        )
+        FROM room_invites i
+        WHERE i.code = $1
+          AND (i.expires_at IS NULL OR i.expires_at > CURRENT_TIMESTAMP)
+          AND (i.max_uses IS NULL OR i.uses < i.max_uses)
+        FOR UPDATE OF i
+    `, code, userID).Scan(&inviteID, &roomID, &isMember)
+	if err == sql.ErrNoRows {
+		return nil, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !isMember {
+		_, err = tx.ExecContext(ctx, `UPDATE room_invites SET uses = uses + 1 WHERE id = $1`, inviteID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = addMember(ctx, tx, userID, roomID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            DELETE FROM room_invitations WHERE room_id = $1 AND user_id = $2
+        `, roomID, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	room, err := scanRoom(tx.QueryRowContext(ctx, `
+        SELECT `+roomColumns+` FROM rooms r WHERE r.id = $1
+    `, roomID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// InviteUser records an invitation. Inviting a member is a no-op.
+func (s *PostgresInviteStore) InviteUser(ctx context.Context, roomID, userID, invitedBy string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO room_invitations (room_id, user_id, invited_by)
+        SELECT $1, $2, $3
+        WHERE NOT EXISTS (
+            SELECT 1 FROM room_memberships WHERE room_id = $1 AND user_id = $2
+        )
+        ON CONFLICT (room_id, user_id) DO NOTHING
+    `, roomID, userID, invitedBy)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// GetInvitations lists a user's pending invitations with the rooms they are for
+func (s *PostgresInviteStore) GetInvitations(ctx context.Context, userID string) ([]*Invitation, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT i.room_id, i.user_id, i.invited_by, i.created_at, `+roomColumns+`
+        FROM room_invitations i
+        JOIN rooms r ON r.id = i.room_id
+        WHERE i.user_id = $1
+        ORDER BY i.created_at DESC
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+	for rows.Next() {
+		invitation := &Invitation{}
+		invitation.Room, err = scanRoom(prefixScanner{
+			row:  rows,
+			dest: []any{&invitation.RoomID, &invitation.UserID, &invitation.InvitedBy, &invitation.CreatedAt},
+		})
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	return invitations, rows.Err()
+}
+
+// DeclineInvitation deletes a pending invitation
+func (s *PostgresInviteStore) DeclineInvitation(ctx context.Context, roomID, userID string) error {
+	result, err := s.db.ExecContext(ctx, `
+        DELETE FROM room_invitations WHERE room_id = $1 AND user_id = $2
+    `, roomID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+// RequestToJoin knocks on a private room. Invite-only rooms can't be knocked
+// on, and there is nothing to ask for in a public room, so the user simply
+// joins it. Knocking again replaces the message.
+func (s *PostgresInviteStore) RequestToJoin(ctx context.Context, roomID, userID, message string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var visibility string
+	var archived, isMember bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT r.visibility, r.archived, EXISTS(
+            SELECT 1 FROM room_memberships WHERE room_id = r.id AND user_id = $2
+        )
+        FROM rooms r
+        WHERE r.id = $1 AND r.deleted_at IS NULL
+    `, roomID, userID).Scan(&visibility, &archived, &isMember)
+	if err == sql.ErrNoRows {
+		return false, ErrRoomNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	if isMember {
+		return true, nil
+	}
+	if archived {
+		return false, ErrRoomArchived
+	}
+
+	err = checkNotBanned(ctx, tx, roomID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	switch visibility {
+	case VisibilityInvite:
+		return false, ErrInvitationRequired
+
+	case VisibilityPublic:
+		err = addMember(ctx, tx, userID, roomID)
+
+	default:
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO room_join_requests (room_id, user_id, message)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (room_id, user_id) DO UPDATE SET message = EXCLUDED.message
+        `, roomID, userID, nullString(message))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return visibility == VisibilityPublic, nil
+}
+
+// GetJoinRequests lists the requests waiting on a room's moderators
+func (s *PostgresInviteStore) GetJoinRequests(ctx context.Context, roomID string) ([]*JoinRequest, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT room_id, user_id, COALESCE(message, ''), created_at
+        FROM room_join_requests
+        WHERE room_id = $1
+        ORDER BY created_at
+    `, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []*JoinRequest{}
+	for rows.Next() {
+		request := &JoinRequest{}
+		err = rows.Scan(&request.RoomID, &request.UserID, &request.Message, &request.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+
+	return requests, rows.Err()
+}
+
+// AnswerJoinRequest settles a request, adding the user when it is approved
+func (s *PostgresInviteStore) AnswerJoinRequest(ctx context.Context, roomID, userID string, approve bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        DELETE FROM room_join_requests WHERE room_id = $1 AND user_id = $2
+    `, roomID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrJoinRequestNotFound
+	}
+
+	if approve {
+		err = addMember(ctx, tx, userID, roomID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
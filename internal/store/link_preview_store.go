@@ -0,0 +1,183 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrLinkPreviewNotFound = errors.New("link preview not found")
+
+// LinkPreview is the card shown under a message for a URL it contains
+type LinkPreview struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	ImageURL    string    `json:"image_url,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	OK          bool      `json:"-"` // False when the page could not be unfurled
+	FetchedAt   time.Time `json:"-"`
+}
+
+type LinkPreviewStore interface {
+	// Get the cached preview of a URL
+	GetLinkPreview(ctx context.Context, url string) (*LinkPreview, error)
+
+	// Cache a preview, replacing any older one for the same URL
+	SaveLinkPreview(ctx context.Context, preview *LinkPreview) error
+
+	// Show previews of the given cached URLs under a message, in that order
+	AttachLinkPreviews(ctx context.Context, messageID string, urls []string) error
+}
+
+type PostgresLinkPreviewStore struct {
+	db *sql.DB
+}
+
+func NewPostgresLinkPreviewStore(db *sql.DB) *PostgresLinkPreviewStore {
+	return &PostgresLinkPreviewStore{db: db}
+}
+
+const linkPreviewColumns = `url, COALESCE(title, ''), COALESCE(description, ''),
+        COALESCE(image_url, ''), COALESCE(site_name, ''), ok, fetched_at`
+
+func scanLinkPreview(row rowScanner) (*LinkPreview, error) {
+	preview := &LinkPreview{}
+	err := row.Scan(
+		&preview.URL,
+		&preview.Title,
+		&preview.Description,
+		&preview.ImageURL,
+		&preview.SiteName,
+		&preview.OK,
+		&preview.FetchedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+// GetLinkPreview returns the cached preview of a URL, whether or not the page
+// could be unfurled
+func (s *PostgresLinkPreviewStore) GetLinkPreview(ctx context.Context, url string) (*LinkPreview, error) {
+	query := `
+        SELECT ` + linkPreviewColumns + `
+        FROM link_previews
+        WHERE url = $1
+    `
+	preview, err := scanLinkPreview(s.db.QueryRowContext(ctx, query, url))
+	if err == sql.ErrNoRows {
+		return nil, ErrLinkPreviewNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+// SaveLinkPreview caches a preview under its URL
+func (s *PostgresLinkPreviewStore) SaveLinkPreview(ctx context.Context, preview *LinkPreview) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+        INSERT INTO link_previews (url, title, description, image_url, site_name, ok, fetched_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (url) DO UPDATE
+        SET title = EXCLUDED.title,
+            description = EXCLUDED.description,
+            image_url = EXCLUDED.image_url,
+            site_name = EXCLUDED.site_name,
+            ok = EXCLUDED.ok,
+            fetched_at = EXCLUDED.fetched_at
+    `
+	_, err = tx.ExecContext(
+		ctx,
+		query,
+		preview.URL,
+		nullString(preview.Title),
+		nullString(preview.Description),
+		nullString(preview.ImageURL),
+		nullString(preview.SiteName),
+		preview.OK,
+		preview.FetchedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AttachLinkPreviews links cached previews to a message. Attaching the same
+// URL twice is a no-op.
+func (s *PostgresLinkPreviewStore) AttachLinkPreviews(ctx context.Context, messageID string, urls []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for position, url := range urls {
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO message_link_previews (message_id, url, position)
+            SELECT $1, $2, $3
+            WHERE EXISTS (SELECT 1 FROM messages WHERE id = $1)
+            ON CONFLICT (message_id, url) DO NOTHING
+        `, messageID, url, position)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// attachLinkPreviews loads the previews of a batch of messages in a single
+// query. Deleted messages lose their previews along with their content.
+func attachLinkPreviews(ctx context.Context, q queryer, messages []*Message) error {
+	byID := make(map[string]*Message)
+	ids := []string{}
+	for _, message := range messages {
+		if message.DeletedAt == nil {
+			byID[message.ID] = message
+			ids = append(ids, message.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+        SELECT mlp.message_id, ` + linkPreviewColumns + `
+        FROM message_link_previews mlp
+        JOIN link_previews USING (url)
+        WHERE mlp.message_id = ANY($1) AND ok
+        ORDER BY mlp.message_id, mlp.position
+    `
+	rows, err := q.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		preview, err := scanLinkPreview(prefixScanner{row: rows, dest: []any{&messageID}})
+		if err != nil {
+			return err
+		}
+		if message, ok := byID[messageID]; ok {
+			message.Previews = append(message.Previews, preview)
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Mention kinds, from most to least specific
+const (
+	MentionUser = "user" // @username
+	MentionHere = "here" // @here, members online when the message was sent
+	MentionRoom = "room" // @room, every member
+)
+
+// Mention records that a message called out a user
+type Mention struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+	Message   *Message  `json:"message"`
+}
+
+// MentionPage is a page of a user's mentions, newest first
+type MentionPage struct {
+	Mentions []*Mention `json:"mentions"`
+	Before   string     `json:"before,omitempty"` // Pass as before= to load older mentions
+}
+
+type MentionStore interface {
+	// Map usernames to the IDs of room members carrying them
+	ResolveUsernames(ctx context.Context, roomID string, usernames []string) (map[string]string, error)
+
+	// Store the mentions of a message, keyed by user ID with the mention kind
+	CreateMentions(ctx context.Context, message *Message, mentioned map[string]string) ([]*Mention, error)
+
+	// Get a page of the mentions of a user in rooms they are still in
+	GetUserMentions(ctx context.Context, userID, before string, limit int) (*MentionPage, error)
+}
+
+type PostgresMentionStore struct {
+	db *sql.DB
+}
+
+func NewPostgresMentionStore(db *sql.DB) *PostgresMentionStore {
+	return &PostgresMentionStore{db: db}
+}
+
+// ResolveUsernames looks usernames up case-insensitively among the members
+// of a room; names that match nobody in the room are left out
+func (s *PostgresMentionStore) ResolveUsernames(ctx context.Context, roomID string, usernames []string) (map[string]string, error) {
+	resolved := make(map[string]string)
+	if len(usernames) == 0 {
+		return resolved, nil
+	}
+
+	query := `
+        SELECT lower(u.username), u.id
+        FROM users u
+        JOIN room_memberships rm ON rm.user_id = u.id::text
+        WHERE rm.room_id = $1 AND lower(u.username) = ANY($2)
+    `
+	rows, err := s.db.QueryContext(ctx, query, roomID, usernames)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var username, userID string
+		if err = rows.Scan(&username, &userID); err != nil {
+			return nil, err
+		}
+		resolved[username] = userID
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return resolved, nil
+}
+
+// CreateMentions stores one record per mentioned user and bumps their unread
+// mention counter for the room
+func (s *PostgresMentionStore) CreateMentions(ctx context.Context, message *Message, mentioned map[string]string) ([]*Mention, error) {
+	if len(mentioned) == 0 {
+		return nil, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var mentions []*Mention
+	for userID, kind := range mentioned {
+		mention := &Mention{UserID: userID, Kind: kind, Message: message}
+		err = tx.QueryRowContext(ctx, `
+            INSERT INTO mentions (message_id, room_id, user_id, kind)
+            VALUES ($1, $2, $3, $4)
+            ON CONFLICT (message_id, user_id) DO NOTHING
+            RETURNING id, created_at
+        `, message.ID, message.Room, userID, kind).Scan(&mention.ID, &mention.CreatedAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            UPDATE room_memberships
+            SET unread_mentions = unread_mentions + 1
+            WHERE user_id = $1 AND room_id = $2
+        `, userID, message.Room)
+		if err != nil {
+			return nil, err
+		}
+
+		mentions = append(mentions, mention)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return mentions, nil
+}
+
+// GetUserMentions pages through a user's mentions, newest first. Mentions
+// in deleted messages or rooms the user has left are skipped.
+func (s *PostgresMentionStore) GetUserMentions(ctx context.Context, userID, before string, limit int) (*MentionPage, error) {
+	if limit <= 0 {
+		limit = DefaultMessagePageSize
+	}
+	if limit > MaxMessagePageSize {
+		limit = MaxMessagePageSize
+	}
+
+	query := `
+        SELECT mn.id, mn.user_id, mn.kind, mn.created_at, m.*
+        FROM mentions mn
+        JOIN room_memberships rm ON rm.room_id = mn.room_id AND rm.user_id = mn.user_id
+        JOIN LATERAL (
+            SELECT ` + messageColumns + `
+            FROM messages
+            WHERE id = mn.message_id AND deleted_at IS NULL AND ` + notExpired + `
+        ) m ON true
+        WHERE mn.user_id = $1
+          AND ($2 = '' OR (mn.created_at, mn.id) < (
+              SELECT created_at, id FROM mentions WHERE id::text = $2 AND user_id = $1
+          ))
+        ORDER BY mn.created_at DESC, mn.id DESC
+        LIMIT $3
+    `
+	rows, err := s.db.QueryContext(ctx, query, userID, before, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &MentionPage{Mentions: []*Mention{}}
+	for rows.Next() {
+		mention := &Mention{}
+		mention.Message, err = scanMessage(prefixScanner{
+			row:  rows,
+			dest: []any{&mention.ID, &mention.UserID, &mention.Kind, &mention.CreatedAt},
+		})
+		if err != nil {
+			return nil, err
+		}
+		page.Mentions = append(page.Mentions, mention)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Mentions) > limit {
+		page.Mentions = page.Mentions[:limit]
+		page.Before = page.Mentions[limit-1].ID
+	}
+
+	return page, nil
+}
+
+// prefixScanner scans a row whose leading columns precede a set that another
+// scan function knows how to read
+type prefixScanner struct {
+	row  rowScanner
+	dest []any
+}
+
+func (p prefixScanner) Scan(dest ...any) error {
+	return p.row.Scan(append(p.dest, dest...)...)
+}
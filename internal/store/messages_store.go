@@ -1,18 +1,65 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"html"
+	"slices"
+	"strings"
 	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/markup"
+)
+
+var ErrMessageNotFound = errors.New("message not found")
+
+const (
+	DefaultMessagePageSize = 50
+	MaxMessagePageSize     = 100
 )
 
 type Message struct {
 	ID      string    `json:"id"`
 	Type    string    `json:"type"` // e.g., "chat", "notification", "error"
 	Room    string    `json:"room"`
-	Content string    `json:"content"` // The actual message content
-	Sender  string    `json:"sender"`  // Who sent the message
-	Time    time.Time `json:"time"`    // When the message was sent
+	Content string    `json:"content"`          // The actual message content, exactly as written
+	Format  string    `json:"format,omitempty"` // FormatPlain or FormatMarkdown
+	HTML    string    `json:"html,omitempty"`   // Sanitized rendering of markdown content
+	Sender  string    `json:"sender"`           // Who sent the message
+	Time    time.Time `json:"time"`             // When the message was sent
+	Seq     int64     `json:"seq,omitempty"`    // Position within the room, or within the thread for replies
+
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Purged from the room once passed
+
+	ParentID    string     `json:"parent_id,omitempty"` // Thread the message replies to
+	QuoteID     string     `json:"quote_id,omitempty"`  // Message quoted in the main timeline
+	ReplyCount  int        `json:"reply_count,omitempty"`
+	LastReplyAt *time.Time `json:"last_reply_at,omitempty"`
+
+	Reactions   []*Reaction    `json:"reactions,omitempty"`
+	Attachments []*Attachment  `json:"attachments,omitempty"`
+	Previews    []*LinkPreview `json:"previews,omitempty"`
+
+	HasAttachments bool `json:"-"`
+
+	// Resolved by the read pump before the message is stored: mentioned user
+	// IDs with their mention kind, and whether @here still needs expanding
+	// against who is online
+	Mentions    map[string]string `json:"-"`
+	MentionHere bool              `json:"-"`
+}
+
+// MessageEdit is a previous version of an edited message
+type MessageEdit struct {
+	ID        string    `json:"id"`
+	MessageID string    `json:"message_id"`
+	Content   string    `json:"content"`
+	EditedBy  string    `json:"edited_by"`
+	EditedAt  time.Time `json:"edited_at"`
 }
 
 type PostgresMessagesStore struct {
@@ -23,27 +70,147 @@ func NewPostgresMessageStore(db *sql.DB) *PostgresMessagesStore {
 	return &PostgresMessagesStore{db: db}
 }
 
+// MessagePageQuery selects a window of a room's history. At most one of
+// Before, After and Around may be set; with none set the latest messages
+// are returned.
+type MessagePageQuery struct {
+	Before string // Messages older than this message ID
+	After  string // Messages newer than this message ID
+	Around string // This message ID with its neighbours on both sides
+	Limit  int
+}
+
+// MessagePage is a window of history, newest first, with cursors for the
+// adjacent windows. A cursor is empty when there is nothing more that way.
+type MessagePage struct {
+	Messages []*Message `json:"messages"`
+	Before   string     `json:"before,omitempty"` // Pass as before= to load older messages
+	After    string     `json:"after,omitempty"`  // Pass as after= to load newer messages
+}
+
 type MessageStore interface {
 	CreateMessage(*Message) (*Message, error)
 	GetMessages(roomId string) ([]*Message, error)
+
+	// Get a page of a room's history using keyset pagination
+	GetMessagePage(ctx context.Context, roomID string, query MessagePageQuery) (*MessagePage, error)
+
+	// Get a single message by ID
+	GetMessage(ctx context.Context, messageID string) (*Message, error)
+
+	// Replace a message's content, keeping the previous version in its history
+	EditMessage(ctx context.Context, messageID, editorID, content string) (*Message, error)
+
+	// Soft-delete a message; it stays in the timeline as an empty tombstone
+	DeleteMessage(ctx context.Context, messageID, deletedBy string) (*Message, error)
+
+	// Get the previous versions of a message, oldest first
+	GetMessageEdits(ctx context.Context, messageID string) ([]*MessageEdit, error)
+
+	// Get a page of the replies to a message
+	GetThreadPage(ctx context.Context, parentID string, query MessagePageQuery) (*MessagePage, error)
+
+	// Get everyone who started or replied to a thread
+	GetThreadParticipants(ctx context.Context, parentID string) ([]string, error)
+
+	// Full-text search over the rooms a user belongs to
+	SearchMessages(ctx context.Context, userID string, query SearchQuery) (*SearchPage, error)
+
+	// Delete up to limit expired messages, returning them with their attachments
+	PurgeExpiredMessages(ctx context.Context, limit int) ([]*Message, error)
 }
 
-func (pg *PostgresMessagesStore) GetMessages(roomId string) ([]*Message, error) {
-	tx, err := pg.db.Begin()
-	if err != nil {
+// Formats a message's content can be written in
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+// renderContent returns the HTML stored next to a message's content; plain
+// messages have none
+func renderContent(format, content string) string {
+	if format != FormatMarkdown {
+		return ""
+	}
+	return markup.Render(content)
+}
+
+// Expired messages are hidden from every read as soon as they expire, before
+// the purge worker gets to delete them
+const notExpired = `(expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`
 
+// MaxMessageTTL is the longest lifetime a self-destructing message can have,
+// whether it asks for one or gets its room's
+const MaxMessageTTL = 30 * 24 * time.Hour
+
+// Deleted messages keep their place in the timeline but never their content
+const messageColumns = `id, type, room,
+        CASE WHEN deleted_at IS NULL THEN content ELSE '' END AS content,
+        format, CASE WHEN deleted_at IS NULL THEN content_html END AS content_html,
+        sender, time, seq, edited_at, deleted_at, expires_at,
+        parent_id, quote_id, reply_count, last_reply_at, has_attachments`
+
+func scanMessage(row rowScanner) (*Message, error) {
+	message := &Message{}
+	var editedAt, deletedAt, expiresAt, lastReplyAt sql.NullTime
+	var parentID, quoteID, contentHTML sql.NullString
+	err := row.Scan(
+		&message.ID,
+		&message.Type,
+		&message.Room,
+		&message.Content,
+		&message.Format,
+		&contentHTML,
+		&message.Sender,
+		&message.Time,
+		&message.Seq,
+		&editedAt,
+		&deletedAt,
+		&expiresAt,
+		&parentID,
+		&quoteID,
+		&message.ReplyCount,
+		&lastReplyAt,
+		&message.HasAttachments,
+	)
+	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
 
-	query := `
-        SELECT id, type, room, content, sender, time 
-        FROM messages
-        WHERE room = $1 
-        ORDER BY time DESC
-    `
+	message.HTML = contentHTML.String
+	message.ParentID = parentID.String
+	message.QuoteID = quoteID.String
+	if lastReplyAt.Valid {
+		message.LastReplyAt = &lastReplyAt.Time
+	}
+
+	if editedAt.Valid {
+		message.EditedAt = &editedAt.Time
+	}
+	if deletedAt.Valid {
+		message.DeletedAt = &deletedAt.Time
+	}
+	if expiresAt.Valid {
+		message.ExpiresAt = &expiresAt.Time
+	}
+
+	return message, nil
+}
+
+// attachDetails loads what lives outside the messages table for a batch of
+// messages: reactions, attachments and link previews
+func attachDetails(ctx context.Context, q queryer, messages []*Message) error {
+	if err := attachReactions(ctx, q, messages); err != nil {
+		return err
+	}
+	if err := attachAttachments(ctx, q, messages); err != nil {
+		return err
+	}
+	return attachLinkPreviews(ctx, q, messages)
+}
 
-	rows, err := tx.Query(query, roomId)
+func queryMessages(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]*Message, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -51,15 +218,7 @@ func (pg *PostgresMessagesStore) GetMessages(roomId string) ([]*Message, error)
 
 	var messages []*Message
 	for rows.Next() {
-		message := &Message{}
-		err = rows.Scan(
-			&message.ID,
-			&message.Type,
-			&message.Room,
-			&message.Content,
-			&message.Sender,
-			&message.Time,
-		)
+		message, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -70,6 +229,34 @@ func (pg *PostgresMessagesStore) GetMessages(roomId string) ([]*Message, error)
 		return nil, err
 	}
 
+	return messages, nil
+}
+
+func (pg *PostgresMessagesStore) GetMessages(roomId string) ([]*Message, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT ` + messageColumns + `
+        FROM messages
+        WHERE room = $1 AND parent_id IS NULL AND ` + notExpired + `
+        ORDER BY time DESC
+    `
+
+	messages, err := queryMessages(context.Background(), tx, query, roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = attachDetails(context.Background(), tx, messages)
+	if err != nil {
+		return nil, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return nil, err
@@ -85,16 +272,102 @@ func (pg *PostgresMessagesStore) CreateMessage(message *Message) (*Message, erro
 	}
 	defer tx.Rollback()
 
+	if message.Time.IsZero() {
+		message.Time = time.Now()
+	}
+
+	if message.ParentID != "" {
+		// Replies are numbered within their thread and leave the room
+		// sequence alone, so they never count as unread room messages.
+		// Threads are one level deep: replies cannot have replies.
+		err = tx.QueryRow(`
+            UPDATE messages
+            SET reply_count = reply_count + 1, last_reply_at = $3
+            WHERE id = $1 AND room = $2 AND parent_id IS NULL AND deleted_at IS NULL AND `+notExpired+`
+            RETURNING reply_count
+        `, message.ParentID, message.Room, message.Time).Scan(&message.Seq)
+	} else {
+		// Claim the next position in the room. The row lock on rooms also
+		// serializes concurrent inserts so sequence numbers never collide.
+		// Activity is the database's clock, whatever the message says.
+		err = tx.QueryRow(`
+            UPDATE rooms
+            SET last_seq = last_seq + 1,
+                last_message_at = CURRENT_TIMESTAMP
+            WHERE id = $1
+            RETURNING last_seq
+        `, message.Room).Scan(&message.Seq)
+	}
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if message.QuoteID != "" {
+		var exists bool
+		err = tx.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM messages WHERE id = $1 AND room = $2 AND deleted_at IS NULL)`,
+			message.QuoteID, message.Room,
+		).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrMessageNotFound
+		}
+	}
+
+	if message.ExpiresAt == nil {
+		var roomTTL sql.NullInt64
+		err = tx.QueryRow(`SELECT message_ttl FROM rooms WHERE id = $1`, message.Room).Scan(&roomTTL)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if roomTTL.Valid {
+			expiresAt := message.Time.Add(time.Duration(roomTTL.Int64) * time.Second)
+			message.ExpiresAt = &expiresAt
+		}
+	}
+
+	message.HasAttachments = len(message.Attachments) > 0
+	if message.Format == "" {
+		message.Format = FormatPlain
+	}
+	message.HTML = renderContent(message.Format, message.Content)
+
 	query :=
-		`INSERT INTO Messages (type, room, content, sender, time)
-  VALUES ($1, $2, $3, $4, $5)
+		`INSERT INTO Messages (type, room, content, format, content_html, sender, time, seq, parent_id, quote_id, has_attachments, expires_at)
+  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
   RETURNING id
   `
-	err = tx.QueryRow(query, message.Type, message.Room, message.Content, message.Sender, message.Time).Scan(&message.ID)
+	err = tx.QueryRow(
+		query,
+		message.Type,
+		message.Room,
+		message.Content,
+		message.Format,
+		nullString(message.HTML),
+		message.Sender,
+		message.Time,
+		message.Seq,
+		nullString(message.ParentID),
+		nullString(message.QuoteID),
+		message.HasAttachments,
+		message.ExpiresAt,
+	).Scan(&message.ID)
 	if err != nil {
 		return nil, err
 	}
 
+	if message.HasAttachments {
+		err = linkAttachments(tx, message)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return nil, err
@@ -103,3 +376,608 @@ func (pg *PostgresMessagesStore) CreateMessage(message *Message) (*Message, erro
 	fmt.Println("message successfully created", message)
 	return message, nil
 }
+
+// timeline is an ordered stream of messages that pages walk through: either
+// a room's main timeline or the replies of one thread
+type timeline struct {
+	filter string // SQL condition on $1
+	key    string // Bound to $1
+}
+
+// Thread replies live in messages too but stay out of the room timeline
+func roomTimeline(roomID string) timeline {
+	return timeline{filter: "room = $1 AND parent_id IS NULL AND " + notExpired, key: roomID}
+}
+
+func threadTimeline(parentID string) timeline {
+	return timeline{filter: "parent_id = $1 AND " + notExpired, key: parentID}
+}
+
+// GetMessagePage returns a page of a room's main timeline
+func (pg *PostgresMessagesStore) GetMessagePage(ctx context.Context, roomID string, query MessagePageQuery) (*MessagePage, error) {
+	return pg.getPage(ctx, roomTimeline(roomID), query)
+}
+
+// GetThreadPage returns a page of the replies to a message
+func (pg *PostgresMessagesStore) GetThreadPage(ctx context.Context, parentID string, query MessagePageQuery) (*MessagePage, error) {
+	return pg.getPage(ctx, threadTimeline(parentID), query)
+}
+
+// getPage walks the (time, id) ordered index of a timeline from an anchor
+// message, so the cost of a page does not depend on how deep into the
+// history it is. The plain time bound next to each row comparison is what
+// lets Postgres skip the monthly partitions on the far side of the anchor.
+func (pg *PostgresMessagesStore) getPage(ctx context.Context, tl timeline, query MessagePageQuery) (*MessagePage, error) {
+	if query.Limit <= 0 {
+		query.Limit = DefaultMessagePageSize
+	}
+	if query.Limit > MaxMessagePageSize {
+		query.Limit = MaxMessagePageSize
+	}
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var older, newer []*Message
+	var moreBefore, moreAfter bool
+
+	switch {
+	case query.Before != "":
+		anchor, err := getAnchor(ctx, tx, tl, query.Before)
+		if err != nil {
+			return nil, err
+		}
+		older, moreBefore, err = messagesBefore(ctx, tx, tl, anchor, query.Limit)
+		if err != nil {
+			return nil, err
+		}
+		moreAfter = true
+
+	case query.After != "":
+		anchor, err := getAnchor(ctx, tx, tl, query.After)
+		if err != nil {
+			return nil, err
+		}
+		newer, moreAfter, err = messagesAfter(ctx, tx, tl, anchor, query.Limit)
+		if err != nil {
+			return nil, err
+		}
+		moreBefore = true
+
+	case query.Around != "":
+		anchor, err := getAnchor(ctx, tx, tl, query.Around)
+		if err != nil {
+			return nil, err
+		}
+		// The anchor itself takes one slot; the rest is split between both sides
+		olderLimit := (query.Limit - 1) / 2
+		newerLimit := query.Limit - 1 - olderLimit
+		older, moreBefore, err = messagesBefore(ctx, tx, tl, anchor, olderLimit)
+		if err != nil {
+			return nil, err
+		}
+		newer, moreAfter, err = messagesAfter(ctx, tx, tl, anchor, newerLimit)
+		if err != nil {
+			return nil, err
+		}
+		older = append([]*Message{anchor}, older...)
+
+	default:
+		older, moreBefore, err = messagesBefore(ctx, tx, tl, nil, query.Limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	page := &MessagePage{Messages: append(newer, older...)}
+	err = attachDetails(ctx, tx, page.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	if page.Messages == nil {
+		page.Messages = []*Message{}
+	}
+	if n := len(page.Messages); n > 0 {
+		if moreBefore {
+			page.Before = page.Messages[n-1].ID
+		}
+		if moreAfter {
+			page.After = page.Messages[0].ID
+		}
+	}
+
+	return page, nil
+}
+
+// getAnchor loads the message a page is positioned relative to, which must
+// belong to the timeline being paged
+func getAnchor(ctx context.Context, tx *sql.Tx, tl timeline, messageID string) (*Message, error) {
+	query := `
+        SELECT ` + messageColumns + `
+        FROM messages
+        WHERE id = $2 AND ` + tl.filter
+	message, err := scanMessage(tx.QueryRowContext(ctx, query, tl.key, messageID))
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// messagesBefore returns up to limit messages older than anchor, newest
+// first, and whether there are more beyond them. A nil anchor starts from
+// the latest message.
+func messagesBefore(ctx context.Context, tx *sql.Tx, tl timeline, anchor *Message, limit int) ([]*Message, bool, error) {
+	if limit <= 0 {
+		return nil, anchor != nil, nil
+	}
+
+	var messages []*Message
+	var err error
+	if anchor == nil {
+		messages, err = queryMessages(ctx, tx, `
+            SELECT `+messageColumns+`
+            FROM messages
+            WHERE `+tl.filter+`
+            ORDER BY time DESC, id DESC
+            LIMIT $2
+        `, tl.key, limit+1)
+	} else {
+		messages, err = queryMessages(ctx, tx, `
+            SELECT `+messageColumns+`
+            FROM messages
+            WHERE `+tl.filter+` AND time <= $2 AND (time, id) < ($2, $3)
+            ORDER BY time DESC, id DESC
+            LIMIT $4
+        `, tl.key, anchor.Time, anchor.ID, limit+1)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(messages) > limit {
+		return messages[:limit], true, nil
+	}
+	return messages, false, nil
+}
+
+// messagesAfter returns up to limit messages newer than anchor, newest
+// first, and whether there are more beyond them
+func messagesAfter(ctx context.Context, tx *sql.Tx, tl timeline, anchor *Message, limit int) ([]*Message, bool, error) {
+	if limit <= 0 {
+		return nil, true, nil
+	}
+
+	messages, err := queryMessages(ctx, tx, `
+        SELECT `+messageColumns+`
+        FROM messages
+        WHERE `+tl.filter+` AND time >= $2 AND (time, id) > ($2, $3)
+        ORDER BY time ASC, id ASC
+        LIMIT $4
+    `, tl.key, anchor.Time, anchor.ID, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	more := len(messages) > limit
+	if more {
+		messages = messages[:limit]
+	}
+	slices.Reverse(messages)
+
+	return messages, more, nil
+}
+
+// GetMessage returns a single message
+func (pg *PostgresMessagesStore) GetMessage(ctx context.Context, messageID string) (*Message, error) {
+	query := `
+        SELECT ` + messageColumns + `
+        FROM messages
+        WHERE id = $1 AND ` + notExpired + `
+    `
+	message, err := scanMessage(pg.db.QueryRowContext(ctx, query, messageID))
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = attachDetails(ctx, pg.db, []*Message{message})
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// EditMessage stores the current content in message_edits and replaces it.
+// Deleted messages cannot be edited.
+func (pg *PostgresMessagesStore) EditMessage(ctx context.Context, messageID, editorID, content string) (*Message, error) {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous sql.NullString
+	var format string
+	err = tx.QueryRowContext(ctx, `
+        SELECT content, format FROM messages
+        WHERE id = $1 AND deleted_at IS NULL AND `+notExpired+`
+        FOR UPDATE
+    `, messageID).Scan(&previous, &format)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO message_edits (message_id, content, edited_by)
+        VALUES ($1, $2, $3)
+    `, messageID, previous.String, editorID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        UPDATE messages
+        SET content = $2, content_html = $3, edited_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING ` + messageColumns
+	contentHTML := nullString(renderContent(format, content))
+	message, err := scanMessage(tx.QueryRowContext(ctx, query, messageID, content, contentHTML))
+	if err != nil {
+		return nil, err
+	}
+
+	// Clients swap the whole message in place, so it has to come complete
+	err = attachDetails(ctx, tx, []*Message{message})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// DeleteMessage marks a message as deleted and takes down its pin. Deleting
+// twice is an error so callers don't announce the same deletion again.
+func (pg *PostgresMessagesStore) DeleteMessage(ctx context.Context, messageID, deletedBy string) (*Message, error) {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE messages
+        SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $2
+        WHERE id = $1 AND deleted_at IS NULL
+        RETURNING ` + messageColumns
+	message, err := scanMessage(tx.QueryRowContext(ctx, query, messageID, deletedBy))
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM pinned_messages WHERE message_id = $1`, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// GetMessageEdits returns the edit history of a message, oldest first
+func (pg *PostgresMessagesStore) GetMessageEdits(ctx context.Context, messageID string) ([]*MessageEdit, error) {
+	query := `
+        SELECT id, message_id, COALESCE(content, ''), edited_by, edited_at
+        FROM message_edits
+        WHERE message_id = $1
+        ORDER BY edited_at, id
+    `
+	rows, err := pg.db.QueryContext(ctx, query, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []*MessageEdit{}
+	for rows.Next() {
+		edit := &MessageEdit{}
+		err = rows.Scan(
+			&edit.ID,
+			&edit.MessageID,
+			&edit.Content,
+			&edit.EditedBy,
+			&edit.EditedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return edits, nil
+}
+
+// GetThreadParticipants returns the author of a thread's root message and
+// everyone who has replied to it
+func (pg *PostgresMessagesStore) GetThreadParticipants(ctx context.Context, parentID string) ([]string, error) {
+	query := `
+        SELECT DISTINCT sender
+        FROM messages
+        WHERE id = $1 OR parent_id = $1
+    `
+	rows, err := pg.db.QueryContext(ctx, query, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var participants []string
+	for rows.Next() {
+		var userID string
+		if err = rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		participants = append(participants, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return participants, nil
+}
+
+// nullString maps an empty optional reference to NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// Search result orderings
+const (
+	SearchByRelevance = "relevance"
+	SearchByRecency   = "recent"
+)
+
+// SearchQuery describes a full-text search over the rooms a user is in.
+// Zero-valued filters are ignored.
+type SearchQuery struct {
+	Text          string
+	RoomID        string
+	SenderID      string
+	From          time.Time
+	To            time.Time
+	HasAttachment *bool
+	OrderBy       string
+	Offset        int
+	Limit         int
+}
+
+// SearchResult is a matching message with the matched terms highlighted
+type SearchResult struct {
+	Message *Message `json:"message"`
+	Snippet string   `json:"snippet"` // HTML-escaped, matches wrapped in <mark>
+	Rank    float64  `json:"rank"`
+}
+
+type SearchPage struct {
+	Results    []*SearchResult `json:"results"`
+	NextOffset int             `json:"next_offset,omitempty"` // Pass as offset= for the next page
+}
+
+// ts_headline marks matches with private-use characters, which are swapped
+// for <mark> tags only after the snippet has been HTML-escaped
+const (
+	highlightStart = "\uE000"
+	highlightStop  = "\uE001"
+)
+
+var highlighter = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// SearchMessages runs a full-text search over the rooms the user belongs to
+func (pg *PostgresMessagesStore) SearchMessages(ctx context.Context, userID string, query SearchQuery) (*SearchPage, error) {
+	if query.Limit <= 0 {
+		query.Limit = DefaultMessagePageSize
+	}
+	if query.Limit > MaxMessagePageSize {
+		query.Limit = MaxMessagePageSize
+	}
+
+	args := []any{userID, query.Text}
+	conds := []string{
+		"m.room IN (SELECT room_id FROM room_memberships WHERE user_id = $1)",
+		"m.search_vector @@ q.query",
+		"m.deleted_at IS NULL",
+		"(m.expires_at IS NULL OR m.expires_at > CURRENT_TIMESTAMP)",
+	}
+	filter := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if query.RoomID != "" {
+		filter("m.room = $%d", query.RoomID)
+	}
+	if query.SenderID != "" {
+		filter("m.sender = $%d", query.SenderID)
+	}
+	if !query.From.IsZero() {
+		filter("m.time >= $%d", query.From)
+	}
+	if !query.To.IsZero() {
+		filter("m.time < $%d", query.To)
+	}
+	if query.HasAttachment != nil {
+		filter("m.has_attachments = $%d", *query.HasAttachment)
+	}
+
+	order := "rank DESC, time DESC, id DESC"
+	if query.OrderBy == SearchByRecency {
+		order = "time DESC, id DESC"
+	}
+
+	args = append(args, query.Limit+1, query.Offset)
+	limitArg, offsetArg := len(args)-1, len(args)
+
+	args = append(args, "StartSel="+highlightStart+", StopSel="+highlightStop+
+		", MaxFragments=2, MaxWords=20, MinWords=5, FragmentDelimiter=\" … \"")
+	optionsArg := len(args)
+
+	// Snippets are only built for the rows on the page
+	sqlQuery := fmt.Sprintf(`
+        SELECT ts_headline('english', coalesce(content, ''), query, $%d), rank, `+messageColumns+`
+        FROM (
+            SELECT m.*, q.query, ts_rank(m.search_vector, q.query) AS rank
+            FROM messages m, websearch_to_tsquery('english', $2) AS q(query)
+            WHERE %s
+            ORDER BY %s
+            LIMIT $%d OFFSET $%d
+        ) m
+        ORDER BY %s
+    `, optionsArg, strings.Join(conds, " AND "), order, limitArg, offsetArg, order)
+
+	rows, err := pg.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &SearchPage{Results: []*SearchResult{}}
+	for rows.Next() {
+		result := &SearchResult{}
+		result.Message, err = scanMessage(prefixScanner{
+			row:  rows,
+			dest: []any{&result.Snippet, &result.Rank},
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Snippet = highlighter.Replace(html.EscapeString(result.Snippet))
+		page.Results = append(page.Results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Results) > query.Limit {
+		page.Results = page.Results[:query.Limit]
+		page.NextOffset = query.Offset + query.Limit
+	}
+
+	return page, nil
+}
+
+// linkAttachments claims the uploaded attachments for a new message. Each one
+// must have been uploaded by the sender to the same room and not be in use.
+func linkAttachments(tx *sql.Tx, message *Message) error {
+	ids := make([]string, 0, len(message.Attachments))
+	for _, attachment := range message.Attachments {
+		ids = append(ids, attachment.ID)
+	}
+
+	result, err := tx.Exec(`
+        UPDATE attachments
+        SET message_id = $1
+        WHERE id = ANY($2) AND room_id = $3 AND uploader_id = $4 AND message_id IS NULL
+    `, message.ID, ids, message.Room, message.Sender)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(ids)) {
+		return ErrAttachmentNotFound
+	}
+
+	for _, attachment := range message.Attachments {
+		attachment.MessageID = message.ID
+	}
+
+	return nil
+}
+
+// PurgeExpiredMessages hard-deletes a batch of expired messages. Their
+// attachments are returned so the caller can remove the files as well; the
+// rows go with the message. Reply counts are left alone because replies are
+// numbered by them.
+func (pg *PostgresMessagesStore) PurgeExpiredMessages(ctx context.Context, limit int) ([]*Message, error) {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	messages, err := queryMessages(ctx, tx, `
+        SELECT `+messageColumns+`
+        FROM messages
+        WHERE expires_at <= CURRENT_TIMESTAMP
+        ORDER BY expires_at
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return messages, nil
+	}
+
+	err = attachAttachments(ctx, tx, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM messages WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
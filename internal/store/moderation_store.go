@@ -0,0 +1,367 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrBanned       = errors.New("user is banned from this room")
+	ErrMuted        = errors.New("user is muted in this room")
+	ErrBanNotFound  = errors.New("ban not found")
+	ErrMuteNotFound = errors.New("mute not found")
+)
+
+// Moderation actions, as recorded in the moderation log
+const (
+	ModerationKick   = "kick"
+	ModerationBan    = "ban"
+	ModerationUnban  = "unban"
+	ModerationMute   = "mute"
+	ModerationUnmute = "unmute"
+)
+
+// ModerationEntry is one action in a room's moderation log
+type ModerationEntry struct {
+	ID        string     `json:"id"`
+	RoomID    string     `json:"room_id"`
+	Action    string     `json:"action"`
+	ActorID   string     `json:"actor_id"`
+	TargetID  string     `json:"target_id"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ModerationLogPage is a page of a room's moderation log, newest first
+type ModerationLogPage struct {
+	Entries []*ModerationEntry `json:"entries"`
+
+	// Cursor for the next, older page; empty on the last page
+	Before string `json:"before,omitempty"`
+}
+
+// Ban keeps a user out of a room
+type Ban struct {
+	RoomID    string     `json:"room_id"`
+	UserID    string     `json:"user_id"`
+	BannedBy  string     `json:"banned_by"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Nil for a permanent ban
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type ModerationStore interface {
+	// Remove a member from a room; they can join again
+	Kick(ctx context.Context, roomID, userID, actorID, reason string) (*ModerationEntry, error)
+
+	// Remove a user from a room and keep them out until expiresAt, or for good if nil
+	Ban(ctx context.Context, roomID, userID, actorID, reason string, expiresAt *time.Time) (*ModerationEntry, error)
+
+	// Lift a ban
+	Unban(ctx context.Context, roomID, userID, actorID string) (*ModerationEntry, error)
+
+	// Stop a user posting in a room until expiresAt
+	Mute(ctx context.Context, roomID, userID, actorID, reason string, expiresAt time.Time) (*ModerationEntry, error)
+
+	// Lift a mute
+	Unmute(ctx context.Context, roomID, userID, actorID string) (*ModerationEntry, error)
+
+	// Get the bans in force in a room, newest first
+	GetBans(ctx context.Context, roomID string) ([]*Ban, error)
+
+	// Get a page of a room's moderation log, newest first, older than the before cursor
+	GetModerationLog(ctx context.Context, roomID, before string, limit int) (*ModerationLogPage, error)
+}
+
+type PostgresModerationStore struct {
+	db *sql.DB
+}
+
+func NewPostgresModerationStore(db *sql.DB) *PostgresModerationStore {
+	return &PostgresModerationStore{db: db}
+}
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// checkNotBanned returns ErrBanned while a ban on the user is in force
+func checkNotBanned(ctx context.Context, q rowQueryer, roomID, userID string) error {
+	var banned bool
+	err := q.QueryRowContext(ctx, `
+        SELECT EXISTS(
+            SELECT 1 FROM room_bans
+            WHERE room_id = $1 AND user_id = $2
+              AND (expires_at IS NULL OR expires_at > NOW())
+        )
+    `, roomID, userID).Scan(&banned)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrBanned
+	}
+	return nil
+}
+
+// logModeration records an action in the same transaction that takes it
+func logModeration(ctx context.Context, tx *sql.Tx, entry *ModerationEntry) (*ModerationEntry, error) {
+	err := tx.QueryRowContext(ctx, `
+        INSERT INTO moderation_log (room_id, action, actor_id, target_id, reason, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
+    `, entry.RoomID, entry.Action, entry.ActorID, entry.TargetID, nullString(entry.Reason), entry.ExpiresAt).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return entry, tx.Commit()
+}
+
+// Kick deletes the user's membership
+func (s *PostgresModerationStore) Kick(ctx context.Context, roomID, userID, actorID, reason string) (*ModerationEntry, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        DELETE FROM room_memberships WHERE room_id = $1 AND user_id = $2
+    `, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrNotRoomMember
+	}
+
+	return logModeration(ctx, tx, &ModerationEntry{
+		RoomID:   roomID,
+		Action:   ModerationKick,
+		ActorID:  actorID,
+		TargetID: userID,
+		Reason:   reason,
+	})
+}
+
+// Ban records the ban, replacing any earlier one, and takes the user out of
+// the room along with any invitation or request to join that would let them
+// back in
+func (s *PostgresModerationStore) Ban(ctx context.Context, roomID, userID, actorID, reason string, expiresAt *time.Time) (*ModerationEntry, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO room_bans (room_id, user_id, banned_by, reason, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (room_id, user_id) DO UPDATE
+        SET banned_by = EXCLUDED.banned_by,
+            reason = EXCLUDED.reason,
+            expires_at = EXCLUDED.expires_at,
+            created_at = CURRENT_TIMESTAMP
+    `, roomID, userID, actorID, nullString(reason), expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM room_memberships WHERE room_id = $1 AND user_id = $2`,
+		`DELETE FROM room_invitations WHERE room_id = $1 AND user_id = $2`,
+		`DELETE FROM room_join_requests WHERE room_id = $1 AND user_id = $2`,
+	} {
+		if _, err = tx.ExecContext(ctx, query, roomID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return logModeration(ctx, tx, &ModerationEntry{
+		RoomID:    roomID,
+		Action:    ModerationBan,
+		ActorID:   actorID,
+		TargetID:  userID,
+		Reason:    reason,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// Unban deletes a ban, expired or not
+func (s *PostgresModerationStore) Unban(ctx context.Context, roomID, userID, actorID string) (*ModerationEntry, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        DELETE FROM room_bans WHERE room_id = $1 AND user_id = $2
+    `, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrBanNotFound
+	}
+
+	return logModeration(ctx, tx, &ModerationEntry{
+		RoomID:   roomID,
+		Action:   ModerationUnban,
+		ActorID:  actorID,
+		TargetID: userID,
+	})
+}
+
+// Mute records the mute, replacing any earlier one. The user does not have
+// to be a member, so a mute can be set ahead of them rejoining.
+func (s *PostgresModerationStore) Mute(ctx context.Context, roomID, userID, actorID, reason string, expiresAt time.Time) (*ModerationEntry, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO room_mutes (room_id, user_id, muted_by, reason, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (room_id, user_id) DO UPDATE
+        SET muted_by = EXCLUDED.muted_by,
+            reason = EXCLUDED.reason,
+            expires_at = EXCLUDED.expires_at,
+            created_at = CURRENT_TIMESTAMP
+    `, roomID, userID, actorID, nullString(reason), expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return logModeration(ctx, tx, &ModerationEntry{
+		RoomID:    roomID,
+		Action:    ModerationMute,
+		ActorID:   actorID,
+		TargetID:  userID,
+		Reason:    reason,
+		ExpiresAt: &expiresAt,
+	})
+}
+
+// Unmute deletes a mute that is still in force
+func (s *PostgresModerationStore) Unmute(ctx context.Context, roomID, userID, actorID string) (*ModerationEntry, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        DELETE FROM room_mutes
+        WHERE room_id = $1 AND user_id = $2 AND expires_at > NOW()
+    `, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrMuteNotFound
+	}
+
+	return logModeration(ctx, tx, &ModerationEntry{
+		RoomID:   roomID,
+		Action:   ModerationUnmute,
+		ActorID:  actorID,
+		TargetID: userID,
+	})
+}
+
+// GetBans lists the bans that have not expired
+func (s *PostgresModerationStore) GetBans(ctx context.Context, roomID string) ([]*Ban, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT room_id, user_id, banned_by, COALESCE(reason, ''), expires_at, created_at
+        FROM room_bans
+        WHERE room_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+        ORDER BY created_at DESC
+    `, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []*Ban{}
+	for rows.Next() {
+		ban := &Ban{}
+		var expiresAt sql.NullTime
+		err = rows.Scan(&ban.RoomID, &ban.UserID, &ban.BannedBy, &ban.Reason, &expiresAt, &ban.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		ban.ExpiresAt = nullTime(expiresAt)
+		bans = append(bans, ban)
+	}
+
+	return bans, rows.Err()
+}
+
+// GetModerationLog pages backwards through a room's moderation log
+func (s *PostgresModerationStore) GetModerationLog(ctx context.Context, roomID, before string, limit int) (*ModerationLogPage, error) {
+	if limit <= 0 {
+		limit = DefaultMessagePageSize
+	}
+	if limit > MaxMessagePageSize {
+		limit = MaxMessagePageSize
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, room_id, action, actor_id, target_id, COALESCE(reason, ''), expires_at, created_at
+        FROM moderation_log
+        WHERE room_id = $1
+          AND ($2 = '' OR (created_at, id) < (
+              SELECT created_at, id FROM moderation_log WHERE id::text = $2 AND room_id = $1
+          ))
+        ORDER BY created_at DESC, id DESC
+        LIMIT $3
+    `, roomID, before, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &ModerationLogPage{Entries: []*ModerationEntry{}}
+	for rows.Next() {
+		entry := &ModerationEntry{}
+		var expiresAt sql.NullTime
+		err = rows.Scan(&entry.ID, &entry.RoomID, &entry.Action, &entry.ActorID, &entry.TargetID, &entry.Reason, &expiresAt, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.ExpiresAt = nullTime(expiresAt)
+		page.Entries = append(page.Entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Entries) > limit {
+		page.Entries = page.Entries[:limit]
+		page.Before = page.Entries[limit-1].ID
+	}
+
+	return page, nil
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// MessagePartition is the partition of messages holding one calendar month,
+// in UTC
+type MessagePartition struct {
+	Name  string    `json:"name"`
+	Month time.Time `json:"month"`
+}
+
+type PartitionStore interface {
+	// Create the partition for the month containing t; reports false if it existed
+	CreateMessagePartition(ctx context.Context, t time.Time) (bool, error)
+
+	// Get the monthly partitions attached to messages, oldest first
+	GetMessagePartitions(ctx context.Context) ([]*MessagePartition, error)
+
+	// Detach a partition, leaving its messages in a table of their own
+	DetachMessagePartition(ctx context.Context, partition *MessagePartition) error
+}
+
+type PostgresPartitionStore struct {
+	db *sql.DB
+}
+
+func NewPostgresPartitionStore(db *sql.DB) *PostgresPartitionStore {
+	return &PostgresPartitionStore{db: db}
+}
+
+const partitionPrefix = "messages_"
+
+// partitionName is messages_YYYY_MM, the name the migrations use as well.
+// It only ever contains digits, so it is safe to put into DDL.
+func partitionName(month time.Time) string {
+	return partitionPrefix + month.Format("2006_01")
+}
+
+func startOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// The stored columns of messages, for copying rows between partitions. The
+// generated search_vector is left out; it is computed again on insert.
+const partitionColumns = `id, type, room, content, sender, time, seq, edited_at, deleted_at, deleted_by,
+        parent_id, quote_id, reply_count, last_reply_at, has_attachments, format,
+        content_html, expires_at, restored_at`
+
+// CreateMessagePartition adds a monthly partition. Rows of that month that
+// landed in the default partition in the meantime are moved into it.
+func (s *PostgresPartitionStore) CreateMessagePartition(ctx context.Context, t time.Time) (bool, error) {
+	month := startOfMonth(t)
+	name := partitionName(month)
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	// DDL takes no parameters; both bounds are formatted by us
+	from := month.Format(time.RFC3339)
+	to := month.AddDate(0, 1, 0).Format(time.RFC3339)
+
+	var misfiled bool
+	err = s.db.QueryRowContext(ctx, `
+        SELECT EXISTS(SELECT 1 FROM messages_default WHERE time >= $1 AND time < $2)
+    `, from, to).Scan(&misfiled)
+	if err != nil {
+		return false, err
+	}
+	if misfiled {
+		return true, s.createWithMisfiled(ctx, name, from, to)
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS `+name+` PARTITION OF messages
+        FOR VALUES FROM ('`+from+`') TO ('`+to+`')
+    `)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// createWithMisfiled creates a partition for a month the default partition
+// already holds rows of, which Postgres refuses to do directly. The default
+// partition is detached for the move, and its rows are put back with
+// TRUNCATE and INSERT rather than DELETE so the delete trigger doesn't take
+// the moved messages' replies, reactions and the rest with them.
+func (s *PostgresPartitionStore) createWithMisfiled(ctx context.Context, name, from, to string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	steps := []string{
+		`ALTER TABLE messages DETACH PARTITION messages_default`,
+		`CREATE TABLE ` + name + ` PARTITION OF messages
+        FOR VALUES FROM ('` + from + `') TO ('` + to + `')`,
+		`INSERT INTO messages (` + partitionColumns + `)
+        SELECT ` + partitionColumns + ` FROM messages_default
+        WHERE time >= '` + from + `' AND time < '` + to + `'`,
+		`CREATE TEMPORARY TABLE messages_default_kept ON COMMIT DROP AS
+        SELECT ` + partitionColumns + ` FROM messages_default
+        WHERE NOT (time >= '` + from + `' AND time < '` + to + `')`,
+		`TRUNCATE messages_default`,
+		`INSERT INTO messages_default (` + partitionColumns + `)
+        SELECT ` + partitionColumns + ` FROM messages_default_kept`,
+		`ALTER TABLE messages ATTACH PARTITION messages_default DEFAULT`,
+	}
+	for _, step := range steps {
+		if _, err = tx.ExecContext(ctx, step); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetMessagePartitions lists the attached monthly partitions. The default
+// partition is left out.
+func (s *PostgresPartitionStore) GetMessagePartitions(ctx context.Context) ([]*MessagePartition, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT c.relname
+        FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        WHERE i.inhparent = 'messages'::regclass
+        ORDER BY c.relname
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partitions := []*MessagePartition{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		month, err := time.Parse("2006_01", strings.TrimPrefix(name, partitionPrefix))
+		if err != nil {
+			continue
+		}
+		partitions = append(partitions, &MessagePartition{Name: name, Month: month})
+	}
+
+	return partitions, rows.Err()
+}
+
+// DetachMessagePartition takes a month out of messages. No delete trigger
+// fires for the detached rows, so whatever referred to them is removed the
+// way the trigger would have, in the same transaction.
+func (s *PostgresPartitionStore) DetachMessagePartition(ctx context.Context, partition *MessagePartition) error {
+	name := partitionName(partition.Month)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `ALTER TABLE messages DETACH PARTITION `+name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `SELECT messages_removed(ARRAY(SELECT id FROM `+name+`))`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"errors"
+)
+
+var ErrForbidden = errors.New("not allowed in this room")
+
+// Permission is something a member may or may not do in a room
+type Permission string
+
+const (
+	PermPost           Permission = "post"
+	PermEditOthers     Permission = "edit_others"
+	PermDeleteOthers   Permission = "delete_others"
+	PermPin            Permission = "pin"
+	PermInvite         Permission = "invite" // Invitations, invite links and join requests
+	PermKick           Permission = "kick"
+	PermMute           Permission = "mute"
+	PermBan            Permission = "ban"
+	PermChangeSettings Permission = "change_settings"
+	PermManageRoles    Permission = "manage_roles"
+	PermViewModeration Permission = "view_moderation" // The moderation log and ban list
+	PermDeleteRoom     Permission = "delete_room"
+	PermSkipSlowMode   Permission = "skip_slow_mode"
+	PermReview         Permission = "review" // The queues of held messages and reports
+)
+
+// rolePermissions is the permission matrix. Each role can do everything the
+// role below it can.
+var rolePermissions = map[string][]Permission{
+	RoleMember:    {PermPost},
+	RoleModerator: {PermPost, PermEditOthers, PermDeleteOthers, PermPin, PermInvite, PermKick, PermMute, PermSkipSlowMode, PermReview},
+	RoleAdmin:     {PermPost, PermEditOthers, PermDeleteOthers, PermPin, PermInvite, PermKick, PermMute, PermSkipSlowMode, PermReview, PermBan, PermChangeSettings, PermManageRoles, PermViewModeration},
+	RoleOwner:     {PermPost, PermEditOthers, PermDeleteOthers, PermPin, PermInvite, PermKick, PermMute, PermSkipSlowMode, PermReview, PermBan, PermChangeSettings, PermManageRoles, PermViewModeration, PermDeleteRoom},
+}
+
+// Writes reports whether a permission changes what is in a room. Archived
+// rooms are read-only, so nobody holds these in them.
+func (p Permission) Writes() bool {
+	switch p {
+	case PermPost, PermEditOthers, PermDeleteOthers, PermPin:
+		return true
+	}
+	return false
+}
+
+var roleRanks = map[string]int{
+	RoleMember:    1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+	RoleOwner:     4,
+}
+
+// RoleCan reports whether a role grants a permission. The empty role, held
+// by non-members, grants nothing.
+func RoleCan(role string, permission Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleOutranks reports whether role sits strictly above other
+func RoleOutranks(role, other string) bool {
+	return roleRanks[role] > roleRanks[other]
+}
+
+// ValidRole reports whether role is one of the membership roles
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Most messages a room can have pinned at once
+const MaxPinnedMessages = 50
+
+var ErrPinLimitReached = errors.New("room has reached the limit of pinned messages")
+
+// Pin is a message pinned to the top of its room
+type Pin struct {
+	Message  *Message  `json:"message"`
+	PinnedBy string    `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+type PinStore interface {
+	// Pin a message in its room; reports false if it was already pinned
+	PinMessage(ctx context.Context, messageID, userID string) (*Pin, bool, error)
+
+	// Unpin a message; reports false if it was not pinned
+	UnpinMessage(ctx context.Context, messageID string) (bool, error)
+
+	// Get the pinned messages of a room, most recently pinned first
+	GetPinnedMessages(ctx context.Context, roomID string) ([]*Pin, error)
+}
+
+type PostgresPinStore struct {
+	db *sql.DB
+}
+
+func NewPostgresPinStore(db *sql.DB) *PostgresPinStore {
+	return &PostgresPinStore{db: db}
+}
+
+// PinMessage pins a message. The room row is locked while the pins are
+// counted so two moderators can't pin past the cap together. Only pins that
+// are shown count: an expired message keeps its pin until it is purged.
+func (s *PostgresPinStore) PinMessage(ctx context.Context, messageID, userID string) (*Pin, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var roomID string
+	err = tx.QueryRowContext(ctx, `
+        SELECT r.id FROM messages m
+        JOIN rooms r ON r.id = m.room
+        WHERE m.id = $1 AND m.deleted_at IS NULL
+          AND (m.expires_at IS NULL OR m.expires_at > CURRENT_TIMESTAMP)
+        FOR UPDATE OF r
+    `, messageID).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		return nil, false, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var pinned int
+	err = tx.QueryRowContext(ctx, `
+        SELECT COUNT(*)
+        FROM pinned_messages p
+        JOIN messages m ON m.id = p.message_id
+        WHERE p.room_id = $1 AND m.deleted_at IS NULL AND `+notExpired+`
+    `, roomID).Scan(&pinned)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pin := &Pin{PinnedBy: userID}
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO pinned_messages (message_id, room_id, pinned_by)
+        SELECT $1, $2, $3
+        WHERE $4::int < $5::int
+        ON CONFLICT (message_id) DO NOTHING
+        RETURNING pinned_at
+    `, messageID, roomID, userID, pinned, MaxPinnedMessages).Scan(&pin.PinnedAt)
+	if err == sql.ErrNoRows {
+		// Either it is pinned already or the room is full
+		var exists bool
+		err = tx.QueryRowContext(ctx, `
+            SELECT EXISTS(SELECT 1 FROM pinned_messages WHERE message_id = $1)
+        `, messageID).Scan(&exists)
+		if err != nil {
+			return nil, false, err
+		}
+		if !exists {
+			return nil, false, ErrPinLimitReached
+		}
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	pin.Message, err = scanMessage(tx.QueryRowContext(ctx, `
+        SELECT `+messageColumns+` FROM messages WHERE id = $1
+    `, messageID))
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return pin, true, nil
+}
+
+// UnpinMessage removes a pin
+func (s *PostgresPinStore) UnpinMessage(ctx context.Context, messageID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+        DELETE FROM pinned_messages WHERE message_id = $1
+    `, messageID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// GetPinnedMessages returns a room's pins. Pins of expired messages are
+// kept until the messages are purged but not shown.
+func (s *PostgresPinStore) GetPinnedMessages(ctx context.Context, roomID string) ([]*Pin, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT p.pinned_by, p.pinned_at, m.*
+        FROM pinned_messages p
+        JOIN LATERAL (
+            SELECT ` + messageColumns + `
+            FROM messages
+            WHERE id = p.message_id AND deleted_at IS NULL AND ` + notExpired + `
+        ) m ON true
+        WHERE p.room_id = $1
+        ORDER BY p.pinned_at DESC
+    `
+	rows, err := tx.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := []*Pin{}
+	messages := []*Message{}
+	for rows.Next() {
+		pin := &Pin{}
+		pin.Message, err = scanMessage(prefixScanner{
+			row:  rows,
+			dest: []any{&pin.PinnedBy, &pin.PinnedAt},
+		})
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+		messages = append(messages, pin.Message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = attachDetails(ctx, tx, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return pins, nil
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// Reaction is the aggregate of one emoji on one message
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+type ReactionStore interface {
+	// Add a user's reaction; reports false if it was already there
+	AddReaction(ctx context.Context, messageID, userID, emoji string) (bool, error)
+
+	// Remove a user's reaction; reports false if there was none
+	RemoveReaction(ctx context.Context, messageID, userID, emoji string) (bool, error)
+}
+
+type PostgresReactionStore struct {
+	db *sql.DB
+}
+
+func NewPostgresReactionStore(db *sql.DB) *PostgresReactionStore {
+	return &PostgresReactionStore{db: db}
+}
+
+// AddReaction records a reaction. Reacting twice with the same emoji is a
+// no-op so clients can retry safely.
+func (s *PostgresReactionStore) AddReaction(ctx context.Context, messageID, userID, emoji string) (bool, error) {
+	query := `
+        INSERT INTO message_reactions (message_id, user_id, emoji)
+        SELECT $1, $2, $3
+        WHERE EXISTS (SELECT 1 FROM messages WHERE id = $1)
+        ON CONFLICT (message_id, user_id, emoji) DO NOTHING
+    `
+	result, err := s.db.ExecContext(ctx, query, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// RemoveReaction deletes a reaction
+func (s *PostgresReactionStore) RemoveReaction(ctx context.Context, messageID, userID, emoji string) (bool, error) {
+	query := `
+        DELETE FROM message_reactions
+        WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+    `
+	result, err := s.db.ExecContext(ctx, query, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// attachReactions loads the reaction aggregates of a batch of messages in a
+// single query. Emojis are ordered by when they were first used.
+func attachReactions(ctx context.Context, q queryer, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*Message, len(messages))
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		byID[message.ID] = message
+		ids = append(ids, message.ID)
+	}
+
+	query := `
+        SELECT message_id, emoji, COUNT(*), string_agg(user_id, ',' ORDER BY created_at)
+        FROM message_reactions
+        WHERE message_id = ANY($1)
+        GROUP BY message_id, emoji
+        ORDER BY MIN(created_at)
+    `
+	rows, err := q.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, users string
+		reaction := &Reaction{}
+		err = rows.Scan(&messageID, &reaction.Emoji, &reaction.Count, &users)
+		if err != nil {
+			return err
+		}
+		reaction.Users = strings.Split(users, ",")
+
+		if message, ok := byID[messageID]; ok {
+			message.Reactions = append(message.Reactions, reaction)
+		}
+	}
+
+	return rows.Err()
+}
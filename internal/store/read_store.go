@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrNotRoomMember = errors.New("user is not a member of this room")
+
+// ReadState is a user's read position in a room
+type ReadState struct {
+	RoomID            string     `json:"room_id"`
+	UserID            string     `json:"user_id"`
+	LastReadMessageID string     `json:"last_read_message_id,omitempty"`
+	LastReadSeq       int64      `json:"last_read_seq"`
+	LastReadAt        *time.Time `json:"last_read_at,omitempty"`
+	UnreadCount       int64      `json:"unread_count"`
+	MentionCount      int64      `json:"mention_count"`
+	Muted             bool       `json:"muted"`
+}
+
+type ReadStore interface {
+	// Move a user's read marker forward to a message; reports whether it moved
+	MarkRead(ctx context.Context, userID, roomID, messageID string) (*ReadState, bool, error)
+
+	// Get the read state of every room a user is in, keyed by room ID
+	GetUserReadStates(ctx context.Context, userID string) (map[string]*ReadState, error)
+}
+
+type PostgresReadStore struct {
+	db *sql.DB
+}
+
+func NewPostgresReadStore(db *sql.DB) *PostgresReadStore {
+	return &PostgresReadStore{db: db}
+}
+
+// MarkRead moves the read marker forward. Markers never move backwards, so a
+// stale mark_read from a second tab is a no-op rather than resurrecting
+// unread messages.
+func (s *PostgresReadStore) MarkRead(ctx context.Context, userID, roomID, messageID string) (*ReadState, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var seq int64
+	err = tx.QueryRowContext(ctx, `
+        SELECT seq FROM messages
+        WHERE id = $1 AND room = $2 AND parent_id IS NULL
+    `, messageID, roomID).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return nil, false, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Mentions are only tracked as a counter, so they clear once the reader
+	// has caught up with the whole room
+	query := `
+        UPDATE room_memberships rm
+        SET last_read_seq = $3,
+            last_read_message_id = $4,
+            last_read_at = CURRENT_TIMESTAMP,
+            unread_mentions = CASE WHEN $3 >= r.last_seq THEN 0 ELSE rm.unread_mentions END
+        FROM rooms r
+        WHERE r.id = rm.room_id
+          AND rm.user_id = $1 AND rm.room_id = $2
+          AND rm.last_read_seq < $3
+    `
+	result, err := tx.ExecContext(ctx, query, userID, roomID, seq, messageID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	state, err := scanReadState(tx.QueryRowContext(ctx, readStateQuery+`
+        WHERE rm.user_id = $1 AND rm.room_id = $2
+    `, userID, roomID))
+	if err == sql.ErrNoRows {
+		return nil, false, ErrNotRoomMember
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return state, rowsAffected > 0, nil
+}
+
+// GetUserReadStates returns unread and mention counts for all of a user's
+// rooms. Both are plain column arithmetic, so the cost does not grow with
+// the size of the rooms.
+func (s *PostgresReadStore) GetUserReadStates(ctx context.Context, userID string) (map[string]*ReadState, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, readStateQuery+`
+        WHERE rm.user_id = $1
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[string]*ReadState)
+	for rows.Next() {
+		state, err := scanReadState(rows)
+		if err != nil {
+			return nil, err
+		}
+		states[state.RoomID] = state
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+const readStateQuery = `
+        SELECT rm.room_id, rm.user_id, rm.last_read_message_id, rm.last_read_seq,
+               rm.last_read_at, r.last_seq - rm.last_read_seq, rm.unread_mentions, rm.muted
+        FROM room_memberships rm
+        JOIN rooms r ON r.id = rm.room_id
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanReadState(row rowScanner) (*ReadState, error) {
+	state := &ReadState{}
+	var lastReadMessageID sql.NullString
+	var lastReadAt sql.NullTime
+	err := row.Scan(
+		&state.RoomID,
+		&state.UserID,
+		&lastReadMessageID,
+		&state.LastReadSeq,
+		&lastReadAt,
+		&state.UnreadCount,
+		&state.MentionCount,
+		&state.Muted,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	state.LastReadMessageID = lastReadMessageID.String
+	if lastReadAt.Valid {
+		state.LastReadAt = &lastReadAt.Time
+	}
+
+	return state, nil
+}
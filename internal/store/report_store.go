@@ -0,0 +1,412 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrReportExists     = errors.New("you already reported this")
+	ErrReportNotFound   = errors.New("report not found")
+	ErrReportClaimed    = errors.New("report is claimed by another moderator")
+	ErrReportNotClaimed = errors.New("report is not claimed by you")
+	ErrReportResolved   = errors.New("report was already resolved")
+)
+
+// Where a report is in the queue
+const (
+	ReportOpen     = "open"
+	ReportClaimed  = "claimed"
+	ReportResolved = "resolved"
+)
+
+// How a report was resolved
+const (
+	ResolutionDismiss       = "dismiss"
+	ResolutionDeleteMessage = "delete_message"
+	ResolutionMute          = "mute"
+	ResolutionBan           = "ban"
+)
+
+// Entries in a report's history
+const (
+	ReportEventCreated  = "created"
+	ReportEventClaimed  = "claimed"
+	ReportEventReleased = "released"
+	ReportEventResolved = "resolved"
+)
+
+// Report is a member's complaint about a message or another member
+type Report struct {
+	ID         string `json:"id"`
+	RoomID     string `json:"room_id"`
+	ReporterID string `json:"reporter_id"`
+	TargetID   string `json:"target_id"` // The reported member, or the sender of the reported message
+
+	// Set for reports of a message; the content is as it was when reported
+	MessageID      string `json:"message_id,omitempty"`
+	MessageContent string `json:"message_content,omitempty"`
+
+	Reason     string     `json:"reason"`
+	Status     string     `json:"status"`
+	ClaimedBy  string     `json:"claimed_by,omitempty"`
+	ClaimedAt  *time.Time `json:"claimed_at,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+	ResolvedBy string     `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// Only filled in for a single report
+	History []*ReportEvent `json:"history,omitempty"`
+}
+
+// ReportEvent is one entry in a report's audit trail
+type ReportEvent struct {
+	ID         string    `json:"id"`
+	ReportID   string    `json:"report_id"`
+	Action     string    `json:"action"`
+	ActorID    string    `json:"actor_id"`
+	Resolution string    `json:"resolution,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ValidResolution reports whether resolution is one of the ways to resolve a report
+func ValidResolution(resolution string) bool {
+	switch resolution {
+	case ResolutionDismiss, ResolutionDeleteMessage, ResolutionMute, ResolutionBan:
+		return true
+	}
+	return false
+}
+
+type ReportStore interface {
+	// File a report
+	CreateReport(ctx context.Context, report *Report) (*Report, error)
+
+	// Get a room's reports with a status, oldest first; no status means the unresolved ones
+	GetReports(ctx context.Context, roomID, status string) ([]*Report, error)
+
+	// Get a report along with its history
+	GetReport(ctx context.Context, roomID, reportID string) (*Report, error)
+
+	// Take a report so other moderators leave it alone; takeOver allows taking it from someone else
+	ClaimReport(ctx context.Context, roomID, reportID, moderatorID string, takeOver bool) (*Report, error)
+
+	// Give back a claimed report
+	ReleaseReport(ctx context.Context, roomID, reportID, moderatorID string) (*Report, error)
+
+	// Close a report, recording what was done about it
+	ResolveReport(ctx context.Context, roomID, reportID, moderatorID, resolution, note string) (*Report, error)
+}
+
+type PostgresReportStore struct {
+	db *sql.DB
+}
+
+func NewPostgresReportStore(db *sql.DB) *PostgresReportStore {
+	return &PostgresReportStore{db: db}
+}
+
+const reportColumns = `id, room_id, reporter_id, target_id, message_id, message_content, reason, status,
+        claimed_by, claimed_at, resolution, resolved_by, resolved_at, created_at`
+
+func scanReport(row rowScanner) (*Report, error) {
+	report := &Report{}
+	var messageID, messageContent, claimedBy, resolution, resolvedBy sql.NullString
+	var claimedAt, resolvedAt sql.NullTime
+	err := row.Scan(
+		&report.ID,
+		&report.RoomID,
+		&report.ReporterID,
+		&report.TargetID,
+		&messageID,
+		&messageContent,
+		&report.Reason,
+		&report.Status,
+		&claimedBy,
+		&claimedAt,
+		&resolution,
+		&resolvedBy,
+		&resolvedAt,
+		&report.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	report.MessageID = messageID.String
+	report.MessageContent = messageContent.String
+	report.ClaimedBy = claimedBy.String
+	report.ClaimedAt = nullTime(claimedAt)
+	report.Resolution = resolution.String
+	report.ResolvedBy = resolvedBy.String
+	report.ResolvedAt = nullTime(resolvedAt)
+	return report, nil
+}
+
+// logReportEvent adds to a report's history in the transaction that changed it
+func logReportEvent(ctx context.Context, tx *sql.Tx, event *ReportEvent) error {
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO report_events (report_id, action, actor_id, resolution, note)
+        VALUES ($1, $2, $3, $4, $5)
+    `, event.ReportID, event.Action, event.ActorID, nullString(event.Resolution), nullString(event.Note))
+	return err
+}
+
+// lockReport loads a report for a change of status
+func lockReport(ctx context.Context, tx *sql.Tx, roomID, reportID string) (*Report, error) {
+	report, err := scanReport(tx.QueryRowContext(ctx, `
+        SELECT `+reportColumns+`
+        FROM reports
+        WHERE id::text = $1 AND room_id = $2
+        FOR UPDATE
+    `, reportID, roomID))
+	if err == sql.ErrNoRows {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if report.Status == ReportResolved {
+		return nil, ErrReportResolved
+	}
+	return report, nil
+}
+
+// CreateReport stores a new, open report and starts its history
+func (s *PostgresReportStore) CreateReport(ctx context.Context, report *Report) (*Report, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	created, err := scanReport(tx.QueryRowContext(ctx, `
+        INSERT INTO reports (room_id, reporter_id, target_id, message_id, message_content, reason)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING `+reportColumns,
+		report.RoomID, report.ReporterID, report.TargetID, nullString(report.MessageID), nullString(report.MessageContent), report.Reason))
+	if err != nil && strings.Contains(err.Error(), "idx_reports_unresolved") {
+		return nil, ErrReportExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = logReportEvent(ctx, tx, &ReportEvent{
+		ReportID: created.ID,
+		Action:   ReportEventCreated,
+		ActorID:  created.ReporterID,
+		Note:     created.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// GetReports returns the reports of a room in the order they were filed
+func (s *PostgresReportStore) GetReports(ctx context.Context, roomID, status string) ([]*Report, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+reportColumns+`
+        FROM reports
+        WHERE room_id = $1
+          AND (status = $2 OR ($2 = '' AND status <> 'resolved'))
+        ORDER BY created_at
+    `, roomID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
+
+// GetReport returns a report of a room with its full history, oldest first
+func (s *PostgresReportStore) GetReport(ctx context.Context, roomID, reportID string) (*Report, error) {
+	report, err := scanReport(s.db.QueryRowContext(ctx, `
+        SELECT `+reportColumns+`
+        FROM reports
+        WHERE id::text = $1 AND room_id = $2
+    `, reportID, roomID))
+	if err == sql.ErrNoRows {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, report_id, action, actor_id, resolution, note, created_at
+        FROM report_events
+        WHERE report_id = $1
+        ORDER BY created_at, id
+    `, report.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report.History = []*ReportEvent{}
+	for rows.Next() {
+		event := &ReportEvent{}
+		var resolution, note sql.NullString
+		err = rows.Scan(&event.ID, &event.ReportID, &event.Action, &event.ActorID, &resolution, &note, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		event.Resolution = resolution.String
+		event.Note = note.String
+		report.History = append(report.History, event)
+	}
+
+	return report, rows.Err()
+}
+
+// ClaimReport marks a report as being handled by a moderator. Claiming a
+// report you already hold is a no-op.
+func (s *PostgresReportStore) ClaimReport(ctx context.Context, roomID, reportID, moderatorID string, takeOver bool) (*Report, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report, err := lockReport(ctx, tx, roomID, reportID)
+	if err != nil {
+		return nil, err
+	}
+	if report.ClaimedBy == moderatorID {
+		return report, nil
+	}
+	if report.Status == ReportClaimed && !takeOver {
+		return nil, ErrReportClaimed
+	}
+
+	report, err = scanReport(tx.QueryRowContext(ctx, `
+        UPDATE reports
+        SET status = 'claimed', claimed_by = $2, claimed_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING `+reportColumns,
+		report.ID, moderatorID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = logReportEvent(ctx, tx, &ReportEvent{ReportID: report.ID, Action: ReportEventClaimed, ActorID: moderatorID})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// ReleaseReport puts a report its moderator held back in the open queue
+func (s *PostgresReportStore) ReleaseReport(ctx context.Context, roomID, reportID, moderatorID string) (*Report, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report, err := lockReport(ctx, tx, roomID, reportID)
+	if err != nil {
+		return nil, err
+	}
+	if report.ClaimedBy != moderatorID {
+		return nil, ErrReportNotClaimed
+	}
+
+	report, err = scanReport(tx.QueryRowContext(ctx, `
+        UPDATE reports
+        SET status = 'open', claimed_by = NULL, claimed_at = NULL
+        WHERE id = $1
+        RETURNING `+reportColumns,
+		report.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = logReportEvent(ctx, tx, &ReportEvent{ReportID: report.ID, Action: ReportEventReleased, ActorID: moderatorID})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// ResolveReport closes a report that is open or claimed by the moderator
+// resolving it. A resolved report stays, with its history, as the record of
+// who did what.
+func (s *PostgresReportStore) ResolveReport(ctx context.Context, roomID, reportID, moderatorID, resolution, note string) (*Report, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report, err := lockReport(ctx, tx, roomID, reportID)
+	if err != nil {
+		return nil, err
+	}
+	if report.Status == ReportClaimed && report.ClaimedBy != moderatorID {
+		return nil, ErrReportClaimed
+	}
+
+	report, err = scanReport(tx.QueryRowContext(ctx, `
+        UPDATE reports
+        SET status = 'resolved', resolution = $2, resolved_by = $3, resolved_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+        RETURNING `+reportColumns,
+		report.ID, resolution, moderatorID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = logReportEvent(ctx, tx, &ReportEvent{
+		ReportID:   report.ID,
+		Action:     ReportEventResolved,
+		ActorID:    moderatorID,
+		Resolution: resolution,
+		Note:       note,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,590 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrArchiveNotFound = errors.New("archive not found")
+
+// What happens to messages older than a room's retention period
+const (
+	RetentionDelete  = "delete"
+	RetentionArchive = "archive"
+)
+
+// RetentionPolicy keeps a room's messages for Days, then deletes or archives
+// them depending on Action
+type RetentionPolicy struct {
+	Days   int    `json:"days"`
+	Action string `json:"action"`
+}
+
+// Cutoff is the time before which messages fall outside the policy
+func (p *RetentionPolicy) Cutoff(now time.Time) time.Time {
+	return now.AddDate(0, 0, -p.Days)
+}
+
+// ArchivedMessage is a message as it is written to an archive: every stored
+// column, deleted content included, with the rows that go away with it
+type ArchivedMessage struct {
+	ID             string     `json:"id"`
+	Type           string     `json:"type"`
+	Room           string     `json:"room"`
+	Content        string     `json:"content"`
+	Format         string     `json:"format"`
+	HTML           string     `json:"html,omitempty"`
+	Sender         string     `json:"sender"`
+	Time           time.Time  `json:"time"`
+	Seq            int64      `json:"seq"`
+	EditedAt       *time.Time `json:"edited_at,omitempty"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy      string     `json:"deleted_by,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	ParentID       string     `json:"parent_id,omitempty"`
+	QuoteID        string     `json:"quote_id,omitempty"`
+	ReplyCount     int        `json:"reply_count,omitempty"`
+	LastReplyAt    *time.Time `json:"last_reply_at,omitempty"`
+	HasAttachments bool       `json:"has_attachments,omitempty"`
+
+	Edits       []*ArchivedEdit       `json:"edits,omitempty"`
+	Reactions   []*ArchivedReaction   `json:"reactions,omitempty"`
+	Attachments []*ArchivedAttachment `json:"attachments,omitempty"`
+}
+
+type ArchivedEdit struct {
+	ID       string    `json:"id"`
+	Content  string    `json:"content"`
+	EditedBy string    `json:"edited_by"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+type ArchivedReaction struct {
+	UserID    string    `json:"user_id"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ArchivedAttachment keeps the blob keys, unlike Attachment, so a restored
+// message points at its files again
+type ArchivedAttachment struct {
+	ID           string    `json:"id"`
+	RoomID       string    `json:"room_id"`
+	UploaderID   string    `json:"uploader_id"`
+	Filename     string    `json:"filename"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	BlobKey      string    `json:"blob_key"`
+	ThumbnailKey string    `json:"thumbnail_key,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// MessageArchive is one archive file of a room's old messages
+type MessageArchive struct {
+	ID           string     `json:"id"`
+	RoomID       string     `json:"room_id"`
+	BlobKey      string     `json:"blob_key"`
+	MessageCount int        `json:"message_count"`
+	Oldest       time.Time  `json:"oldest"`
+	Newest       time.Time  `json:"newest"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RestoredAt   *time.Time `json:"restored_at,omitempty"`
+}
+
+// RetentionReport says what a policy would remove from a room right now
+type RetentionReport struct {
+	RoomID   string           `json:"room_id"`
+	Policy   *RetentionPolicy `json:"policy"`
+	Cutoff   time.Time        `json:"cutoff"`
+	Messages int              `json:"messages"`
+	Oldest   *time.Time       `json:"oldest,omitempty"`
+}
+
+type RetentionStore interface {
+	// Count the messages of a room that are past the cutoff
+	PreviewRetention(ctx context.Context, roomID string, cutoff time.Time) (*RetentionReport, error)
+
+	// Remove up to limit threads of a room past the cutoff, handing them to
+	// archive first; the archive it returns, if any, is recorded
+	ClaimOldMessages(ctx context.Context, roomID string, cutoff time.Time, limit int, archive func([]*ArchivedMessage) (*MessageArchive, error)) ([]*ArchivedMessage, error)
+
+	// Get the archives of a room, or of every room when roomID is empty
+	GetArchives(ctx context.Context, roomID string) ([]*MessageArchive, error)
+
+	// Get an archive by ID
+	GetArchive(ctx context.Context, archiveID string) (*MessageArchive, error)
+
+	// Put an archive's messages back, skipping those already present
+	RestoreArchive(ctx context.Context, archiveID string, messages []*ArchivedMessage) (int, error)
+}
+
+type PostgresRetentionStore struct {
+	db *sql.DB
+}
+
+func NewPostgresRetentionStore(db *sql.DB) *PostgresRetentionStore {
+	return &PostgresRetentionStore{db: db}
+}
+
+// A thread only falls out of retention as a whole: its root and last reply
+// must both be past the cutoff ($2). Restored messages count from the time
+// they were restored.
+const oldThreads = `
+        room = $1 AND parent_id IS NULL AND time < $2
+          AND (last_reply_at IS NULL OR last_reply_at < $2)
+          AND (restored_at IS NULL OR restored_at < $2)
+`
+
+// PreviewRetention counts what ClaimOldMessages would remove without
+// touching anything, replies included
+func (s *PostgresRetentionStore) PreviewRetention(ctx context.Context, roomID string, cutoff time.Time) (*RetentionReport, error) {
+	report := &RetentionReport{RoomID: roomID, Cutoff: cutoff}
+
+	var oldest sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+        WITH roots AS (
+            SELECT id, time FROM messages WHERE `+oldThreads+`
+        )
+        SELECT
+            (SELECT COUNT(*) FROM roots) +
+            (SELECT COUNT(*) FROM messages WHERE parent_id IN (SELECT id FROM roots)),
+            (SELECT MIN(time) FROM roots)
+    `, roomID, cutoff).Scan(&report.Messages, &oldest)
+	if err != nil {
+		return nil, err
+	}
+
+	if oldest.Valid {
+		report.Oldest = &oldest.Time
+	}
+	return report, nil
+}
+
+const archivedMessageColumns = `id, type, room, content, format, content_html, sender, time, seq,
+        edited_at, deleted_at, deleted_by, expires_at, parent_id, quote_id,
+        reply_count, last_reply_at, has_attachments`
+
+func scanArchivedMessage(row rowScanner) (*ArchivedMessage, error) {
+	message := &ArchivedMessage{}
+	var content, contentHTML, deletedBy, parentID, quoteID sql.NullString
+	var editedAt, deletedAt, expiresAt, lastReplyAt sql.NullTime
+	err := row.Scan(
+		&message.ID,
+		&message.Type,
+		&message.Room,
+		&content,
+		&message.Format,
+		&contentHTML,
+		&message.Sender,
+		&message.Time,
+		&message.Seq,
+		&editedAt,
+		&deletedAt,
+		&deletedBy,
+		&expiresAt,
+		&parentID,
+		&quoteID,
+		&message.ReplyCount,
+		&lastReplyAt,
+		&message.HasAttachments,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	message.Content = content.String
+	message.HTML = contentHTML.String
+	message.DeletedBy = deletedBy.String
+	message.ParentID = parentID.String
+	message.QuoteID = quoteID.String
+	message.EditedAt = nullTime(editedAt)
+	message.DeletedAt = nullTime(deletedAt)
+	message.ExpiresAt = nullTime(expiresAt)
+	message.LastReplyAt = nullTime(lastReplyAt)
+
+	return message, nil
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func queryArchivedMessages(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]*ArchivedMessage, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*ArchivedMessage
+	for rows.Next() {
+		message, err := scanArchivedMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+// ClaimOldMessages locks a batch of old threads with SKIP LOCKED, loads
+// everything that would be lost with them and deletes them once archive has
+// succeeded. Roots come before their replies in the result, so restoring in
+// order never inserts a reply without its thread.
+//
+// Messages quoting an archived message lose the quote, and mentions, pins and
+// bookmarks of archived messages are not kept.
+func (s *PostgresRetentionStore) ClaimOldMessages(ctx context.Context, roomID string, cutoff time.Time, limit int, archive func([]*ArchivedMessage) (*MessageArchive, error)) ([]*ArchivedMessage, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	roots, err := queryArchivedMessages(ctx, tx, `
+        SELECT `+archivedMessageColumns+`
+        FROM messages
+        WHERE `+oldThreads+`
+        ORDER BY time
+        LIMIT $3
+        FOR UPDATE SKIP LOCKED
+    `, roomID, cutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, nil
+	}
+
+	rootIDs := make([]string, 0, len(roots))
+	for _, root := range roots {
+		rootIDs = append(rootIDs, root.ID)
+	}
+
+	replies, err := queryArchivedMessages(ctx, tx, `
+        SELECT `+archivedMessageColumns+`
+        FROM messages
+        WHERE parent_id = ANY($1)
+        ORDER BY time
+        FOR UPDATE
+    `, rootIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := append(roots, replies...)
+	if err = loadArchivedDetails(ctx, tx, messages); err != nil {
+		return nil, err
+	}
+
+	written, err := archive(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	if written != nil {
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO message_archives (id, room_id, blob_key, message_count, oldest, newest)
+            VALUES ($1, $2, $3, $4, $5, $6)
+        `, written.ID, written.RoomID, written.BlobKey, written.MessageCount, written.Oldest, written.Newest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Replies go with their root through the cascade
+	_, err = tx.ExecContext(ctx, `DELETE FROM messages WHERE id = ANY($1)`, rootIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// loadArchivedDetails fills in the edits, reactions and attachments of a
+// batch of messages
+func loadArchivedDetails(ctx context.Context, tx *sql.Tx, messages []*ArchivedMessage) error {
+	byID := make(map[string]*ArchivedMessage, len(messages))
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		byID[message.ID] = message
+		ids = append(ids, message.ID)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT message_id, id, COALESCE(content, ''), edited_by, edited_at
+        FROM message_edits
+        WHERE message_id = ANY($1)
+        ORDER BY edited_at
+    `, ids)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var messageID string
+		edit := &ArchivedEdit{}
+		if err = rows.Scan(&messageID, &edit.ID, &edit.Content, &edit.EditedBy, &edit.EditedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		byID[messageID].Edits = append(byID[messageID].Edits, edit)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+        SELECT message_id, user_id, emoji, created_at
+        FROM message_reactions
+        WHERE message_id = ANY($1)
+        ORDER BY created_at
+    `, ids)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var messageID string
+		reaction := &ArchivedReaction{}
+		if err = rows.Scan(&messageID, &reaction.UserID, &reaction.Emoji, &reaction.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		byID[messageID].Reactions = append(byID[messageID].Reactions, reaction)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+        SELECT `+attachmentColumns+`
+        FROM attachments
+        WHERE message_id = ANY($1)
+        ORDER BY created_at
+    `, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return err
+		}
+		byID[attachment.MessageID].Attachments = append(byID[attachment.MessageID].Attachments, &ArchivedAttachment{
+			ID:           attachment.ID,
+			RoomID:       attachment.RoomID,
+			UploaderID:   attachment.UploaderID,
+			Filename:     attachment.Filename,
+			ContentType:  attachment.ContentType,
+			Size:         attachment.Size,
+			Width:        attachment.Width,
+			Height:       attachment.Height,
+			BlobKey:      attachment.BlobKey,
+			ThumbnailKey: attachment.ThumbnailKey,
+			CreatedAt:    attachment.CreatedAt,
+		})
+	}
+
+	return rows.Err()
+}
+
+const archiveColumns = `id, room_id, blob_key, message_count, oldest, newest, created_at, restored_at`
+
+func scanArchive(row rowScanner) (*MessageArchive, error) {
+	archive := &MessageArchive{}
+	var restoredAt sql.NullTime
+	err := row.Scan(
+		&archive.ID,
+		&archive.RoomID,
+		&archive.BlobKey,
+		&archive.MessageCount,
+		&archive.Oldest,
+		&archive.Newest,
+		&archive.CreatedAt,
+		&restoredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	archive.RestoredAt = nullTime(restoredAt)
+	return archive, nil
+}
+
+// GetArchives lists archives, oldest messages first
+func (s *PostgresRetentionStore) GetArchives(ctx context.Context, roomID string) ([]*MessageArchive, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+archiveColumns+`
+        FROM message_archives
+        WHERE $1 = '' OR room_id::text = $1
+        ORDER BY room_id, oldest
+    `, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	archives := []*MessageArchive{}
+	for rows.Next() {
+		archive, err := scanArchive(rows)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, archive)
+	}
+
+	return archives, rows.Err()
+}
+
+// GetArchive returns a single archive
+func (s *PostgresRetentionStore) GetArchive(ctx context.Context, archiveID string) (*MessageArchive, error) {
+	archive, err := scanArchive(s.db.QueryRowContext(ctx, `
+        SELECT `+archiveColumns+`
+        FROM message_archives
+        WHERE id::text = $1
+    `, archiveID))
+	if err == sql.ErrNoRows {
+		return nil, ErrArchiveNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// RestoreArchive inserts archived messages with their edits, reactions and
+// attachments. Messages that are back already are skipped, so a restore can
+// be repeated. A quoted message that is gone leaves the quote empty.
+func (s *PostgresRetentionStore) RestoreArchive(ctx context.Context, archiveID string, messages []*ArchivedMessage) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	restored := 0
+	for _, message := range messages {
+		result, err := tx.ExecContext(ctx, `
+            INSERT INTO messages (`+archivedMessageColumns+`, restored_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14,
+                    (SELECT id FROM messages WHERE id = $15), $16, $17, $18, CURRENT_TIMESTAMP)
+            ON CONFLICT (id, time) DO NOTHING
+        `,
+			message.ID,
+			message.Type,
+			message.Room,
+			message.Content,
+			message.Format,
+			nullString(message.HTML),
+			message.Sender,
+			message.Time,
+			message.Seq,
+			message.EditedAt,
+			message.DeletedAt,
+			nullString(message.DeletedBy),
+			message.ExpiresAt,
+			nullString(message.ParentID),
+			nullString(message.QuoteID),
+			message.ReplyCount,
+			message.LastReplyAt,
+			message.HasAttachments,
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if rowsAffected == 0 {
+			continue
+		}
+		restored++
+
+		if err = restoreDetails(ctx, tx, message); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE message_archives SET restored_at = CURRENT_TIMESTAMP WHERE id::text = $1
+    `, archiveID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return restored, nil
+}
+
+func restoreDetails(ctx context.Context, tx *sql.Tx, message *ArchivedMessage) error {
+	for _, edit := range message.Edits {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO message_edits (id, message_id, content, edited_by, edited_at)
+            VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (id) DO NOTHING
+        `, edit.ID, message.ID, edit.Content, edit.EditedBy, edit.EditedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, reaction := range message.Reactions {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO message_reactions (message_id, user_id, emoji, created_at)
+            VALUES ($1, $2, $3, $4)
+            ON CONFLICT DO NOTHING
+        `, message.ID, reaction.UserID, reaction.Emoji, reaction.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, attachment := range message.Attachments {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO attachments (id, room_id, uploader_id, message_id, filename, content_type,
+                                     size, width, height, blob_key, thumbnail_key, created_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+            ON CONFLICT (id) DO NOTHING
+        `,
+			attachment.ID,
+			attachment.RoomID,
+			attachment.UploaderID,
+			message.ID,
+			attachment.Filename,
+			attachment.ContentType,
+			attachment.Size,
+			sql.NullInt64{Int64: int64(attachment.Width), Valid: attachment.Width > 0},
+			sql.NullInt64{Int64: int64(attachment.Height), Valid: attachment.Height > 0},
+			attachment.BlobKey,
+			nullString(attachment.ThumbnailKey),
+			attachment.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
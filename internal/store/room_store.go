@@ -3,31 +3,171 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 )
 
+var (
+	ErrRoomNotFound        = errors.New("room not found")
+	ErrInvitationRequired  = errors.New("room is invite only")
+	ErrApprovalRequired    = errors.New("joining this room needs a moderator's approval")
+	ErrOwnerCannotLeave    = errors.New("the owner has to transfer the room before leaving it")
+	ErrTooFewParticipants  = errors.New("a conversation needs someone besides its creator")
+	ErrTooManyParticipants = fmt.Errorf("a group conversation can have at most %d people", MaxGroupParticipants)
+	ErrRoomNameTaken       = errors.New("a room with this name already exists")
+	ErrRoomFull            = errors.New("room is full")
+	ErrRoomArchived        = errors.New("room is archived")
+)
+
+// Who can join a room
+const (
+	VisibilityPublic  = "public"  // Anyone
+	VisibilityPrivate = "private" // Invited users, or after a moderator approves a request
+	VisibilityInvite  = "invite"  // Invited users only
+)
+
+// Kinds of room
+const (
+	KindChannel = "channel" // A named room people join
+	KindDM      = "dm"      // A conversation between two people
+	KindGroup   = "group"   // A conversation among a few people
+)
+
+// Most people a group conversation can have, its creator included
+const MaxGroupParticipants = 9
+
+// Membership roles, from least to most trusted. What each may do is in
+// rolePermissions.
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+	RoleOwner     = "owner"
+)
+
+// RoomMember is a user's membership of a room
+type RoomMember struct {
+	UserID   string    `json:"user_id"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
 // Room represents a chat room
 type Room struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Kind       string    `json:"kind"`
+	Visibility string    `json:"visibility"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// For direct conversations, everyone in it besides the user asking
+	Participants []string `json:"participants,omitempty"`
+
+	// Lifetime given to new messages that don't set their own, in seconds
+	MessageTTL int `json:"message_ttl,omitempty"`
+
+	// How long history is kept; nil leaves it to the server default
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+
+	Topic       string `json:"topic,omitempty"`
+	Description string `json:"description,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	Archived    bool   `json:"archived"`
+
+	// Seconds a member waits between messages; zero is off
+	SlowMode int `json:"slow_mode,omitempty"`
+
+	// Most members the room takes; zero is unlimited
+	MemberLimit int `json:"member_limit,omitempty"`
+
+	MemberCount   int        `json:"member_count"`
+	LastMessageAt *time.Time `json:"last_message_at,omitempty"`
+}
+
+// RoomUpdate holds the room settings to change; nil fields are left alone
+type RoomUpdate struct {
+	Name        *string `json:"name,omitempty"`
+	Topic       *string `json:"topic,omitempty"`
+	Description *string `json:"description,omitempty"`
+	AvatarURL   *string `json:"avatar_url,omitempty"`
+	Archived    *bool   `json:"archived,omitempty"`
+	SlowMode    *int    `json:"slow_mode,omitempty"`
+	MemberLimit *int    `json:"member_limit,omitempty"`
+}
+
+const roomColumns = `r.id, r.name, r.kind, r.visibility, r.created_at, r.message_ttl, r.retention_days, r.retention_action,
+        r.topic, r.description, r.avatar_url, r.archived, r.slow_mode, r.member_limit, r.member_count, r.last_message_at`
+
+func scanRoom(row rowScanner) (*Room, error) {
+	room := &Room{}
+	var messageTTL, retentionDays sql.NullInt64
+	var retentionAction sql.NullString
+	var lastMessageAt sql.NullTime
+	err := row.Scan(
+		&room.ID,
+		&room.Name,
+		&room.Kind,
+		&room.Visibility,
+		&room.CreatedAt,
+		&messageTTL,
+		&retentionDays,
+		&retentionAction,
+		&room.Topic,
+		&room.Description,
+		&room.AvatarURL,
+		&room.Archived,
+		&room.SlowMode,
+		&room.MemberLimit,
+		&room.MemberCount,
+		&lastMessageAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	room.LastMessageAt = nullTime(lastMessageAt)
+	room.MessageTTL = int(messageTTL.Int64)
+	if retentionDays.Valid {
+		room.Retention = &RetentionPolicy{
+			Days:   int(retentionDays.Int64),
+			Action: retentionAction.String,
+		}
+	}
+	return room, nil
 }
 
 type RoomStore interface {
-	// Get all available rooms
+	// Get all channels; direct conversations are left out
 	GetRooms(ctx context.Context) ([]*Room, error)
 
+	// Get every room, direct conversations included
+	GetAllRooms(ctx context.Context) ([]*Room, error)
+
+	// Get a page of the public room directory
+	GetDirectory(ctx context.Context, query DirectoryQuery) (*DirectoryPage, error)
+
 	// Get rooms that a specific user is in
 	GetUserRooms(ctx context.Context, userID string) ([]*Room, error)
 
 	// Get users in a specific room
 	GetRoomUsers(ctx context.Context, roomID string) ([]string, error)
 
-	// Create a new room
-	CreateRoom(ctx context.Context, name string) (*Room, error)
+	// Get the members of a room with their roles, highest role first
+	GetRoomMembers(ctx context.Context, roomID string) ([]*RoomMember, error)
+
+	// Get the members of a room whose role grants a permission
+	GetMembersWith(ctx context.Context, roomID string, permission Permission) ([]string, error)
+
+	// Create a new room owned by ownerID
+	CreateRoom(ctx context.Context, name, ownerID string) (*Room, error)
 
-	// Add a user to a room
+	// Get the direct conversation among a set of users, creating it if needed
+	OpenDirectRoom(ctx context.Context, userID string, participants []string, name string) (room *Room, created bool, err error)
+
+	// Add a user to a room, if its visibility lets them in
 	JoinRoom(ctx context.Context, userID, roomID string) error
 
 	// Remove a user from a room
@@ -35,6 +175,53 @@ type RoomStore interface {
 
 	// Check if a user is in a room
 	IsUserInRoom(ctx context.Context, userID, roomID string) (bool, error)
+
+	// Get a user's role in a room, or "" if they are not a member
+	GetMemberRole(ctx context.Context, userID, roomID string) (string, error)
+
+	// Check that a user's role in a room grants a permission
+	Authorize(ctx context.Context, userID, roomID string, permission Permission) error
+
+	// Record that a member is about to post, unless slow mode still holds
+	// them back; then return how much longer they have to wait
+	TakeSlowModeTurn(ctx context.Context, userID, roomID string) (time.Duration, error)
+
+	// Change a member's role; ownership only changes hands through a transfer
+	SetMemberRole(ctx context.Context, roomID, userID, role string) error
+
+	// Make another member the owner, leaving the previous owner an admin
+	TransferOwnership(ctx context.Context, roomID, ownerID, newOwnerID string) error
+
+	// Mute or unmute notifications from a room for a user
+	SetRoomMuted(ctx context.Context, userID, roomID string, muted bool) error
+
+	// Get the users who muted a room
+	GetMutedRoomUsers(ctx context.Context, roomID string) ([]string, error)
+
+	// Set the default lifetime of new messages in a room; zero turns it off
+	SetMessageTTL(ctx context.Context, roomID string, ttl time.Duration) error
+
+	// Set how long a room keeps its history; nil falls back to the default
+	SetRetentionPolicy(ctx context.Context, roomID string, policy *RetentionPolicy) error
+
+	// Set who can join a room
+	SetVisibility(ctx context.Context, roomID, visibility string) error
+
+	// Get a room by ID
+	GetRoom(ctx context.Context, roomID string) (*Room, error)
+
+	// Change a room's name, topic and other settings
+	UpdateRoom(ctx context.Context, roomID string, update *RoomUpdate) (*Room, error)
+
+	// Take a room out of use, returning who was in it; its history is
+	// removed later in the background
+	DeleteRoom(ctx context.Context, roomID string) ([]string, error)
+
+	// Get the deleted rooms still waiting to be purged, oldest first
+	GetDeletedRooms(ctx context.Context) ([]string, error)
+
+	// Remove a deleted room for good, along with anything still pointing at it
+	PurgeRoom(ctx context.Context, roomID string) error
 }
 
 type PostgresRoomStore struct {
@@ -45,21 +232,148 @@ func NewPostgresRoomStore(db *sql.DB) *PostgresRoomStore {
 	return &PostgresRoomStore{db: db}
 }
 
-// GetRooms returns all available rooms
+// GetRooms returns all channels
 func (s *PostgresRoomStore) GetRooms(ctx context.Context) ([]*Room, error) {
+	return s.queryRooms(ctx, `
+        SELECT `+roomColumns+`
+        FROM rooms r
+        WHERE r.kind = $1 AND r.deleted_at IS NULL
+        ORDER BY r.name
+    `, KindChannel)
+}
+
+// GetAllRooms returns every room, for jobs that have to go through all of them
+func (s *PostgresRoomStore) GetAllRooms(ctx context.Context) ([]*Room, error) {
+	return s.queryRooms(ctx, `
+        SELECT `+roomColumns+`
+        FROM rooms r
+        WHERE r.deleted_at IS NULL
+        ORDER BY r.created_at
+    `)
+}
+
+// Directory orderings
+const (
+	DirectoryByMembers  = "members"
+	DirectoryByActivity = "activity"
+	DirectoryByName     = "name"
+)
+
+// DirectoryQuery asks for a page of the public channels
+type DirectoryQuery struct {
+	Search string // Matched anywhere in the name or topic
+	Sort   string // One of the Directory* orderings; members by default
+
+	// Leave out the rooms this user is in
+	ExcludeUserID string
+
+	After string // Room the previous page ended on
+	Limit int
+}
+
+// DirectoryPage is a page of the room directory
+type DirectoryPage struct {
+	Rooms []*Room `json:"rooms"`
+
+	// Cursor for the next page; empty on the last page
+	After string `json:"after,omitempty"`
+}
+
+// directoryOrders holds, for each ordering, the sort key of a room r, the
+// same key for the cursor room c, and the direction pages move in
+var directoryOrders = map[string]struct{ key, cursorKey, order, compare string }{
+	DirectoryByMembers: {
+		"(r.member_count, r.id)", "(c.member_count, c.id)",
+		"r.member_count DESC, r.id DESC", "<",
+	},
+	DirectoryByActivity: {
+		"(COALESCE(r.last_message_at, r.created_at), r.id)", "(COALESCE(c.last_message_at, c.created_at), c.id)",
+		"COALESCE(r.last_message_at, r.created_at) DESC, r.id DESC", "<",
+	},
+	DirectoryByName: {
+		"(r.name, r.id)", "(c.name, c.id)",
+		"r.name, r.id", ">",
+	},
+}
+
+// likePattern matches s anywhere, with LIKE's wildcards in s taken literally
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// GetDirectory lists public channels that are not archived. Member counts
+// and last activity are kept on the room, so sorting by them is an index
+// scan.
+func (s *PostgresRoomStore) GetDirectory(ctx context.Context, query DirectoryQuery) (*DirectoryPage, error) {
+	order, ok := directoryOrders[query.Sort]
+	if !ok {
+		order = directoryOrders[DirectoryByMembers]
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultMessagePageSize
+	}
+	if limit > MaxMessagePageSize {
+		limit = MaxMessagePageSize
+	}
+
+	search := ""
+	if query.Search != "" {
+		search = likePattern(query.Search)
+	}
+
+	// The visibility filters are literals so the partial indexes apply
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+roomColumns+`
+        FROM rooms r
+        WHERE r.kind = '`+KindChannel+`' AND r.visibility = '`+VisibilityPublic+`' AND NOT r.archived
+          AND r.deleted_at IS NULL
+          AND ($1 = '' OR (r.name || ' ' || r.topic) ILIKE $1)
+          AND ($2 = '' OR NOT EXISTS (
+              SELECT 1 FROM room_memberships WHERE room_id = r.id AND user_id = $2
+          ))
+          AND ($3 = '' OR `+order.key+` `+order.compare+` (
+              SELECT `+order.cursorKey+` FROM rooms c WHERE c.id::text = $3
+          ))
+        ORDER BY `+order.order+`
+        LIMIT $4
+    `, search, query.ExcludeUserID, query.After, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &DirectoryPage{Rooms: []*Room{}}
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Rooms = append(page.Rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Rooms) > limit {
+		page.Rooms = page.Rooms[:limit]
+		page.After = page.Rooms[limit-1].ID
+	}
+
+	return page, nil
+}
+
+func (s *PostgresRoomStore) queryRooms(ctx context.Context, query string, args ...any) ([]*Room, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	query := `
-        SELECT id, name, created_at
-        FROM rooms
-        ORDER BY name
-    `
-
-	rows, err := tx.QueryContext(ctx, query)
+	rows, err := tx.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -67,12 +381,7 @@ func (s *PostgresRoomStore) GetRooms(ctx context.Context) ([]*Room, error) {
 
 	var rooms []*Room
 	for rows.Next() {
-		room := &Room{}
-		err = rows.Scan(
-			&room.ID,
-			&room.Name,
-			&room.CreatedAt,
-		)
+		room, err := scanRoom(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -99,15 +408,21 @@ func (s *PostgresRoomStore) GetUserRooms(ctx context.Context, userID string) ([]
 	}
 	defer tx.Rollback()
 
+	// Channels come first by name, then direct conversations
 	query := `
-        SELECT r.id, r.name, r.created_at
+        SELECT CASE WHEN r.kind = $2 THEN '' ELSE COALESCE((
+                   SELECT string_agg(o.user_id, ',' ORDER BY o.joined_at, o.user_id)
+                   FROM room_memberships o
+                   WHERE o.room_id = r.id AND o.user_id <> $1
+               ), '') END,
+               ` + roomColumns + `
         FROM rooms r
         JOIN room_memberships rm ON r.id = rm.room_id
         WHERE rm.user_id = $1
-        ORDER BY r.name
+        ORDER BY r.kind <> $2, r.name, r.created_at
     `
 
-	rows, err := tx.QueryContext(ctx, query, userID)
+	rows, err := tx.QueryContext(ctx, query, userID, KindChannel)
 	if err != nil {
 		return nil, err
 	}
@@ -115,15 +430,17 @@ func (s *PostgresRoomStore) GetUserRooms(ctx context.Context, userID string) ([]
 
 	var rooms []*Room
 	for rows.Next() {
-		room := &Room{}
-		err = rows.Scan(
-			&room.ID,
-			&room.Name,
-			&room.CreatedAt,
-		)
+		var participants string
+		room, err := scanRoom(prefixScanner{row: rows, dest: []any{&participants}})
 		if err != nil {
 			return nil, err
 		}
+		if room.Kind != KindChannel {
+			room.Participants = []string{}
+			if participants != "" {
+				room.Participants = strings.Split(participants, ",")
+			}
+		}
 		rooms = append(rooms, room)
 	}
 
@@ -181,8 +498,51 @@ func (s *PostgresRoomStore) GetRoomUsers(ctx context.Context, roomID string) ([]
 	return userIDs, nil
 }
 
-// CreateRoom creates a new chat room
-func (s *PostgresRoomStore) CreateRoom(ctx context.Context, name string) (*Room, error) {
+// GetRoomMembers returns a room's members, highest role first and then in
+// the order they joined
+func (s *PostgresRoomStore) GetRoomMembers(ctx context.Context, roomID string) ([]*RoomMember, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT user_id, role, joined_at
+        FROM room_memberships
+        WHERE room_id = $1
+        ORDER BY array_position(ARRAY['owner', 'admin', 'moderator', 'member']::VARCHAR[], role), joined_at
+    `, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*RoomMember{}
+	for rows.Next() {
+		member := &RoomMember{}
+		if err = rows.Scan(&member.UserID, &member.Role, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+// GetMembersWith returns the members of a room who hold a permission, such
+// as the moderators to tell about something that needs them
+func (s *PostgresRoomStore) GetMembersWith(ctx context.Context, roomID string, permission Permission) ([]string, error) {
+	members, err := s.GetRoomMembers(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := []string{}
+	for _, member := range members {
+		if RoleCan(member.Role, permission) {
+			userIDs = append(userIDs, member.UserID)
+		}
+	}
+	return userIDs, nil
+}
+
+// CreateRoom creates a new chat room with its creator as the owner
+func (s *PostgresRoomStore) CreateRoom(ctx context.Context, name, ownerID string) (*Room, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -190,19 +550,23 @@ func (s *PostgresRoomStore) CreateRoom(ctx context.Context, name string) (*Room,
 	defer tx.Rollback()
 
 	query := `
-        INSERT INTO rooms (name)
+        INSERT INTO rooms AS r (name)
         VALUES ($1)
-        RETURNING id, name, created_at
+        RETURNING ` + roomColumns + `
     `
 
-	row := tx.QueryRowContext(ctx, query, name)
+	room, err := scanRoom(tx.QueryRowContext(ctx, query, name))
+	if err != nil && strings.Contains(err.Error(), "idx_rooms_channel_name") {
+		return nil, ErrRoomNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	room := &Room{}
-	err = row.Scan(
-		&room.ID,
-		&room.Name,
-		&room.CreatedAt,
-	)
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO room_memberships (user_id, room_id, role)
+        VALUES ($1, $2, $3)
+    `, ownerID, room.ID, RoleOwner)
 	if err != nil {
 		return nil, err
 	}
@@ -216,7 +580,80 @@ func (s *PostgresRoomStore) CreateRoom(ctx context.Context, name string) (*Room,
 	return room, nil
 }
 
-// JoinRoom adds a user to a room
+// OpenDirectRoom returns the conversation among userID and participants,
+// creating it on first use. The same set of people always gets the same
+// room, whoever opens it; opening it again brings back anyone who left.
+// Direct conversations are invite only and everyone in them is a member.
+func (s *PostgresRoomStore) OpenDirectRoom(ctx context.Context, userID string, participants []string, name string) (*Room, bool, error) {
+	seen := map[string]bool{userID: true}
+	everyone := []string{userID}
+	for _, participant := range participants {
+		if participant != "" && !seen[participant] {
+			seen[participant] = true
+			everyone = append(everyone, participant)
+		}
+	}
+	if len(everyone) < 2 {
+		return nil, false, ErrTooFewParticipants
+	}
+	if len(everyone) > MaxGroupParticipants {
+		return nil, false, ErrTooManyParticipants
+	}
+	sort.Strings(everyone)
+
+	kind := KindGroup
+	if len(everyone) == 2 {
+		kind = KindDM
+		name = ""
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	key := strings.Join(everyone, ",")
+	created := true
+	room, err := scanRoom(tx.QueryRowContext(ctx, `
+        INSERT INTO rooms AS r (name, kind, visibility, participants_key)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (participants_key) DO NOTHING
+        RETURNING `+roomColumns+`
+    `, name, kind, VisibilityInvite, key))
+	if err == sql.ErrNoRows {
+		created = false
+		room, err = scanRoom(tx.QueryRowContext(ctx, `
+            SELECT `+roomColumns+` FROM rooms r WHERE r.participants_key = $1
+        `, key))
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, participant := range everyone {
+		if err = addMember(ctx, tx, participant, room.ID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, false, err
+	}
+
+	room.Participants = []string{}
+	for _, participant := range everyone {
+		if participant != userID {
+			room.Participants = append(room.Participants, participant)
+		}
+	}
+	return room, created, nil
+}
+
+// JoinRoom adds a user to a room. Anyone can join a public room; other rooms
+// take a pending invitation, which joining uses up. Joining a room one is
+// already in is a no-op.
 func (s *PostgresRoomStore) JoinRoom(ctx context.Context, userID, roomID string) error {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -226,13 +663,52 @@ func (s *PostgresRoomStore) JoinRoom(ctx context.Context, userID, roomID string)
 
 	fmt.Println(userID, roomID, ctx)
 
-	query := `
-        INSERT INTO room_memberships (user_id, room_id)
-        VALUES ($1, $2)
-        ON CONFLICT (user_id, room_id) DO NOTHING
-    `
+	var visibility string
+	var isMember bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT r.visibility, EXISTS(
+            SELECT 1 FROM room_memberships WHERE room_id = r.id AND user_id = $2
+        )
+        FROM rooms r
+        WHERE r.id = $1 AND r.deleted_at IS NULL
+    `, roomID, userID).Scan(&visibility, &isMember)
+	if err == sql.ErrNoRows {
+		return ErrRoomNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if isMember {
+		return nil
+	}
+
+	// Checked before the invitation so a banned user doesn't use one up
+	err = checkNotBanned(ctx, tx, roomID, userID)
+	if err != nil {
+		return err
+	}
+
+	if visibility != VisibilityPublic {
+		result, err := tx.ExecContext(ctx, `
+            DELETE FROM room_invitations WHERE room_id = $1 AND user_id = $2
+        `, roomID, userID)
+		if err != nil {
+			return err
+		}
+
+		invited, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if invited == 0 && visibility == VisibilityPrivate {
+			return ErrApprovalRequired
+		}
+		if invited == 0 {
+			return ErrInvitationRequired
+		}
+	}
 
-	_, err = tx.ExecContext(ctx, query, userID, roomID)
+	err = addMember(ctx, tx, userID, roomID)
 	if err != nil {
 		return err
 	}
@@ -246,7 +722,56 @@ func (s *PostgresRoomStore) JoinRoom(ctx context.Context, userID, roomID string)
 	return nil
 }
 
-// LeaveRoom removes a user from a room
+// addMember inserts a membership unless the user is banned, or the room is
+// archived or full. New members
+// start with the existing history marked as read, and any request of theirs
+// to join is settled.
+func addMember(ctx context.Context, tx *sql.Tx, userID, roomID string) error {
+	err := checkNotBanned(ctx, tx, roomID, userID)
+	if err != nil {
+		return err
+	}
+
+	// Locking the room lines concurrent joins up behind the member count
+	var archived, full, isMember bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT r.archived, r.member_limit > 0 AND r.member_count >= r.member_limit, EXISTS(
+            SELECT 1 FROM room_memberships WHERE room_id = r.id AND user_id = $2
+        )
+        FROM rooms r
+        WHERE r.id = $1 AND r.deleted_at IS NULL
+        FOR UPDATE
+    `, roomID, userID).Scan(&archived, &full, &isMember)
+	if err == sql.ErrNoRows {
+		return ErrRoomNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !isMember && archived {
+		return ErrRoomArchived
+	}
+	if !isMember && full {
+		return ErrRoomFull
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO room_memberships (user_id, room_id, last_read_seq)
+        VALUES ($1, $2, COALESCE((SELECT last_seq FROM rooms WHERE id = $2), 0))
+        ON CONFLICT (user_id, room_id) DO NOTHING
+    `, userID, roomID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        DELETE FROM room_join_requests WHERE room_id = $1 AND user_id = $2
+    `, roomID, userID)
+	return err
+}
+
+// LeaveRoom removes a user from a room. The owner cannot leave until they
+// hand the room over.
 func (s *PostgresRoomStore) LeaveRoom(ctx context.Context, userID, roomID string) error {
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -257,12 +782,20 @@ func (s *PostgresRoomStore) LeaveRoom(ctx context.Context, userID, roomID string
 	query := `
         DELETE FROM room_memberships
         WHERE user_id = $1 AND room_id = $2
+        RETURNING role
     `
 
-	_, err = tx.ExecContext(ctx, query, userID, roomID)
+	var role string
+	err = tx.QueryRowContext(ctx, query, userID, roomID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	if role == RoleOwner {
+		return ErrOwnerCannotLeave
+	}
 
 	err = tx.Commit()
 	if err != nil {
@@ -301,3 +834,467 @@ func (s *PostgresRoomStore) IsUserInRoom(ctx context.Context, userID, roomID str
 
 	return exists, nil
 }
+
+// GetMemberRole returns the user's role in a room, or an empty string if the
+// user is not a member
+func (s *PostgresRoomStore) GetMemberRole(ctx context.Context, userID, roomID string) (string, error) {
+	query := `
+        SELECT role FROM room_memberships
+        WHERE user_id = $1 AND room_id = $2
+    `
+
+	var role string
+	err := s.db.QueryRowContext(ctx, query, userID, roomID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return role, nil
+}
+
+// Authorize returns ErrNotRoomMember if the user is not in the room and
+// ErrForbidden if their role does not grant the permission. Nothing can be
+// written to an archived room, which gives ErrRoomArchived, and a muted user
+// gets ErrMuted when trying to post.
+func (s *PostgresRoomStore) Authorize(ctx context.Context, userID, roomID string, permission Permission) error {
+	var role string
+	var muted, archived bool
+	err := s.db.QueryRowContext(ctx, `
+        SELECT rm.role, EXISTS(
+            SELECT 1 FROM room_mutes
+            WHERE room_id = rm.room_id AND user_id = rm.user_id AND expires_at > NOW()
+        ), r.archived
+        FROM room_memberships rm
+        JOIN rooms r ON r.id = rm.room_id
+        WHERE rm.user_id = $1 AND rm.room_id = $2
+    `, userID, roomID).Scan(&role, &muted, &archived)
+	if err == sql.ErrNoRows {
+		return ErrNotRoomMember
+	}
+	if err != nil {
+		return err
+	}
+	if !RoleCan(role, permission) {
+		return ErrForbidden
+	}
+	if archived && permission.Writes() {
+		return ErrRoomArchived
+	}
+	if permission == PermPost && muted {
+		return ErrMuted
+	}
+
+	return nil
+}
+
+// TakeSlowModeTurn lets a member post if the room's slow mode allows it,
+// and starts their wait for the next message. Moderators are not held back.
+// Claiming the turn before the message is stored keeps two quick messages
+// from both getting through.
+func (s *PostgresRoomStore) TakeSlowModeTurn(ctx context.Context, userID, roomID string) (time.Duration, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var role string
+	var slowMode int
+	var lastPostedAt sql.NullTime
+	var now time.Time
+	err = tx.QueryRowContext(ctx, `
+        SELECT rm.role, r.slow_mode, rm.last_posted_at, CURRENT_TIMESTAMP
+        FROM room_memberships rm
+        JOIN rooms r ON r.id = rm.room_id
+        WHERE rm.user_id = $1 AND rm.room_id = $2
+        FOR UPDATE OF rm
+    `, userID, roomID).Scan(&role, &slowMode, &lastPostedAt, &now)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotRoomMember
+	}
+	if err != nil {
+		return 0, err
+	}
+	if slowMode == 0 || RoleCan(role, PermSkipSlowMode) {
+		return 0, nil
+	}
+
+	if lastPostedAt.Valid {
+		wait := lastPostedAt.Time.Add(time.Duration(slowMode) * time.Second).Sub(now)
+		if wait > 0 {
+			return wait, nil
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE room_memberships SET last_posted_at = $3 WHERE user_id = $1 AND room_id = $2
+    `, userID, roomID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// SetMemberRole changes the role of a member other than the owner. Making
+// someone the owner goes through TransferOwnership instead.
+func (s *PostgresRoomStore) SetMemberRole(ctx context.Context, roomID, userID, role string) error {
+	if role == RoleOwner || !ValidRole(role) {
+		return ErrForbidden
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE room_memberships SET role = $3
+        WHERE room_id = $1 AND user_id = $2 AND role <> $4
+    `, roomID, userID, role, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	current, err := s.GetMemberRole(ctx, userID, roomID)
+	if err != nil {
+		return err
+	}
+	if current == RoleOwner {
+		return ErrForbidden
+	}
+	return ErrNotRoomMember
+}
+
+// TransferOwnership hands a room from its owner to another member. The
+// previous owner stays on as an admin.
+func (s *PostgresRoomStore) TransferOwnership(ctx context.Context, roomID, ownerID, newOwnerID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRowContext(ctx, `
+        SELECT role FROM room_memberships
+        WHERE room_id = $1 AND user_id = $2
+        FOR UPDATE
+    `, roomID, ownerID).Scan(&role)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if role != RoleOwner {
+		return ErrForbidden
+	}
+
+	// Step down first; the room may only have one owner at a time
+	_, err = tx.ExecContext(ctx, `
+        UPDATE room_memberships SET role = $3
+        WHERE room_id = $1 AND user_id = $2
+    `, roomID, ownerID, RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE room_memberships SET role = $3
+        WHERE room_id = $1 AND user_id = $2
+    `, roomID, newOwnerID, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotRoomMember
+	}
+
+	return tx.Commit()
+}
+
+// SetRoomMuted mutes or unmutes notifications from a room for a user
+func (s *PostgresRoomStore) SetRoomMuted(ctx context.Context, userID, roomID string, muted bool) error {
+	query := `
+        UPDATE room_memberships
+        SET muted = $3
+        WHERE user_id = $1 AND room_id = $2
+    `
+	result, err := s.db.ExecContext(ctx, query, userID, roomID, muted)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrNotRoomMember
+	}
+
+	return nil
+}
+
+// GetMutedRoomUsers returns the IDs of members who muted a room
+func (s *PostgresRoomStore) GetMutedRoomUsers(ctx context.Context, roomID string) ([]string, error) {
+	query := `
+        SELECT user_id
+        FROM room_memberships
+        WHERE room_id = $1 AND muted
+    `
+	rows, err := s.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err = rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
+
+// SetMessageTTL sets how long new messages in a room live when they don't
+// ask for a lifetime of their own. Existing messages keep theirs.
+func (s *PostgresRoomStore) SetMessageTTL(ctx context.Context, roomID string, ttl time.Duration) error {
+	seconds := sql.NullInt64{Int64: int64(ttl / time.Second), Valid: ttl > 0}
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE rooms SET message_ttl = $2 WHERE id = $1
+    `, roomID, seconds)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRoomNotFound
+	}
+
+	return nil
+}
+
+// SetRetentionPolicy sets how long a room keeps its history. A nil policy
+// clears it so the server-wide default applies again.
+func (s *PostgresRoomStore) SetRetentionPolicy(ctx context.Context, roomID string, policy *RetentionPolicy) error {
+	var days sql.NullInt64
+	var action sql.NullString
+	if policy != nil {
+		days = sql.NullInt64{Int64: int64(policy.Days), Valid: true}
+		action = sql.NullString{String: policy.Action, Valid: true}
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE rooms SET retention_days = $2, retention_action = $3 WHERE id = $1
+    `, roomID, days, action)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRoomNotFound
+	}
+
+	return nil
+}
+
+// SetVisibility sets who can join a room. Members stay either way.
+func (s *PostgresRoomStore) SetVisibility(ctx context.Context, roomID, visibility string) error {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE rooms SET visibility = $2 WHERE id = $1
+    `, roomID, visibility)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRoomNotFound
+	}
+
+	return nil
+}
+
+// GetRoom returns a single room
+func (s *PostgresRoomStore) GetRoom(ctx context.Context, roomID string) (*Room, error) {
+	room, err := scanRoom(s.db.QueryRowContext(ctx, `
+        SELECT `+roomColumns+` FROM rooms r WHERE r.id = $1 AND r.deleted_at IS NULL
+    `, roomID))
+	if err == sql.ErrNoRows {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// UpdateRoom applies the non-nil fields of an update. Channel names stay
+// unique; renaming onto a taken name returns ErrRoomNameTaken.
+func (s *PostgresRoomStore) UpdateRoom(ctx context.Context, roomID string, update *RoomUpdate) (*Room, error) {
+	room, err := scanRoom(s.db.QueryRowContext(ctx, `
+        UPDATE rooms AS r
+        SET name = COALESCE($2, r.name),
+            topic = COALESCE($3, r.topic),
+            description = COALESCE($4, r.description),
+            avatar_url = COALESCE($5, r.avatar_url),
+            archived = COALESCE($6, r.archived),
+            slow_mode = COALESCE($7, r.slow_mode),
+            member_limit = COALESCE($8, r.member_limit)
+        WHERE r.id = $1 AND r.deleted_at IS NULL
+        RETURNING `+roomColumns+`
+    `,
+		roomID,
+		update.Name,
+		update.Topic,
+		update.Description,
+		update.AvatarURL,
+		update.Archived,
+		update.SlowMode,
+		update.MemberLimit,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil && strings.Contains(err.Error(), "idx_rooms_channel_name") {
+		return nil, ErrRoomNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// DeleteRoom takes a room out of use at once: its members, invites and
+// messages waiting to be sent go now, which also stops its events. Its
+// history can be large, so it is left for the retention worker to remove in
+// batches before the room itself is purged.
+func (s *PostgresRoomStore) DeleteRoom(ctx context.Context, roomID string) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Clearing the key lets the same people start a new conversation
+	result, err := tx.ExecContext(ctx, `
+        UPDATE rooms SET deleted_at = CURRENT_TIMESTAMP, participants_key = NULL
+        WHERE id = $1 AND deleted_at IS NULL
+    `, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrRoomNotFound
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+        DELETE FROM room_memberships WHERE room_id = $1 RETURNING user_id
+    `, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []string{}
+	for rows.Next() {
+		var userID string
+		if err = rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		members = append(members, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, table := range []string{"room_invites", "room_invitations", "room_join_requests", "scheduled_messages"} {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE room_id = $1`, roomID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Room deleted:", roomID)
+	return members, nil
+}
+
+// GetDeletedRooms returns the IDs of deleted rooms that are not purged yet
+func (s *PostgresRoomStore) GetDeletedRooms(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id FROM rooms WHERE deleted_at IS NOT NULL ORDER BY deleted_at
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roomIDs []string
+	for rows.Next() {
+		var roomID string
+		if err = rows.Scan(&roomID); err != nil {
+			return nil, err
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+
+	return roomIDs, rows.Err()
+}
+
+// PurgeRoom removes a deleted room. Whatever still belongs to it, messages
+// included, goes with it through the foreign keys, so callers clear out the
+// messages first to keep this transaction small.
+func (s *PostgresRoomStore) PurgeRoom(ctx context.Context, roomID string) error {
+	_, err := s.db.ExecContext(ctx, `
+        DELETE FROM rooms WHERE id = $1 AND deleted_at IS NOT NULL
+    `, roomID)
+	return err
+}
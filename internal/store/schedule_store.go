@@ -0,0 +1,413 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	ErrScheduledMessageNotFound = errors.New("scheduled message not found")
+	ErrReminderNotFound         = errors.New("reminder not found")
+
+	// What the check given to ClaimDueMessages returns for a due message
+	// that isn't sent now: dropped ones are removed, postponed ones move to
+	// the SendAt the check set
+	ErrScheduledDropped   = errors.New("scheduled message dropped")
+	ErrScheduledPostponed = errors.New("scheduled message postponed")
+)
+
+// ScheduledMessage is a chat message waiting to be sent at SendAt
+type ScheduledMessage struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	RoomID    string    `json:"room_id"`
+	Content   string    `json:"content"`
+	Format    string    `json:"format"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	SendAt    time.Time `json:"send_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Reminder brings a message back to a user's attention at RemindAt
+type Reminder struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	MessageID string    `json:"message_id"`
+	RemindAt  time.Time `json:"remind_at"`
+	CreatedAt time.Time `json:"created_at"`
+	Message   *Message  `json:"message,omitempty"`
+}
+
+type ScheduleStore interface {
+	// Queue a message to be sent later
+	CreateScheduledMessage(ctx context.Context, scheduled *ScheduledMessage) (*ScheduledMessage, error)
+
+	// Get a user's pending messages, soonest first
+	GetScheduledMessages(ctx context.Context, userID string) ([]*ScheduledMessage, error)
+
+	// Cancel one of a user's pending messages
+	CancelScheduledMessage(ctx context.Context, userID, id string) error
+
+	// Set a reminder about a message
+	CreateReminder(ctx context.Context, reminder *Reminder) (*Reminder, error)
+
+	// Get a user's pending reminders, soonest first
+	GetReminders(ctx context.Context, userID string) ([]*Reminder, error)
+
+	// Cancel one of a user's pending reminders
+	CancelReminder(ctx context.Context, userID, id string) error
+
+	// Check up to limit due messages, remove the ones that passed and return
+	// them to be sent
+	ClaimDueMessages(ctx context.Context, limit int, check func(*ScheduledMessage) error) ([]*ScheduledMessage, error)
+
+	// Remove up to limit due reminders and return them to be sent
+	ClaimDueReminders(ctx context.Context, limit int) ([]*Reminder, error)
+}
+
+type PostgresScheduleStore struct {
+	db *sql.DB
+}
+
+func NewPostgresScheduleStore(db *sql.DB) *PostgresScheduleStore {
+	return &PostgresScheduleStore{db: db}
+}
+
+const scheduledMessageColumns = `id, user_id, room_id, content, format, parent_id, send_at, created_at`
+
+func scanScheduledMessage(row rowScanner) (*ScheduledMessage, error) {
+	scheduled := &ScheduledMessage{}
+	var parentID sql.NullString
+	err := row.Scan(
+		&scheduled.ID,
+		&scheduled.UserID,
+		&scheduled.RoomID,
+		&scheduled.Content,
+		&scheduled.Format,
+		&parentID,
+		&scheduled.SendAt,
+		&scheduled.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduled.ParentID = parentID.String
+	return scheduled, nil
+}
+
+// CreateScheduledMessage stores a message to be sent at its SendAt
+func (s *PostgresScheduleStore) CreateScheduledMessage(ctx context.Context, scheduled *ScheduledMessage) (*ScheduledMessage, error) {
+	if scheduled.Format == "" {
+		scheduled.Format = FormatPlain
+	}
+
+	query := `
+        INSERT INTO scheduled_messages (user_id, room_id, content, format, parent_id, send_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
+    `
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		scheduled.UserID,
+		scheduled.RoomID,
+		scheduled.Content,
+		scheduled.Format,
+		nullString(scheduled.ParentID),
+		scheduled.SendAt,
+	).Scan(&scheduled.ID, &scheduled.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return scheduled, nil
+}
+
+// GetScheduledMessages lists a user's pending messages
+func (s *PostgresScheduleStore) GetScheduledMessages(ctx context.Context, userID string) ([]*ScheduledMessage, error) {
+	query := `
+        SELECT ` + scheduledMessageColumns + `
+        FROM scheduled_messages
+        WHERE user_id = $1
+        ORDER BY send_at, id
+    `
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scheduled := []*ScheduledMessage{}
+	for rows.Next() {
+		message, err := scanScheduledMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		scheduled = append(scheduled, message)
+	}
+
+	return scheduled, rows.Err()
+}
+
+// CancelScheduledMessage deletes a pending message. A message that is being
+// sent right now is locked by the scheduler and can no longer be cancelled.
+func (s *PostgresScheduleStore) CancelScheduledMessage(ctx context.Context, userID, id string) error {
+	query := `
+        DELETE FROM scheduled_messages
+        WHERE id = (
+            SELECT id FROM scheduled_messages
+            WHERE id::text = $1 AND user_id = $2
+            FOR UPDATE SKIP LOCKED
+        )
+    `
+	result, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrScheduledMessageNotFound
+	}
+
+	return nil
+}
+
+// CreateReminder stores a reminder
+func (s *PostgresScheduleStore) CreateReminder(ctx context.Context, reminder *Reminder) (*Reminder, error) {
+	query := `
+        INSERT INTO reminders (user_id, message_id, remind_at)
+        SELECT $1, $2, $3
+        WHERE EXISTS (SELECT 1 FROM messages WHERE id = $2)
+        RETURNING id, created_at
+    `
+	err := s.db.QueryRowContext(ctx, query, reminder.UserID, reminder.MessageID, reminder.RemindAt).
+		Scan(&reminder.ID, &reminder.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return reminder, nil
+}
+
+// reminderQuery selects reminders with the messages they point at.
+// Reminders about deleted messages are skipped.
+const reminderQuery = `
+        SELECT r.id, r.user_id, r.message_id, r.remind_at, r.created_at, m.*
+        FROM reminders r
+        JOIN LATERAL (
+            SELECT ` + messageColumns + `
+            FROM messages
+            WHERE id = r.message_id AND deleted_at IS NULL AND ` + notExpired + `
+        ) m ON true
+`
+
+func scanReminder(row rowScanner) (*Reminder, error) {
+	reminder := &Reminder{}
+	message, err := scanMessage(prefixScanner{
+		row:  row,
+		dest: []any{&reminder.ID, &reminder.UserID, &reminder.MessageID, &reminder.RemindAt, &reminder.CreatedAt},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reminder.Message = message
+	return reminder, nil
+}
+
+// GetReminders lists a user's pending reminders
+func (s *PostgresScheduleStore) GetReminders(ctx context.Context, userID string) ([]*Reminder, error) {
+	rows, err := s.db.QueryContext(ctx, reminderQuery+`
+        WHERE r.user_id = $1
+        ORDER BY r.remind_at, r.id
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []*Reminder{}
+	for rows.Next() {
+		reminder, err := scanReminder(rows)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, reminder)
+	}
+
+	return reminders, rows.Err()
+}
+
+// CancelReminder deletes a pending reminder
+func (s *PostgresScheduleStore) CancelReminder(ctx context.Context, userID, id string) error {
+	query := `
+        DELETE FROM reminders
+        WHERE id = (
+            SELECT id FROM reminders
+            WHERE id::text = $1 AND user_id = $2
+            FOR UPDATE SKIP LOCKED
+        )
+    `
+	result, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrReminderNotFound
+	}
+
+	return nil
+}
+
+// ClaimDueMessages locks due messages with SKIP LOCKED, so every instance
+// polling the table gets a different batch, and keeps them locked while they
+// are checked. The messages that passed are removed before they are handed
+// back, so a failed commit can't send one twice. Rows whose check failed
+// stay for the next poll.
+func (s *PostgresScheduleStore) ClaimDueMessages(ctx context.Context, limit int, check func(*ScheduledMessage) error) ([]*ScheduledMessage, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+scheduledMessageColumns+`
+        FROM scheduled_messages
+        WHERE send_at <= CURRENT_TIMESTAMP
+        ORDER BY send_at
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var due []*ScheduledMessage
+	for rows.Next() {
+		scheduled, err := scanScheduledMessage(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, scheduled)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var passed []*ScheduledMessage
+	var removed []string
+	for _, scheduled := range due {
+		err := check(scheduled)
+		switch {
+		case err == nil:
+			passed = append(passed, scheduled)
+			removed = append(removed, scheduled.ID)
+		case errors.Is(err, ErrScheduledDropped):
+			removed = append(removed, scheduled.ID)
+		case errors.Is(err, ErrScheduledPostponed):
+			_, err = tx.ExecContext(ctx, `UPDATE scheduled_messages SET send_at = $2 WHERE id = $1`, scheduled.ID, scheduled.SendAt)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			log.Printf("Error checking scheduled message %s: %v", scheduled.ID, err)
+		}
+	}
+
+	if len(removed) > 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM scheduled_messages WHERE id = ANY($1)`, removed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return passed, nil
+}
+
+// ClaimDueReminders claims due reminders like ClaimDueMessages. There is
+// nothing to check, so every one claimed is removed and handed back.
+func (s *PostgresScheduleStore) ClaimDueReminders(ctx context.Context, limit int) ([]*Reminder, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Nothing is left to remind anyone of once the message is gone
+	_, err = tx.ExecContext(ctx, `
+        DELETE FROM reminders r
+        USING messages m
+        WHERE m.id = r.message_id AND m.deleted_at IS NOT NULL
+          AND r.remind_at <= CURRENT_TIMESTAMP
+    `)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, reminderQuery+`
+        WHERE r.remind_at <= CURRENT_TIMESTAMP
+        ORDER BY r.remind_at
+        LIMIT $1
+        FOR UPDATE OF r SKIP LOCKED
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var due []*Reminder
+	for rows.Next() {
+		reminder, err := scanReminder(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, reminder)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, reminder := range due {
+		removed = append(removed, reminder.ID)
+	}
+
+	if len(removed) > 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM reminders WHERE id = ANY($1)`, removed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return due, nil
+}
@@ -0,0 +1,249 @@
+// Package unfurl turns URLs posted in messages into preview cards.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+const (
+	// Whole request, redirects included
+	fetchTimeout = 8 * time.Second
+
+	// Only the head of a page matters; the rest is never read
+	maxBodySize = 512 << 10
+
+	maxRedirects = 3
+
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+
+	userAgent = "gochat-unfurler/1.0"
+)
+
+var (
+	ErrBlockedAddress = errors.New("unfurl: address is not publicly routable")
+	ErrNotHTML        = errors.New("unfurl: response is not an HTML page")
+)
+
+// Ranges net.IP has no predicate for that must never be fetched from
+var blockedNets = mustParseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved
+	"64:ff9b::/96",  // NAT64, which can reach private IPv4
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// publicIP reports whether ip is on the public internet
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, ipNet := range blockedNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// blockPrivate runs for every connection after DNS resolution, so neither a
+// hostname pointing inside the network nor a redirect to one gets through
+func blockPrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+// Fetcher downloads pages and reads their OpenGraph and Twitter card tags
+type Fetcher struct {
+	client *http.Client
+}
+
+func NewFetcher() *Fetcher {
+	return newFetcher(blockPrivate)
+}
+
+// newFetcher builds a Fetcher that checks each connection with control.
+// Tests pass their own to reach servers on localhost.
+func newFetcher(control func(network, address string, c syscall.RawConn) error) *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: 3 * time.Second,
+		Control: control,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   3 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   fetchTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("unfurl: too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("unfurl: redirect to %s URL", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Fetch builds a preview of the page at rawURL. A page without a title gives
+// a preview with OK unset.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*store.LinkPreview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unfurl: %s answered %d", rawURL, resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	meta, title := parseHead(string(body))
+	preview := &store.LinkPreview{
+		URL:         rawURL,
+		Title:       truncate(first(meta["og:title"], meta["twitter:title"], title), maxTitleLength),
+		Description: truncate(first(meta["og:description"], meta["twitter:description"], meta["description"]), maxDescriptionLength),
+		ImageURL:    resolveImage(resp.Request.URL, first(meta["og:image"], meta["og:image:url"], meta["twitter:image"])),
+		SiteName:    truncate(first(meta["og:site_name"], resp.Request.URL.Hostname()), maxTitleLength),
+		FetchedAt:   time.Now(),
+	}
+	preview.OK = preview.Title != ""
+
+	return preview, nil
+}
+
+var (
+	headEnd      = regexp.MustCompile(`(?i)</head\s*>`)
+	metaTag      = regexp.MustCompile(`(?is)<meta\s+([^>]*)>`)
+	titleTag     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title\s*>`)
+	tagAttribute = regexp.MustCompile(`(?is)([a-z][a-z:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// parseHead collects the meta tags of a page, keyed by their lowercased
+// property or name, and its title. The first occurrence of a key wins.
+func parseHead(page string) (map[string]string, string) {
+	if loc := headEnd.FindStringIndex(page); loc != nil {
+		page = page[:loc[0]]
+	}
+
+	meta := make(map[string]string)
+	for _, tag := range metaTag.FindAllStringSubmatch(page, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range tagAttribute.FindAllStringSubmatch(tag[1], -1) {
+			attrs[strings.ToLower(attr[1])] = attr[2] + attr[3] + attr[4]
+		}
+
+		key := strings.ToLower(first(attrs["property"], attrs["name"]))
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = clean(attrs["content"])
+		}
+	}
+
+	var title string
+	if m := titleTag.FindStringSubmatch(page); m != nil {
+		title = clean(m[1])
+	}
+
+	return meta, title
+}
+
+// clean decodes entities and collapses whitespace in a tag value
+func clean(s string) string {
+	s = strings.ToValidUTF8(html.UnescapeString(s), "")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// resolveImage makes a relative image URL absolute against the page it was
+// found on. Only http(s) images are kept.
+func resolveImage(page *url.URL, raw string) string {
+	if raw == "" {
+		return ""
+	}
+	image, err := page.Parse(raw)
+	if err != nil || (image.Scheme != "http" && image.Scheme != "https") {
+		return ""
+	}
+	return image.String()
+}
+
+func first(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func truncate(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	return string([]rune(s)[:limit-1]) + "…"
+}
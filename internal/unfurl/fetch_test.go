@@ -0,0 +1,238 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// allowOnly lets a test fetcher reach the given test servers and treats
+// every other address as the production fetcher would
+func allowOnly(servers ...*httptest.Server) func(string, string, syscall.RawConn) error {
+	allowed := make(map[string]bool)
+	for _, server := range servers {
+		allowed[server.Listener.Addr().String()] = true
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if allowed[address] {
+			return nil
+		}
+		return blockPrivate(network, address, c)
+	}
+}
+
+func pageServer(t *testing.T, contentType, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchReadsCardTags(t *testing.T) {
+	server := pageServer(t, "text/html; charset=utf-8", `<!doctype html>
+<html><head>
+  <title>Fallback title</title>
+  <meta property="og:title" content="Release &amp; notes">
+  <meta name="description" content="Plain description">
+  <meta property="og:description" content="  Open   Graph
+    description ">
+  <meta property="og:image" content="/img/card.png">
+  <meta property="og:site_name" content="Example">
+</head><body><meta property="og:title" content="Body tags are ignored"></body></html>`)
+
+	preview, err := newFetcher(allowOnly(server)).Fetch(context.Background(), server.URL+"/post")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !preview.OK {
+		t.Fatal("preview not OK")
+	}
+	if preview.Title != "Release & notes" {
+		t.Errorf("title %q", preview.Title)
+	}
+	if preview.Description != "Open Graph description" {
+		t.Errorf("description %q", preview.Description)
+	}
+	if preview.ImageURL != server.URL+"/img/card.png" {
+		t.Errorf("image %q", preview.ImageURL)
+	}
+	if preview.SiteName != "Example" {
+		t.Errorf("site name %q", preview.SiteName)
+	}
+}
+
+func TestParseHead(t *testing.T) {
+	tests := []struct {
+		name  string
+		page  string
+		key   string
+		value string
+		title string
+	}{
+		{"single quotes", `<meta property='og:title' content='Quoted'>`, "og:title", "Quoted", ""},
+		{"unquoted", `<meta name=description content=Bare>`, "description", "Bare", ""},
+		{"attribute order", `<meta content="Late" property="og:title">`, "og:title", "Late", ""},
+		{"case", `<META PROPERTY="OG:Title" CONTENT="Upper">`, "og:title", "Upper", ""},
+		{"first wins", `<meta property="og:title" content="One"><meta property="og:title" content="Two">`, "og:title", "One", ""},
+		{"title", `<title>  A
+  page </title>`, "", "", "A page"},
+		{"entities", `<title>Tom &amp; Jerry &#8212; &lt;3</title>`, "", "", "Tom & Jerry — <3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, title := parseHead(tt.page)
+			if tt.key != "" && meta[tt.key] != tt.value {
+				t.Errorf("%s = %q, want %q", tt.key, meta[tt.key], tt.value)
+			}
+			if title != tt.title {
+				t.Errorf("title %q, want %q", title, tt.title)
+			}
+		})
+	}
+}
+
+func TestFetchWithoutTitle(t *testing.T) {
+	server := pageServer(t, "text/html", `<html><head></head><body>Nothing here</body></html>`)
+
+	preview, err := newFetcher(allowOnly(server)).Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.OK {
+		t.Fatal("a page without a title gave a preview")
+	}
+}
+
+func TestFetchRejectsNonHTML(t *testing.T) {
+	for _, contentType := range []string{"application/json", "image/png", "text/plain", ""} {
+		t.Run(contentType, func(t *testing.T) {
+			server := pageServer(t, contentType, `<title>Looks like HTML</title>`)
+			_, err := newFetcher(allowOnly(server)).Fetch(context.Background(), server.URL)
+			if !errors.Is(err, ErrNotHTML) {
+				t.Fatalf("got %v, want ErrNotHTML", err)
+			}
+		})
+	}
+}
+
+func TestFetchRejectsErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	if _, err := newFetcher(allowOnly(server)).Fetch(context.Background(), server.URL); err == nil {
+		t.Fatal("a 404 page gave a preview")
+	}
+}
+
+func TestFetchReadsOnlyTheHead(t *testing.T) {
+	// The title sits past the body limit, so it is never read
+	page := "<html><head>" + strings.Repeat("<!-- padding -->", maxBodySize/16+1) + "<title>Too far</title></head></html>"
+	server := pageServer(t, "text/html", page)
+
+	preview, err := newFetcher(allowOnly(server)).Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.OK || preview.Title != "" {
+		t.Fatalf("read past the body limit: title %q", preview.Title)
+	}
+}
+
+func TestFetchTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	fetcher := newFetcher(allowOnly(server))
+	fetcher.client.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if _, err := fetcher.Fetch(context.Background(), server.URL); err == nil {
+		t.Fatal("a page that never answers gave a preview")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("gave up after %s", elapsed)
+	}
+}
+
+func TestFetchBlocksPrivateAddresses(t *testing.T) {
+	server := pageServer(t, "text/html", `<title>Internal admin</title>`)
+
+	// The real fetcher, which must refuse the loopback test server
+	_, err := NewFetcher().Fetch(context.Background(), server.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("got %v, want ErrBlockedAddress", err)
+	}
+}
+
+func TestFetchBlocksRedirectsToPrivateAddresses(t *testing.T) {
+	internal := pageServer(t, "text/html", `<title>Internal admin</title>`)
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusFound)
+	}))
+	defer public.Close()
+
+	// Only the redirecting server counts as public
+	_, err := newFetcher(allowOnly(public)).Fetch(context.Background(), public.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("got %v, want ErrBlockedAddress", err)
+	}
+}
+
+func TestFetchLimitsRedirects(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, server.URL+r.URL.Path+"x", http.StatusFound)
+	}))
+	defer server.Close()
+
+	_, err := newFetcher(allowOnly(server)).Fetch(context.Background(), server.URL+"/")
+	if err == nil || !strings.Contains(err.Error(), "too many redirects") {
+		t.Fatalf("got %v, want too many redirects", err)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // cloud metadata
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"64:ff9b::a00:1", false}, // NAT64 of 10.0.0.1
+	}
+
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+const (
+	// URLs unfurled per message; the rest are ignored
+	maxPreviews = 3
+
+	maxURLLength = 2048
+
+	// How long a fetched page is trusted, and how long a page that could
+	// not be unfurled is left alone before it is tried again
+	cacheTTL  = 24 * time.Hour
+	failedTTL = time.Hour
+
+	// Messages waiting to be unfurled; when full, new ones get no previews
+	queueSize = 256
+)
+
+var linkPattern = regexp.MustCompile("https?://[^\\s<>\"'`]+")
+
+// extractURLs returns the distinct http(s) URLs in a message, in order
+func extractURLs(content string) []string {
+	seen := make(map[string]bool)
+	var urls []string
+	for _, match := range linkPattern.FindAllString(content, -1) {
+		link := strings.TrimRight(match, ".,;:!?)]*_")
+		if strings.HasSuffix(link, "://") || len(link) > maxURLLength || seen[link] {
+			continue
+		}
+		seen[link] = true
+		urls = append(urls, link)
+		if len(urls) == maxPreviews {
+			break
+		}
+	}
+	return urls
+}
+
+// Worker unfurls the links in new messages in the background and pushes the
+// message again, now with previews, once they are ready
+type Worker struct {
+	previewStore store.LinkPreviewStore
+	messageStore store.MessageStore
+	events       *events.Bus
+	fetcher      *Fetcher
+	queue        chan *store.Message
+}
+
+func NewWorker(previewStore store.LinkPreviewStore, messageStore store.MessageStore, bus *events.Bus) *Worker {
+	return &Worker{
+		previewStore: previewStore,
+		messageStore: messageStore,
+		events:       bus,
+		fetcher:      NewFetcher(),
+		queue:        make(chan *store.Message, queueSize),
+	}
+}
+
+// Start runs the given number of goroutines working through the queue
+func (w *Worker) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for message := range w.queue {
+				w.process(message)
+			}
+		}()
+	}
+}
+
+// Enqueue schedules a stored message for unfurling without ever blocking the
+// caller
+func (w *Worker) Enqueue(message *store.Message) {
+	if message.Type != "chat" || !linkPattern.MatchString(message.Content) {
+		return
+	}
+
+	select {
+	case w.queue <- message:
+	default:
+		log.Printf("Unfurl queue is full, skipping message %s", message.ID)
+	}
+}
+
+func (w *Worker) process(message *store.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), maxPreviews*fetchTimeout)
+	defer cancel()
+
+	var found []string
+	for _, link := range extractURLs(message.Content) {
+		preview, err := w.preview(ctx, link)
+		if err != nil {
+			log.Printf("Error unfurling %s: %v", link, err)
+			continue
+		}
+		if preview.OK {
+			found = append(found, link)
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+
+	err := w.previewStore.AttachLinkPreviews(ctx, message.ID, found)
+	if err != nil {
+		log.Printf("Error attaching link previews: %v", err)
+		return
+	}
+
+	updated, err := w.messageStore.GetMessage(ctx, message.ID)
+	if err != nil {
+		log.Printf("Error reloading unfurled message: %v", err)
+		return
+	}
+	if updated.DeletedAt != nil {
+		return
+	}
+
+	w.events.PublishRoom(events.MessageUpdated(updated))
+}
+
+// preview returns the cached preview of a URL, fetching it when there is none
+// or it has gone stale. Failures are cached as well, so a dead link in a busy
+// room isn't fetched for every message that repeats it.
+func (w *Worker) preview(ctx context.Context, link string) (*store.LinkPreview, error) {
+	cached, err := w.previewStore.GetLinkPreview(ctx, link)
+	if err != nil && !errors.Is(err, store.ErrLinkPreviewNotFound) {
+		return nil, err
+	}
+	if cached != nil {
+		ttl := cacheTTL
+		if !cached.OK {
+			ttl = failedTTL
+		}
+		if time.Since(cached.FetchedAt) < ttl {
+			return cached, nil
+		}
+	}
+
+	preview, err := w.fetcher.Fetch(ctx, link)
+	if err != nil {
+		log.Printf("Could not unfurl %s: %v", link, err)
+		preview = &store.LinkPreview{URL: link, FetchedAt: time.Now()}
+	}
+
+	err = w.previewStore.SaveLinkPreview(ctx, preview)
+	if err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
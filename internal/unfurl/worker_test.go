@@ -0,0 +1,144 @@
+package unfurl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+type fakePreviewStore struct {
+	previews map[string]*store.LinkPreview
+}
+
+func (f *fakePreviewStore) GetLinkPreview(ctx context.Context, url string) (*store.LinkPreview, error) {
+	preview, ok := f.previews[url]
+	if !ok {
+		return nil, store.ErrLinkPreviewNotFound
+	}
+	return preview, nil
+}
+
+func (f *fakePreviewStore) SaveLinkPreview(ctx context.Context, preview *store.LinkPreview) error {
+	f.previews[preview.URL] = preview
+	return nil
+}
+
+func (f *fakePreviewStore) AttachLinkPreviews(ctx context.Context, messageID string, urls []string) error {
+	return nil
+}
+
+func TestExtractURLs(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"no links here", nil},
+		{"see https://a.test/x.", []string{"https://a.test/x"}},
+		{"(http://a.test) and http://a.test", []string{"http://a.test"}},
+		{"ftp://a.test https://", nil},
+		{"https://1.test https://2.test https://3.test https://4.test", []string{"https://1.test", "https://2.test", "https://3.test"}},
+	}
+
+	for _, tt := range tests {
+		if got := extractURLs(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("extractURLs(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestPreviewCache(t *testing.T) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.URL.Path == "/dead" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>Fresh</title>`))
+	}))
+	defer server.Close()
+
+	page, dead := server.URL+"/page", server.URL+"/dead"
+	tests := []struct {
+		name    string
+		cached  *store.LinkPreview
+		link    string
+		fetched bool
+		title   string
+		ok      bool
+	}{
+		{
+			name:    "nothing cached",
+			link:    page,
+			fetched: true,
+			title:   "Fresh",
+			ok:      true,
+		},
+		{
+			name:   "fresh cache",
+			cached: &store.LinkPreview{URL: page, Title: "Cached", OK: true, FetchedAt: time.Now().Add(-time.Hour)},
+			link:   page,
+			title:  "Cached",
+			ok:     true,
+		},
+		{
+			name:    "stale cache",
+			cached:  &store.LinkPreview{URL: page, Title: "Cached", OK: true, FetchedAt: time.Now().Add(-cacheTTL - time.Minute)},
+			link:    page,
+			fetched: true,
+			title:   "Fresh",
+			ok:      true,
+		},
+		{
+			name:   "recent failure is not retried",
+			cached: &store.LinkPreview{URL: page, FetchedAt: time.Now().Add(-failedTTL / 2)},
+			link:   page,
+		},
+		{
+			name:    "old failure is retried",
+			cached:  &store.LinkPreview{URL: page, FetchedAt: time.Now().Add(-failedTTL - time.Minute)},
+			link:    page,
+			fetched: true,
+			title:   "Fresh",
+			ok:      true,
+		},
+		{
+			name:    "failures are cached",
+			link:    dead,
+			fetched: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previews := &fakePreviewStore{previews: make(map[string]*store.LinkPreview)}
+			if tt.cached != nil {
+				previews.previews[tt.cached.URL] = tt.cached
+			}
+			worker := &Worker{previewStore: previews, fetcher: newFetcher(allowOnly(server))}
+
+			fetches.Store(0)
+			preview, err := worker.preview(context.Background(), tt.link)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fetched := fetches.Load() > 0; fetched != tt.fetched {
+				t.Fatalf("fetched %v, want %v", fetched, tt.fetched)
+			}
+			if preview.Title != tt.title || preview.OK != tt.ok {
+				t.Fatalf("preview %q ok=%v, want %q ok=%v", preview.Title, preview.OK, tt.title, tt.ok)
+			}
+			if saved := previews.previews[tt.link]; saved == nil || saved.OK != tt.ok {
+				t.Fatalf("cache holds %+v", saved)
+			}
+		})
+	}
+}
@@ -4,13 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"html"
 	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/filter"
 	"github.com/kaczmarekdaniel/gochat/internal/store"
 )
 
@@ -25,7 +26,7 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	maxMessageSize = 4096
 )
 
 var (
@@ -40,20 +41,51 @@ var upgrader = websocket.Upgrader{
 
 }
 
+// frame is what clients send over the socket. Chat frames are plain
+// messages; commands that point at an existing message also carry its ID.
+type frame struct {
+	store.Message
+	MessageID string `json:"message_id,omitempty"`
+	Emoji     string `json:"emoji,omitempty"`
+
+	// Files uploaded beforehand that a chat message should carry
+	AttachmentIDs []string `json:"attachment_ids,omitempty"`
+
+	// Seconds until a chat message destroys itself
+	TTL int `json:"ttl,omitempty"`
+
+	// The other people in a direct conversation being opened, and for
+	// groups an optional name
+	Participants []string `json:"participants,omitempty"`
+	Name         string   `json:"name,omitempty"`
+
+	// Paging for commands that return history
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
 type Client struct {
 	hub    *Hub
 	conn   *websocket.Conn
 	send   chan *store.Message
 	userID string // User's identifier
-} // validateMessage checks if a message is valid
 
+	// Every frame the connection sends takes a token. Only the read pump
+	// touches these.
+	frames    *bucket
+	throttled bool // Told about the frame limit and not yet back under it
+}
+
+// validateMessage checks if a message is valid. A message that carries
+// attachments may have no text.
 func validateMessage(message *store.Message) (bool, string) {
 	// Check required fields
 	if message.Type == "" {
 		return false, "message type is required"
 	}
 
-	if message.Content == "" {
+	if message.Content == "" && len(message.Attachments) == 0 {
 		return false, "message content is required"
 	}
 
@@ -80,22 +112,19 @@ func validateMessage(message *store.Message) (bool, string) {
 		return false, "sender name exceeds maximum length of 50 characters"
 	}
 
-	// Add other validations as needed:
-	// - Check for profanity in content
-	// - Validate message format for specific types
-	// - Rate limiting (number of messages per minute)
-
 	return true, ""
 }
 
+// sanitizeMessage normalizes a chat message before it is stored. Content is
+// not HTML-escaped: it is kept exactly as written and clients either show it
+// as text or use the HTML the server renders for markdown messages.
 func sanitizeMessage(message *store.Message) {
-	message.Content = html.EscapeString(message.Content)
-
 	// Trim whitespace
 	message.Content = strings.TrimSpace(message.Content)
-
-	message.Sender = html.EscapeString(message.Sender)
 	message.Sender = strings.TrimSpace(message.Sender)
+
+	// Only the server renders HTML
+	message.HTML = ""
 }
 
 // readPump pumps messages from the websocket connection to the hub
@@ -122,16 +151,32 @@ func (c *Client) readPump() {
 			break
 		}
 
-		var message store.Message
-		if err := json.Unmarshal(rawMessage, &message); err != nil {
+		// Frames over the limit are dropped before any work is done on
+		// them. The client hears about it once, not for every frame.
+		if ok, wait := c.frames.take(time.Now()); !ok {
+			if !c.throttled {
+				c.throttled = true
+				c.send <- events.RateLimited("", limitFrames, wait)
+			}
+			continue
+		}
+		c.throttled = false
+
+		var in frame
+		if err := json.Unmarshal(rawMessage, &in); err != nil {
 			log.Printf("Error parsing message: %v", err)
 			continue
 		}
+		message := in.Message
 
 		// Process message based on type
 		ctx := context.Background()
 		switch message.Type {
 		case "join_room":
+			if !c.rateLimit(c.hub.membershipLimits, message.Room, limitMembership) {
+				continue
+			}
+
 			// Add user to room in database
 			fmt.Println(c.userID, message.Room, "tried to connect to a room")
 			if err := c.hub.roomStore.JoinRoom(ctx, c.userID, message.Room); err != nil {
@@ -155,6 +200,10 @@ func (c *Client) readPump() {
 			}
 
 		case "leave_room":
+			if !c.rateLimit(c.hub.membershipLimits, message.Room, limitMembership) {
+				continue
+			}
+
 			// Remove user from room in database
 			if err := c.hub.roomStore.LeaveRoom(ctx, c.userID, message.Room); err != nil {
 				c.send <- &store.Message{
@@ -176,23 +225,86 @@ func (c *Client) readPump() {
 			}
 
 		case "chat":
-			// Check if user is in this room
-			isInRoom, err := c.hub.roomStore.IsUserInRoom(ctx, c.userID, message.Room)
-			fmt.Println(err, "user:", c.userID, "message:", message)
-			if err != nil || !isInRoom {
-				c.send <- &store.Message{
-					Type:    "error",
-					Content: "You are not a member of this room",
-					Sender:  "system",
-					Room:    message.Room,
-					Time:    time.Now(),
-				}
+			// Check if user may post in this room
+			if !c.authorize(ctx, message.Room, store.PermPost, "You are not allowed to post in this room") {
+				continue
+			}
+			if !c.rateLimit(c.hub.chatLimits, message.Room, limitChat) {
+				continue
+			}
+
+			sanitizeMessage(&message)
+			if message.Format != "" && message.Format != store.FormatPlain && message.Format != store.FormatMarkdown {
+				c.sendError(message.Room, fmt.Sprintf("unsupported message format: %s", message.Format))
 				continue
 			}
 
-			// Set sender ID and broadcast message
+			if !c.setExpiry(in, &message) {
+				continue
+			}
+			if !c.checkReferences(ctx, &message) {
+				continue
+			}
+			if !c.loadAttachments(ctx, in.AttachmentIDs, &message) {
+				continue
+			}
+
+			// The filters look at who is talking, so the sender is set first.
+			// The time is the server's: it picks the partition the message
+			// goes to and where it sorts in history.
 			message.Sender = c.userID
+			message.Time = time.Now()
+			if ok, reason := validateMessage(&message); !ok {
+				c.sendError(message.Room, reason)
+				continue
+			}
+
+			verdict := c.hub.filters.Run(ctx, &message)
+			if verdict.Action == filter.Reject {
+				c.send <- events.MessageRejected(message.Room, verdict.Filter, verdict.Reason)
+				continue
+			}
+
+			// Last, so a message that was turned down doesn't start the wait
+			if !c.takeSlowModeTurn(ctx, message.Room) {
+				continue
+			}
+
+			c.hub.filters.Accepted(&message, verdict)
+			if verdict.Action == filter.Hold {
+				c.holdMessage(ctx, &message, verdict)
+				continue
+			}
+
+			c.hub.resolveMentions(ctx, &message)
 			c.hub.broadcast <- &message
+
+		case "open_dm":
+			c.openDirectRoom(ctx, in)
+
+		case "mark_read":
+			c.markRead(ctx, in)
+
+		case "edit_message":
+			c.editMessage(ctx, in)
+
+		case "delete_message":
+			c.deleteMessage(ctx, in)
+
+		case "open_thread":
+			c.openThread(ctx, in)
+
+		case "pin_message":
+			c.pin(ctx, in, true)
+
+		case "unpin_message":
+			c.pin(ctx, in, false)
+
+		case "react":
+			c.react(ctx, in, true)
+
+		case "unreact":
+			c.react(ctx, in, false)
 		}
 	}
 }
@@ -277,6 +389,7 @@ func createClient(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		conn:   conn,
 		send:   make(chan *store.Message, 256),
 		userID: userID,
+		frames: newBucket(frameBurst, frameRate),
 	}
 
 	client.hub.register <- client
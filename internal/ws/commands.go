@@ -0,0 +1,449 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/filter"
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// sendError reports a failed command back to the client that issued it
+func (c *Client) sendError(room, content string) {
+	c.send <- &store.Message{
+		Type:    "error",
+		Content: content,
+		Sender:  "system",
+		Room:    room,
+		Time:    time.Now(),
+	}
+}
+
+// authorize reports whether the client's role in a room grants a
+// permission, telling the client why not if it doesn't. denied is what
+// members without it are told.
+func (c *Client) authorize(ctx context.Context, roomID string, permission store.Permission, denied string) bool {
+	err := c.hub.roomStore.Authorize(ctx, c.userID, roomID, permission)
+	if errors.Is(err, store.ErrNotRoomMember) {
+		c.sendError(roomID, "You are not a member of this room")
+		return false
+	}
+	if errors.Is(err, store.ErrForbidden) {
+		c.sendError(roomID, denied)
+		return false
+	}
+	if errors.Is(err, store.ErrMuted) {
+		c.sendError(roomID, "You are muted in this room")
+		return false
+	}
+	if errors.Is(err, store.ErrRoomArchived) {
+		c.sendError(roomID, "This room is archived")
+		return false
+	}
+	if err != nil {
+		c.sendError(roomID, fmt.Sprintf("Failed to check permissions: %v", err))
+		return false
+	}
+	return true
+}
+
+// rateLimit takes one of the user's tokens from l, telling the client how
+// long to wait if there are none left
+func (c *Client) rateLimit(l *limiter, roomID, reason string) bool {
+	ok, wait := l.take(c.userID)
+	if !ok {
+		c.send <- events.RateLimited(roomID, reason, wait)
+	}
+	return ok
+}
+
+// takeSlowModeTurn holds the client back while the room's slow mode says
+// they posted too recently
+func (c *Client) takeSlowModeTurn(ctx context.Context, roomID string) bool {
+	wait, err := c.hub.roomStore.TakeSlowModeTurn(ctx, c.userID, roomID)
+	if err != nil {
+		c.sendError(roomID, fmt.Sprintf("Failed to check slow mode: %v", err))
+		return false
+	}
+	if wait > 0 {
+		c.send <- events.RateLimited(roomID, limitSlowMode, wait)
+		return false
+	}
+	return true
+}
+
+// holdMessage puts a message in its room's review queue and tells the sender
+// and the room's reviewers about it
+func (c *Client) holdMessage(ctx context.Context, message *store.Message, verdict filter.Verdict) {
+	held, err := c.hub.filterStore.HoldMessage(ctx, &store.HeldMessage{
+		RoomID:  message.Room,
+		Sender:  message.Sender,
+		Message: message,
+		Filter:  verdict.Filter,
+		Reason:  verdict.Reason,
+	})
+	if err != nil {
+		c.sendError(message.Room, fmt.Sprintf("Failed to hold message for review: %v", err))
+		return
+	}
+
+	c.send <- events.MessageHeld(held)
+
+	reviewers, err := c.hub.roomStore.GetMembersWith(ctx, message.Room, store.PermReview)
+	if err != nil {
+		log.Printf("Error getting reviewers of room %s: %v", message.Room, err)
+		return
+	}
+	for _, reviewer := range reviewers {
+		if reviewer != c.userID {
+			c.hub.events.PublishUser(reviewer, events.MessageHeld(held))
+		}
+	}
+}
+
+// markRead moves the client's read marker and tells the room about it
+func (c *Client) markRead(ctx context.Context, in frame) {
+	if in.MessageID == "" {
+		c.sendError(in.Room, "message_id is required")
+		return
+	}
+
+	state, advanced, err := c.hub.readStore.MarkRead(ctx, c.userID, in.Room, in.MessageID)
+	if errors.Is(err, store.ErrNotRoomMember) {
+		c.sendError(in.Room, "You are not a member of this room")
+		return
+	}
+	if err != nil {
+		c.sendError(in.Room, fmt.Sprintf("Failed to mark room as read: %v", err))
+		return
+	}
+
+	if advanced {
+		c.hub.events.PublishRoom(events.Read(state))
+	}
+}
+
+// loadModifiable fetches the message a command targets and checks that the
+// client may change it: senders can change their own messages while they
+// may still post in the room, and others need the given permission.
+func (c *Client) loadModifiable(ctx context.Context, in frame, othersPermission store.Permission) (*store.Message, bool) {
+	if in.MessageID == "" {
+		c.sendError(in.Room, "message_id is required")
+		return nil, false
+	}
+
+	message, err := c.hub.messageStore.GetMessage(ctx, in.MessageID)
+	if errors.Is(err, store.ErrMessageNotFound) || (err == nil && message.DeletedAt != nil) {
+		c.sendError(in.Room, "Message not found")
+		return nil, false
+	}
+	if err != nil {
+		c.sendError(in.Room, fmt.Sprintf("Failed to load message: %v", err))
+		return nil, false
+	}
+
+	if message.Sender == c.userID {
+		if !c.authorize(ctx, message.Room, store.PermPost, "You are not allowed to modify this message") {
+			return nil, false
+		}
+		return message, true
+	}
+
+	if !c.authorize(ctx, message.Room, othersPermission, "You are not allowed to modify this message") {
+		return nil, false
+	}
+	return message, true
+}
+
+// editMessage replaces the content of a message and pushes the new version
+func (c *Client) editMessage(ctx context.Context, in frame) {
+	content := strings.TrimSpace(in.Content)
+	if content == "" {
+		c.sendError(in.Room, "message content is required")
+		return
+	}
+	if len(content) > 1000 {
+		c.sendError(in.Room, "message content exceeds maximum length of 1000 characters")
+		return
+	}
+
+	message, ok := c.loadModifiable(ctx, in, store.PermEditOthers)
+	if !ok {
+		return
+	}
+
+	// Edits go through the filters too, or they would be a way around them.
+	// Edits have no review queue, so one that would be held is turned down.
+	edited := *message
+	edited.Content = content
+	verdict := c.hub.filters.Run(ctx, &edited)
+	if verdict.Action >= filter.Hold {
+		c.send <- events.MessageRejected(message.Room, verdict.Filter, verdict.Reason)
+		return
+	}
+	content = edited.Content
+
+	updated, err := c.hub.messageStore.EditMessage(ctx, message.ID, c.userID, content)
+	if err != nil {
+		c.sendError(message.Room, fmt.Sprintf("Failed to edit message: %v", err))
+		return
+	}
+
+	c.hub.events.PublishRoom(events.MessageUpdated(updated))
+}
+
+// deleteMessage soft-deletes a message and tells the room to drop it
+func (c *Client) deleteMessage(ctx context.Context, in frame) {
+	message, ok := c.loadModifiable(ctx, in, store.PermDeleteOthers)
+	if !ok {
+		return
+	}
+
+	deleted, err := c.hub.messageStore.DeleteMessage(ctx, message.ID, c.userID)
+	if errors.Is(err, store.ErrMessageNotFound) {
+		// Someone else deleted it first and already announced it
+		return
+	}
+	if err != nil {
+		c.sendError(message.Room, fmt.Sprintf("Failed to delete message: %v", err))
+		return
+	}
+
+	c.hub.events.PublishRoom(events.MessageDeleted(deleted))
+}
+
+// checkReferences makes sure the thread a chat message replies to, or the
+// message it quotes, is a live message of the same room. The store enforces
+// the same rules; checking here lets the sender see why a message was refused.
+func (c *Client) checkReferences(ctx context.Context, message *store.Message) bool {
+	if message.ParentID != "" {
+		parent, err := c.hub.messageStore.GetMessage(ctx, message.ParentID)
+		if err != nil || parent.Room != message.Room || parent.DeletedAt != nil {
+			c.sendError(message.Room, "Thread not found")
+			return false
+		}
+		if parent.ParentID != "" {
+			c.sendError(message.Room, "Replies cannot start their own thread")
+			return false
+		}
+	}
+
+	if message.QuoteID != "" {
+		quoted, err := c.hub.messageStore.GetMessage(ctx, message.QuoteID)
+		if err != nil || quoted.Room != message.Room || quoted.DeletedAt != nil {
+			c.sendError(message.Room, "Quoted message not found")
+			return false
+		}
+	}
+
+	return true
+}
+
+// setExpiry turns the TTL of a chat frame into the message's expiry time.
+// Without one, the room's default applies when the message is stored.
+func (c *Client) setExpiry(in frame, message *store.Message) bool {
+	message.ExpiresAt = nil
+	if in.TTL == 0 {
+		return true
+	}
+
+	// Checked in seconds, before a huge TTL can overflow the duration
+	if in.TTL < 0 || in.TTL > int(store.MaxMessageTTL/time.Second) {
+		c.sendError(message.Room, "invalid message ttl")
+		return false
+	}
+
+	expiresAt := time.Now().Add(time.Duration(in.TTL) * time.Second)
+	message.ExpiresAt = &expiresAt
+	return true
+}
+
+// Most files a single message may carry
+const maxAttachments = 10
+
+// loadAttachments resolves the attachment IDs of a chat frame. Only files the
+// sender uploaded to the same room, and not yet sent with another message,
+// can be attached.
+func (c *Client) loadAttachments(ctx context.Context, ids []string, message *store.Message) bool {
+	message.Attachments = nil
+	if len(ids) > maxAttachments {
+		c.sendError(message.Room, fmt.Sprintf("A message can carry at most %d attachments", maxAttachments))
+		return false
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		attachment, err := c.hub.attachmentStore.GetAttachment(ctx, id)
+		if err != nil || attachment.RoomID != message.Room || attachment.UploaderID != c.userID || attachment.MessageID != "" {
+			c.sendError(message.Room, "Attachment not found")
+			return false
+		}
+		message.Attachments = append(message.Attachments, attachment)
+	}
+
+	return true
+}
+
+// openThread sends the client the root of a thread with a page of replies
+func (c *Client) openThread(ctx context.Context, in frame) {
+	if in.MessageID == "" {
+		c.sendError(in.Room, "message_id is required")
+		return
+	}
+
+	parent, err := c.hub.messageStore.GetMessage(ctx, in.MessageID)
+	if err != nil {
+		c.sendError(in.Room, "Thread not found")
+		return
+	}
+
+	isInRoom, err := c.hub.roomStore.IsUserInRoom(ctx, c.userID, parent.Room)
+	if err != nil || !isInRoom {
+		c.sendError(parent.Room, "You are not a member of this room")
+		return
+	}
+
+	replies, err := c.hub.messageStore.GetThreadPage(ctx, parent.ID, store.MessagePageQuery{
+		Before: in.Before,
+		After:  in.After,
+		Limit:  in.Limit,
+	})
+	if err != nil {
+		c.sendError(parent.Room, fmt.Sprintf("Failed to load thread: %v", err))
+		return
+	}
+
+	c.send <- events.Thread(parent, replies)
+}
+
+// Emoji are stored as short names (":tada:") or the characters themselves
+const maxEmojiLength = 64
+
+// react adds or removes one of the client's reactions on a message in a room
+// they may post in
+func (c *Client) react(ctx context.Context, in frame, add bool) {
+	if in.MessageID == "" {
+		c.sendError(in.Room, "message_id is required")
+		return
+	}
+
+	emoji := strings.TrimSpace(in.Emoji)
+	if emoji == "" || len(emoji) > maxEmojiLength || strings.ContainsAny(emoji, " \t\n") {
+		c.sendError(in.Room, "invalid emoji")
+		return
+	}
+
+	message, err := c.hub.messageStore.GetMessage(ctx, in.MessageID)
+	if err != nil || message.DeletedAt != nil {
+		c.sendError(in.Room, "Message not found")
+		return
+	}
+
+	if !c.authorize(ctx, message.Room, store.PermPost, "You are not allowed to react in this room") {
+		return
+	}
+
+	if add {
+		added, err := c.hub.reactionStore.AddReaction(ctx, message.ID, c.userID, emoji)
+		if err != nil {
+			c.sendError(message.Room, fmt.Sprintf("Failed to add reaction: %v", err))
+			return
+		}
+		if added {
+			c.hub.events.PublishRoom(events.ReactionAdded(message, c.userID, emoji))
+		}
+		return
+	}
+
+	removed, err := c.hub.reactionStore.RemoveReaction(ctx, message.ID, c.userID, emoji)
+	if err != nil {
+		c.sendError(message.Room, fmt.Sprintf("Failed to remove reaction: %v", err))
+		return
+	}
+	if removed {
+		c.hub.events.PublishRoom(events.ReactionRemoved(message, c.userID, emoji))
+	}
+}
+
+// pin pins a message to the top of its room, or takes the pin down. Only
+// moderators manage pins. A pin can be taken down after its message is gone.
+func (c *Client) pin(ctx context.Context, in frame, add bool) {
+	if in.MessageID == "" {
+		c.sendError(in.Room, "message_id is required")
+		return
+	}
+
+	message, err := c.hub.messageStore.GetMessage(ctx, in.MessageID)
+	if err != nil || (add && message.DeletedAt != nil) {
+		c.sendError(in.Room, "Message not found")
+		return
+	}
+
+	if !c.authorize(ctx, message.Room, store.PermPin, "Only moderators can pin messages") {
+		return
+	}
+
+	if add {
+		pin, added, err := c.hub.pinStore.PinMessage(ctx, message.ID, c.userID)
+		if errors.Is(err, store.ErrPinLimitReached) {
+			c.sendError(message.Room, fmt.Sprintf("A room can have at most %d pinned messages", store.MaxPinnedMessages))
+			return
+		}
+		if err != nil {
+			c.sendError(message.Room, fmt.Sprintf("Failed to pin message: %v", err))
+			return
+		}
+		if added {
+			c.hub.events.PublishRoom(events.MessagePinned(pin))
+		}
+		return
+	}
+
+	removed, err := c.hub.pinStore.UnpinMessage(ctx, message.ID)
+	if err != nil {
+		c.sendError(message.Room, fmt.Sprintf("Failed to unpin message: %v", err))
+		return
+	}
+	if removed {
+		c.hub.events.PublishRoom(events.MessageUnpinned(message, c.userID))
+	}
+}
+
+// openDirectRoom finds or starts a conversation with the given participants.
+// The client gets the room back; on first use everyone else is told it now
+// exists, so it shows up without reconnecting.
+func (c *Client) openDirectRoom(ctx context.Context, in frame) {
+	if len(in.Name) > 255 {
+		c.sendError("", "name exceeds maximum length of 255 characters")
+		return
+	}
+
+	room, created, err := c.hub.roomStore.OpenDirectRoom(ctx, c.userID, in.Participants, in.Name)
+	if errors.Is(err, store.ErrTooFewParticipants) || errors.Is(err, store.ErrTooManyParticipants) {
+		c.sendError("", err.Error())
+		return
+	}
+	if errors.Is(err, store.ErrRoomArchived) {
+		c.sendError("", "This conversation is archived")
+		return
+	}
+	if err != nil {
+		c.sendError("", fmt.Sprintf("Failed to open conversation: %v", err))
+		return
+	}
+
+	if created {
+		events.AnnounceRoomOpened(c.hub.events, room, c.userID)
+	}
+	c.send <- events.RoomOpened(room)
+}
@@ -7,31 +7,63 @@ import (
 	"log"
 	"time"
 
+	"github.com/kaczmarekdaniel/gochat/internal/events"
+	"github.com/kaczmarekdaniel/gochat/internal/filter"
 	"github.com/kaczmarekdaniel/gochat/internal/store"
+	"github.com/kaczmarekdaniel/gochat/internal/unfurl"
 )
 
 type Hub struct {
-	clients      map[*Client]bool
-	broadcast    chan *store.Message
-	register     chan *Client
-	unregister   chan *Client
-	roomStore    store.RoomStore    // Add this
-	messageStore store.MessageStore // Add this
+	clients         map[*Client]bool
+	broadcast       chan *store.Message
+	register        chan *Client
+	unregister      chan *Client
+	roomStore       store.RoomStore    // Add this
+	messageStore    store.MessageStore // Add this
+	readStore       store.ReadStore
+	reactionStore   store.ReactionStore
+	mentionStore    store.MentionStore
+	attachmentStore store.AttachmentStore
+	pinStore        store.PinStore
+	filterStore     store.FilterStore
+	filters         *filter.Pipeline
+	events          *events.Bus
+	unfurler        *unfurl.Worker
+
+	// Per user, so opening more connections doesn't buy more messages
+	chatLimits       *limiter
+	membershipLimits *limiter
 }
 
+// Number of messages per room sent on register; clients fetch older ones
+// through the history API using the room's cursor
+const initialMessages = 50
+
 type RoomAction struct {
 	client *Client
 	room   string
 }
 
-func newHub(roomStore store.RoomStore, messageStore store.MessageStore) *Hub {
+func newHub(roomStore store.RoomStore, messageStore store.MessageStore, readStore store.ReadStore, reactionStore store.ReactionStore, mentionStore store.MentionStore, attachmentStore store.AttachmentStore, pinStore store.PinStore, filterStore store.FilterStore, filters *filter.Pipeline, bus *events.Bus, unfurler *unfurl.Worker) *Hub {
 	return &Hub{
-		broadcast:    make(chan *store.Message),
-		register:     make(chan *Client),
-		unregister:   make(chan *Client),
-		clients:      make(map[*Client]bool),
-		roomStore:    roomStore,
-		messageStore: messageStore,
+		broadcast:       make(chan *store.Message),
+		register:        make(chan *Client),
+		unregister:      make(chan *Client),
+		clients:         make(map[*Client]bool),
+		roomStore:       roomStore,
+		messageStore:    messageStore,
+		readStore:       readStore,
+		reactionStore:   reactionStore,
+		mentionStore:    mentionStore,
+		attachmentStore: attachmentStore,
+		pinStore:        pinStore,
+		filterStore:     filterStore,
+		filters:         filters,
+		events:          bus,
+		unfurler:        unfurler,
+
+		chatLimits:       newLimiter(chatBurst, chatRate),
+		membershipLimits: newLimiter(membershipBurst, membershipRate),
 	}
 }
 func (h *Hub) run() {
@@ -53,10 +85,21 @@ func (h *Hub) run() {
 					return
 				}
 
+				readStates, err := h.readStore.GetUserReadStates(context.Background(), client.userID)
+				if err != nil {
+					log.Printf("Error getting read states: %v", err)
+				}
+
 				// Create a new structure to hold rooms with their messages
 				type RoomWithMessages struct {
-					*store.Room                  // Embed the original Room
-					Messages    []*store.Message `json:"messages"`
+					*store.Room                        // Embed the original Room
+					Messages          []*store.Message `json:"messages"`
+					Pinned            []*store.Pin     `json:"pinned"`
+					Cursor            string           `json:"cursor,omitempty"`
+					UnreadCount       int64            `json:"unread_count"`
+					MentionCount      int64            `json:"mention_count"`
+					LastReadMessageID string           `json:"last_read_message_id,omitempty"`
+					Muted             bool             `json:"muted"`
 				}
 
 				roomsWithMessages := make([]RoomWithMessages, 0, len(rooms))
@@ -64,16 +107,31 @@ func (h *Hub) run() {
 				// Attach messages to each room
 				for _, room := range rooms {
 					// Get messages for this specific room
-					roomMessages, err := h.messageStore.GetMessages(room.ID) // Assuming room has an ID field
+					page, err := h.messageStore.GetMessagePage(context.Background(), room.ID, store.MessagePageQuery{Limit: initialMessages})
 					if err != nil {
 						log.Printf("Error retrieving messages from room %s: %v", room.ID, err)
 						// Continue with other rooms even if we fail to get messages for this one
+						page = &store.MessagePage{}
+					}
+
+					pins, err := h.pinStore.GetPinnedMessages(context.Background(), room.ID)
+					if err != nil {
+						log.Printf("Error retrieving pinned messages from room %s: %v", room.ID, err)
+						pins = []*store.Pin{}
 					}
 
 					// Create a room with messages
 					roomWithMessages := RoomWithMessages{
 						Room:     room,
-						Messages: roomMessages,
+						Messages: page.Messages,
+						Pinned:   pins,
+						Cursor:   page.Before,
+					}
+					if state, ok := readStates[room.ID]; ok {
+						roomWithMessages.UnreadCount = state.UnreadCount
+						roomWithMessages.MentionCount = state.MentionCount
+						roomWithMessages.LastReadMessageID = state.LastReadMessageID
+						roomWithMessages.Muted = state.Muted
 					}
 
 					roomsWithMessages = append(roomsWithMessages, roomWithMessages)
@@ -95,18 +153,43 @@ func (h *Hub) run() {
 			}
 
 		case message := <-h.broadcast:
-			// Save message to database
-			if _, err := h.messageStore.CreateMessage(message); err != nil {
-				log.Printf("Error saving message: %v", err)
-				continue
-			}
+			h.handleMessage(message)
 
-			// Find clients in the room and send them the message
-			h.distributeMessage(message)
+		case message := <-h.events.Messages():
+			// Sent on someone's behalf by a background job rather than
+			// typed into a connection, so mentions are still unresolved
+			h.resolveMentions(context.Background(), message)
+			h.handleMessage(message)
+
+		case event := <-h.events.Room():
+			h.distributeMessage(event)
+
+		case event := <-h.events.User():
+			h.sendToUser(event.UserID, event.Message)
 		}
 	}
 }
 
+// handleMessage stores a new chat message and delivers it
+func (h *Hub) handleMessage(message *store.Message) {
+	// Save message to database
+	if _, err := h.messageStore.CreateMessage(message); err != nil {
+		log.Printf("Error saving message: %v", err)
+		return
+	}
+
+	h.updateReadState(message)
+
+	// Find clients in the room and send them the message
+	h.distributeMessage(message)
+	h.deliverMentions(message)
+	h.unfurler.Enqueue(message)
+
+	if message.ParentID != "" {
+		h.notifyThread(message)
+	}
+}
+
 // Distribute message to clients in the room
 func (h *Hub) distributeMessage(message *store.Message) {
 	ctx := context.Background()
@@ -136,3 +219,109 @@ func (h *Hub) distributeMessage(message *store.Message) {
 		}
 	}
 }
+
+// updateReadState moves the sender's read marker past their own message
+func (h *Hub) updateReadState(message *store.Message) {
+	ctx := context.Background()
+
+	// Thread replies are outside the room sequence that read markers follow
+	if message.ParentID == "" {
+		if _, _, err := h.readStore.MarkRead(ctx, message.Sender, message.Room, message.ID); err != nil {
+			log.Printf("Error advancing sender read marker: %v", err)
+		}
+	}
+}
+
+// deliverMentions stores the mentions of a message and sends every mentioned
+// user a mention event. Mentions cut through muted rooms on purpose.
+func (h *Hub) deliverMentions(message *store.Message) {
+	if message.MentionHere {
+		roomUsers, err := h.roomStore.GetRoomUsers(context.Background(), message.Room)
+		if err != nil {
+			log.Printf("Error resolving @here: %v", err)
+		}
+
+		roomUserMap := make(map[string]bool)
+		for _, userID := range roomUsers {
+			roomUserMap[userID] = true
+		}
+
+		if message.Mentions == nil {
+			message.Mentions = make(map[string]string)
+		}
+		for client := range h.clients {
+			_, already := message.Mentions[client.userID]
+			if roomUserMap[client.userID] && client.userID != message.Sender && !already {
+				message.Mentions[client.userID] = store.MentionHere
+			}
+		}
+	}
+
+	if len(message.Mentions) == 0 {
+		return
+	}
+
+	mentions, err := h.mentionStore.CreateMentions(context.Background(), message, message.Mentions)
+	if err != nil {
+		log.Printf("Error saving mentions: %v", err)
+		return
+	}
+
+	for _, mention := range mentions {
+		h.sendToUser(mention.UserID, events.Mention(mention))
+	}
+}
+
+// notifyThread tells everyone taking part in a thread, apart from the author
+// of the reply, that there is something new in it
+func (h *Hub) notifyThread(reply *store.Message) {
+	ctx := context.Background()
+
+	participants, err := h.messageStore.GetThreadParticipants(ctx, reply.ParentID)
+	if err != nil {
+		log.Printf("Error getting thread participants: %v", err)
+		return
+	}
+
+	// People who have since left the room are no longer told about it
+	roomUsers, err := h.roomStore.GetRoomUsers(ctx, reply.Room)
+	if err != nil {
+		log.Printf("Error getting room users: %v", err)
+		return
+	}
+
+	mutedUsers, err := h.roomStore.GetMutedRoomUsers(ctx, reply.Room)
+	if err != nil {
+		log.Printf("Error getting muted users: %v", err)
+	}
+
+	roomUserMap := make(map[string]bool)
+	for _, userID := range roomUsers {
+		roomUserMap[userID] = true
+	}
+	for _, userID := range mutedUsers {
+		delete(roomUserMap, userID)
+	}
+
+	// Mentioned participants already got a mention event for this reply
+	event := events.ThreadReply(reply)
+	for _, userID := range participants {
+		if userID != reply.Sender && roomUserMap[userID] && reply.Mentions[userID] == "" {
+			h.sendToUser(userID, event)
+		}
+	}
+}
+
+// Send a message to every connection of a single user
+func (h *Hub) sendToUser(userID string, message *store.Message) {
+	for client := range h.clients {
+		if client.userID == userID {
+			select {
+			case client.send <- message:
+			default:
+				close(client.send)
+				delete(h.clients, client)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/kaczmarekdaniel/gochat/internal/store"
+)
+
+// Upper bound on distinct @names looked up per message
+const maxMentions = 50
+
+// An @ only starts a mention at the start of the text or after a character
+// that can't be part of a word, so e-mail addresses don't mention anyone
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]+)`)
+
+// parseMentions extracts the lowercased usernames mentioned in content and
+// whether @room or @here was used
+func parseMentions(content string) (usernames []string, room, here bool) {
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		switch {
+		case name == "":
+		case name == "room":
+			room = true
+		case name == "here":
+			here = true
+		case !seen[name] && len(usernames) < maxMentions:
+			seen[name] = true
+			usernames = append(usernames, name)
+		}
+	}
+
+	return usernames, room, here
+}
+
+// resolveMentions turns the @names in a chat message into the room members
+// they refer to. @here depends on who is connected, which only the hub
+// goroutine knows, so it is flagged for deliverMentions to expand.
+func (h *Hub) resolveMentions(ctx context.Context, message *store.Message) {
+	usernames, room, here := parseMentions(message.Content)
+	message.MentionHere = here
+	if len(usernames) == 0 && !room {
+		return
+	}
+
+	mentioned := make(map[string]string)
+	if room {
+		members, err := h.roomStore.GetRoomUsers(ctx, message.Room)
+		if err != nil {
+			log.Printf("Error resolving @room: %v", err)
+		}
+		for _, userID := range members {
+			mentioned[userID] = store.MentionRoom
+		}
+	}
+
+	resolved, err := h.mentionStore.ResolveUsernames(ctx, message.Room, usernames)
+	if err != nil {
+		log.Printf("Error resolving mentions: %v", err)
+	}
+	for _, userID := range resolved {
+		mentioned[userID] = store.MentionUser
+	}
+
+	delete(mentioned, message.Sender)
+	message.Mentions = mentioned
+}
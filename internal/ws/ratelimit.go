@@ -0,0 +1,114 @@
+package ws
+
+import (
+	"sync"
+	"time"
+)
+
+// Rates are in tokens per second; each action takes one token
+const (
+	// Every frame a connection sends, whatever it is
+	frameBurst = 30
+	frameRate  = 10
+
+	// Chat messages from one user, across all their connections
+	chatBurst = 5
+	chatRate  = 1
+
+	// Joining and leaving rooms, per user
+	membershipBurst = 5
+	membershipRate  = 0.2
+
+	// How often idle buckets are dropped from a limiter
+	limiterPruneInterval = 10 * time.Minute
+)
+
+// Reasons given in rate_limited frames
+const (
+	limitFrames     = "frames"
+	limitChat       = "chat"
+	limitMembership = "membership"
+	limitSlowMode   = "slow_mode"
+)
+
+// bucket is a token bucket: it holds up to burst tokens and refills at rate
+// tokens per second
+type bucket struct {
+	burst  float64
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(burst, rate float64) *bucket {
+	return &bucket{burst: burst, rate: rate, tokens: burst, last: time.Now()}
+}
+
+// refill adds the tokens earned since the last call
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// take uses up a token if there is one. Otherwise it returns how long until
+// there will be.
+func (b *bucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// limiter keeps a bucket per key, such as a user ID, and is safe for
+// concurrent use
+type limiter struct {
+	burst, rate float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+func newLimiter(burst, rate float64) *limiter {
+	return &limiter{
+		burst:   burst,
+		rate:    rate,
+		buckets: make(map[string]*bucket),
+		pruned:  time.Now(),
+	}
+}
+
+// take uses up one of key's tokens, or says how long to wait for one
+func (l *limiter) take(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.pruned) > limiterPruneInterval {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucket(l.burst, l.rate)
+		l.buckets[key] = b
+	}
+	return b.take(now)
+}
+
+// prune drops the buckets that have filled up again; a full bucket is the
+// same as none
+func (l *limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= b.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.pruned = now
+}
@@ -7,10 +7,18 @@ import (
 	"github.com/kaczmarekdaniel/gochat/internal/routes"
 )
 
+// Goroutines fetching link previews at the same time
+const unfurlWorkers = 4
+
 func Start(app *app.Application) {
-	hub := newHub(app.RoomStore, app.MessageStore)
+	hub := newHub(app.RoomStore, app.MessageStore, app.ReadStore, app.ReactionStore, app.MentionStore, app.AttachmentStore, app.PinStore, app.FilterStore, app.Filters, app.Events, app.Unfurler)
 
 	go hub.run()
+	app.Unfurler.Start(unfurlWorkers)
+	app.Scheduler.Start()
+	app.Purger.Start()
+	app.Retention.Start()
+	app.Partitions.Start()
 
 	routes.SetupRoutes(app)
 
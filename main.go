@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/kaczmarekdaniel/gochat/internal/app"
@@ -12,6 +13,18 @@ import (
 func main() {
 
 	app, err := app.NewApplication()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 {
+		if err := runCommand(app, os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	server := &http.Server{
 		Addr:           ":8080",
@@ -0,0 +1,52 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Per-room message sequence numbers make unread counts a subtraction
+-- instead of a scan over the room's history.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+WITH numbered AS (
+  SELECT id, row_number() OVER (PARTITION BY room ORDER BY time, id) AS seq
+  FROM messages
+)
+UPDATE messages m
+SET seq = numbered.seq
+FROM numbered
+WHERE m.id = numbered.id;
+
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_room_seq ON messages(room, seq);
+
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+
+UPDATE rooms r
+SET last_seq = s.max_seq
+FROM (SELECT room, MAX(seq) AS max_seq FROM messages GROUP BY room) s
+WHERE r.id::text = s.room;
+
+ALTER TABLE room_memberships
+  ADD COLUMN IF NOT EXISTS last_read_seq BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS last_read_message_id UUID,
+  ADD COLUMN IF NOT EXISTS last_read_at TIMESTAMP WITH TIME ZONE,
+  ADD COLUMN IF NOT EXISTS unread_mentions INTEGER NOT NULL DEFAULT 0;
+
+-- Existing members start with everything read
+UPDATE room_memberships rm
+SET last_read_seq = r.last_seq
+FROM rooms r
+WHERE r.id = rm.room_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE room_memberships
+  DROP COLUMN IF EXISTS last_read_seq,
+  DROP COLUMN IF EXISTS last_read_message_id,
+  DROP COLUMN IF EXISTS last_read_at,
+  DROP COLUMN IF EXISTS unread_mentions;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS last_seq;
+
+DROP INDEX IF EXISTS idx_messages_room_seq;
+ALTER TABLE messages DROP COLUMN IF EXISTS seq;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Supports keyset pagination over a room's history; id breaks ties between
+-- messages sent in the same instant.
+CREATE INDEX IF NOT EXISTS idx_messages_room_time ON messages(room, time, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_room_time;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE messages
+  ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP WITH TIME ZONE,
+  ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE,
+  ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255);
+
+-- Previous versions of edited messages, newest last
+CREATE TABLE IF NOT EXISTS message_edits (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  content TEXT,
+  edited_by VARCHAR(255) NOT NULL,
+  edited_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_message_edits_message_id ON message_edits(message_id);
+
+ALTER TABLE room_memberships
+  ADD COLUMN IF NOT EXISTS role VARCHAR(32) NOT NULL DEFAULT 'member'
+  CHECK (role IN ('member', 'moderator'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE room_memberships DROP COLUMN IF EXISTS role;
+
+DROP TABLE message_edits;
+
+ALTER TABLE messages
+  DROP COLUMN IF EXISTS edited_at,
+  DROP COLUMN IF EXISTS deleted_at,
+  DROP COLUMN IF EXISTS deleted_by;
+-- +goose StatementEnd
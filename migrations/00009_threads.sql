@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE messages
+  ADD COLUMN IF NOT EXISTS parent_id UUID REFERENCES messages(id) ON DELETE CASCADE,
+  ADD COLUMN IF NOT EXISTS quote_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+  ADD COLUMN IF NOT EXISTS reply_count INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS last_reply_at TIMESTAMP WITH TIME ZONE;
+
+-- Thread replies are paged the same way as the room timeline
+CREATE INDEX IF NOT EXISTS idx_messages_parent_time ON messages(parent_id, time, id)
+  WHERE parent_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_messages_parent_time;
+
+ALTER TABLE messages
+  DROP COLUMN IF EXISTS parent_id,
+  DROP COLUMN IF EXISTS quote_id,
+  DROP COLUMN IF EXISTS reply_count,
+  DROP COLUMN IF EXISTS last_reply_at;
+-- +goose StatementEnd
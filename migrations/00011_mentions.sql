@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS mentions (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  user_id VARCHAR(255) NOT NULL,
+  kind VARCHAR(16) NOT NULL CHECK (kind IN ('user', 'room', 'here')),
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (message_id, user_id)
+);
+
+-- Serves the "mentions of me" inbox, newest first
+CREATE INDEX idx_mentions_user_created ON mentions(user_id, created_at DESC, id DESC);
+
+-- Muted rooms stop sending notifications, except mentions
+ALTER TABLE room_memberships ADD COLUMN IF NOT EXISTS muted BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE room_memberships DROP COLUMN IF EXISTS muted;
+
+DROP TABLE mentions;
+-- +goose StatementEnd
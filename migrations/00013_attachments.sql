@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Attachments are uploaded to a room first and linked to a message when it
+-- is sent. The bytes live in the blob store under blob_key.
+CREATE TABLE IF NOT EXISTS attachments (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  uploader_id VARCHAR(255) NOT NULL,
+  message_id UUID REFERENCES messages(id) ON DELETE CASCADE,
+  filename VARCHAR(255) NOT NULL,
+  content_type VARCHAR(255) NOT NULL,
+  size BIGINT NOT NULL,
+  blob_key VARCHAR(512) NOT NULL,
+  thumbnail_key VARCHAR(512),
+  width INTEGER,
+  height INTEGER,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_attachments_message_id ON attachments(message_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE attachments;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Markdown messages keep the raw source in content and the sanitized HTML
+-- rendered from it alongside, so clients never parse markdown themselves.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS format VARCHAR(16) NOT NULL DEFAULT 'plain'
+  CHECK (format IN ('plain', 'markdown'));
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_html TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE messages DROP COLUMN IF EXISTS content_html;
+ALTER TABLE messages DROP COLUMN IF EXISTS format;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Unfurled pages are cached by URL and shared by every message linking to
+-- them. Pages that could not be unfurled are cached too (ok = false) so they
+-- are not fetched again on every mention.
+CREATE TABLE IF NOT EXISTS link_previews (
+  url TEXT PRIMARY KEY,
+  title TEXT,
+  description TEXT,
+  image_url TEXT,
+  site_name TEXT,
+  ok BOOLEAN NOT NULL,
+  fetched_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS message_link_previews (
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  url TEXT NOT NULL REFERENCES link_previews(url) ON DELETE CASCADE,
+  position INTEGER NOT NULL,
+  PRIMARY KEY (message_id, url)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE message_link_previews;
+DROP TABLE link_previews;
+-- +goose StatementEnd
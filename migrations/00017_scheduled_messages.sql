@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Work for the scheduler. Rows are deleted once delivered, so what is left
+-- is always pending and the poll only ever scans the due end of an index.
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id VARCHAR(255) NOT NULL,
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  content TEXT NOT NULL,
+  format VARCHAR(16) NOT NULL DEFAULT 'plain',
+  parent_id UUID REFERENCES messages(id) ON DELETE CASCADE,
+  send_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_scheduled_messages_send_at ON scheduled_messages(send_at);
+CREATE INDEX idx_scheduled_messages_user ON scheduled_messages(user_id, send_at);
+
+CREATE TABLE IF NOT EXISTS reminders (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id VARCHAR(255) NOT NULL,
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  remind_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_reminders_remind_at ON reminders(remind_at);
+CREATE INDEX idx_reminders_user ON reminders(user_id, remind_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE reminders;
+DROP TABLE scheduled_messages;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+
+-- Only ephemeral messages are indexed, which keeps the purge scan small
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages(expires_at)
+  WHERE expires_at IS NOT NULL;
+
+-- Default lifetime of new messages in a room, in seconds
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS message_ttl INTEGER
+  CHECK (message_ttl > 0);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE rooms DROP COLUMN IF EXISTS message_ttl;
+
+DROP INDEX IF EXISTS idx_messages_expires_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS expires_at;
+-- +goose StatementEnd
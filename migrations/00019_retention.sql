@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+-- How long a room keeps its history and what happens to older messages.
+-- Rooms without a policy fall back to the server-wide default.
+ALTER TABLE rooms
+  ADD COLUMN IF NOT EXISTS retention_days INTEGER CHECK (retention_days > 0),
+  ADD COLUMN IF NOT EXISTS retention_action VARCHAR(16)
+  CHECK (retention_action IN ('delete', 'archive'));
+
+-- Restored messages get a full retention period again from this time
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS restored_at TIMESTAMP WITH TIME ZONE;
+
+-- One row per archive file written to the blob store
+CREATE TABLE IF NOT EXISTS message_archives (
+  id UUID PRIMARY KEY,
+  room_id VARCHAR(255) NOT NULL,
+  blob_key VARCHAR(512) NOT NULL,
+  message_count INTEGER NOT NULL,
+  oldest TIMESTAMP WITH TIME ZONE NOT NULL,
+  newest TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  restored_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_message_archives_room ON message_archives(room_id, oldest);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE message_archives;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS restored_at;
+
+ALTER TABLE rooms
+  DROP COLUMN IF EXISTS retention_days,
+  DROP COLUMN IF EXISTS retention_action;
+-- +goose StatementEnd
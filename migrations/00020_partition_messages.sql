@@ -0,0 +1,192 @@
+-- +goose Up
+-- +goose StatementBegin
+-- messages becomes a table partitioned by month on time. The primary key of a
+-- partitioned table has to include the partition key, so id alone is no
+-- longer unique as far as Postgres knows and foreign keys cannot point at
+-- messages any more. The cascades they did are done by a trigger instead.
+ALTER TABLE message_edits DROP CONSTRAINT IF EXISTS message_edits_message_id_fkey;
+ALTER TABLE message_reactions DROP CONSTRAINT IF EXISTS message_reactions_message_id_fkey;
+ALTER TABLE mentions DROP CONSTRAINT IF EXISTS mentions_message_id_fkey;
+ALTER TABLE attachments DROP CONSTRAINT IF EXISTS attachments_message_id_fkey;
+ALTER TABLE message_link_previews DROP CONSTRAINT IF EXISTS message_link_previews_message_id_fkey;
+ALTER TABLE pinned_messages DROP CONSTRAINT IF EXISTS pinned_messages_message_id_fkey;
+ALTER TABLE bookmarks DROP CONSTRAINT IF EXISTS bookmarks_message_id_fkey;
+ALTER TABLE reminders DROP CONSTRAINT IF EXISTS reminders_message_id_fkey;
+ALTER TABLE scheduled_messages DROP CONSTRAINT IF EXISTS scheduled_messages_parent_id_fkey;
+
+CREATE TABLE messages_partitioned (
+  id UUID NOT NULL DEFAULT gen_random_uuid(),
+  type VARCHAR(255) NOT NULL,
+  room VARCHAR(255) NOT NULL,
+  content TEXT,
+  sender VARCHAR(255) NOT NULL,
+  time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  seq BIGINT NOT NULL,
+  edited_at TIMESTAMP WITH TIME ZONE,
+  deleted_at TIMESTAMP WITH TIME ZONE,
+  deleted_by VARCHAR(255),
+  parent_id UUID,
+  quote_id UUID,
+  reply_count INTEGER NOT NULL DEFAULT 0,
+  last_reply_at TIMESTAMP WITH TIME ZONE,
+  search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', coalesce(content, ''))) STORED,
+  has_attachments BOOLEAN NOT NULL DEFAULT FALSE,
+  format VARCHAR(16) NOT NULL DEFAULT 'plain' CHECK (format IN ('plain', 'markdown')),
+  content_html TEXT,
+  expires_at TIMESTAMP WITH TIME ZONE,
+  restored_at TIMESTAMP WITH TIME ZONE
+) PARTITION BY RANGE (time);
+
+-- Catches rows outside every monthly partition, such as restored messages
+-- from a month that has been detached. Normally empty.
+CREATE TABLE messages_default PARTITION OF messages_partitioned DEFAULT;
+
+-- Monthly partitions from the oldest message up to three months ahead; the
+-- partition maintenance job keeps creating them from there
+DO $$
+DECLARE
+  month TIMESTAMP := date_trunc('month',
+    COALESCE((SELECT MIN(time) FROM messages), CURRENT_TIMESTAMP) AT TIME ZONE 'UTC');
+BEGIN
+  WHILE month <= date_trunc('month', CURRENT_TIMESTAMP AT TIME ZONE 'UTC') + INTERVAL '3 months' LOOP
+    EXECUTE format(
+      'CREATE TABLE %I PARTITION OF messages_partitioned FOR VALUES FROM (%L) TO (%L)',
+      'messages_' || to_char(month, 'YYYY_MM'),
+      month AT TIME ZONE 'UTC',
+      (month + INTERVAL '1 month') AT TIME ZONE 'UTC'
+    );
+    month := month + INTERVAL '1 month';
+  END LOOP;
+END $$;
+
+INSERT INTO messages_partitioned (
+  id, type, room, content, sender, time, seq, edited_at, deleted_at, deleted_by,
+  parent_id, quote_id, reply_count, last_reply_at, has_attachments, format,
+  content_html, expires_at, restored_at
+)
+SELECT
+  id, type, room, content, sender, COALESCE(time, 'epoch'), seq, edited_at, deleted_at, deleted_by,
+  parent_id, quote_id, reply_count, last_reply_at, has_attachments, format,
+  content_html, expires_at, restored_at
+FROM messages;
+
+DROP TABLE messages;
+ALTER TABLE messages_partitioned RENAME TO messages;
+
+ALTER TABLE messages ADD CONSTRAINT messages_pkey PRIMARY KEY (id, time);
+
+CREATE INDEX idx_messages_room_time ON messages(room, time, id);
+CREATE INDEX idx_messages_room_seq ON messages(room, seq);
+CREATE INDEX idx_messages_parent_time ON messages(parent_id, time, id)
+  WHERE parent_id IS NOT NULL;
+CREATE INDEX idx_messages_quote ON messages(quote_id)
+  WHERE quote_id IS NOT NULL;
+CREATE INDEX idx_messages_search ON messages USING GIN (search_vector);
+CREATE INDEX idx_messages_expires_at ON messages(expires_at)
+  WHERE expires_at IS NOT NULL;
+
+-- What the foreign keys used to do when messages went away. Also called
+-- with the messages of a partition when it is detached.
+CREATE FUNCTION messages_removed(ids UUID[]) RETURNS void AS $fn$
+BEGIN
+  DELETE FROM messages WHERE parent_id = ANY(ids);
+  UPDATE messages SET quote_id = NULL WHERE quote_id = ANY(ids);
+  DELETE FROM message_edits WHERE message_id = ANY(ids);
+  DELETE FROM message_reactions WHERE message_id = ANY(ids);
+  DELETE FROM mentions WHERE message_id = ANY(ids);
+  DELETE FROM attachments WHERE message_id = ANY(ids);
+  DELETE FROM message_link_previews WHERE message_id = ANY(ids);
+  DELETE FROM pinned_messages WHERE message_id = ANY(ids);
+  DELETE FROM bookmarks WHERE message_id = ANY(ids);
+  DELETE FROM reminders WHERE message_id = ANY(ids);
+  DELETE FROM scheduled_messages WHERE parent_id = ANY(ids);
+END
+$fn$ LANGUAGE plpgsql;
+
+CREATE FUNCTION messages_after_delete() RETURNS trigger AS $fn$
+BEGIN
+  PERFORM messages_removed(ARRAY[OLD.id]);
+  RETURN NULL;
+END
+$fn$ LANGUAGE plpgsql;
+
+CREATE TRIGGER messages_after_delete
+  AFTER DELETE ON messages
+  FOR EACH ROW EXECUTE FUNCTION messages_after_delete();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE messages_plain (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  type VARCHAR(255) NOT NULL,
+  room VARCHAR(255) NOT NULL,
+  content TEXT,
+  sender VARCHAR(255) NOT NULL,
+  time TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  seq BIGINT NOT NULL,
+  edited_at TIMESTAMP WITH TIME ZONE,
+  deleted_at TIMESTAMP WITH TIME ZONE,
+  deleted_by VARCHAR(255),
+  parent_id UUID,
+  quote_id UUID,
+  reply_count INTEGER NOT NULL DEFAULT 0,
+  last_reply_at TIMESTAMP WITH TIME ZONE,
+  search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', coalesce(content, ''))) STORED,
+  has_attachments BOOLEAN NOT NULL DEFAULT FALSE,
+  format VARCHAR(16) NOT NULL DEFAULT 'plain' CHECK (format IN ('plain', 'markdown')),
+  content_html TEXT,
+  expires_at TIMESTAMP WITH TIME ZONE,
+  restored_at TIMESTAMP WITH TIME ZONE
+);
+
+INSERT INTO messages_plain (
+  id, type, room, content, sender, time, seq, edited_at, deleted_at, deleted_by,
+  parent_id, quote_id, reply_count, last_reply_at, has_attachments, format,
+  content_html, expires_at, restored_at
+)
+SELECT
+  id, type, room, content, sender, time, seq, edited_at, deleted_at, deleted_by,
+  parent_id, quote_id, reply_count, last_reply_at, has_attachments, format,
+  content_html, expires_at, restored_at
+FROM messages;
+
+DROP TABLE messages;
+DROP FUNCTION messages_after_delete();
+DROP FUNCTION messages_removed(UUID[]);
+ALTER TABLE messages_plain RENAME TO messages;
+ALTER INDEX messages_plain_pkey RENAME TO messages_pkey;
+
+ALTER TABLE messages
+  ADD CONSTRAINT messages_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES messages(id) ON DELETE CASCADE,
+  ADD CONSTRAINT messages_quote_id_fkey FOREIGN KEY (quote_id) REFERENCES messages(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_messages_room_time ON messages(room, time, id);
+CREATE INDEX idx_messages_room_seq ON messages(room, seq);
+CREATE INDEX idx_messages_parent_time ON messages(parent_id, time, id)
+  WHERE parent_id IS NOT NULL;
+CREATE INDEX idx_messages_search ON messages USING GIN (search_vector);
+CREATE INDEX idx_messages_expires_at ON messages(expires_at)
+  WHERE expires_at IS NOT NULL;
+
+ALTER TABLE message_edits ADD CONSTRAINT message_edits_message_id_fkey
+  FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE message_reactions ADD CONSTRAINT message_reactions_message_id_fkey
+  FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE mentions ADD CONSTRAINT mentions_message_id_fkey
+  FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE attachments ADD CONSTRAINT attachments_message_id_fkey
+  FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE message_link_previews ADD CONSTRAINT message_link_previews_message_id_fkey
+  FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE pinned_messages ADD CONSTRAINT pinned_messages_message_id_fkey
+  FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE bookmarks ADD CONSTRAINT bookmarks_message_id_fkey
+  FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE reminders ADD CONSTRAINT reminders_message_id_fkey
+  FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
+ALTER TABLE scheduled_messages ADD CONSTRAINT scheduled_messages_parent_id_fkey
+  FOREIGN KEY (parent_id) REFERENCES messages(id) ON DELETE CASCADE;
+-- +goose StatementEnd
@@ -0,0 +1,51 @@
+-- +goose Up
+-- +goose StatementBegin
+-- public rooms can be joined by anyone, private rooms need an invitation or
+-- a moderator's approval, invite rooms only let invited users in
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS visibility VARCHAR(16) NOT NULL DEFAULT 'public'
+  CHECK (visibility IN ('public', 'private', 'invite'));
+
+-- Shareable invite links. Anyone holding the code can join until the link
+-- expires or runs out of uses.
+CREATE TABLE IF NOT EXISTS room_invites (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  code VARCHAR(64) NOT NULL UNIQUE,
+  created_by VARCHAR(255) NOT NULL,
+  expires_at TIMESTAMP WITH TIME ZONE,
+  max_uses INTEGER CHECK (max_uses > 0),
+  uses INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_room_invites_room ON room_invites(room_id, created_at);
+
+-- Invitations addressed to one user, used up when they join
+CREATE TABLE IF NOT EXISTS room_invitations (
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  user_id VARCHAR(255) NOT NULL,
+  invited_by VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX idx_room_invitations_user ON room_invitations(user_id, created_at);
+
+-- Requests to join a private room, waiting for a moderator
+CREATE TABLE IF NOT EXISTS room_join_requests (
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  user_id VARCHAR(255) NOT NULL,
+  message TEXT,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (room_id, user_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE room_join_requests;
+DROP TABLE room_invitations;
+DROP TABLE room_invites;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS visibility;
+-- +goose StatementEnd
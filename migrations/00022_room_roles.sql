@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE room_memberships DROP CONSTRAINT IF EXISTS room_memberships_role_check;
+
+ALTER TABLE room_memberships
+  ADD CONSTRAINT room_memberships_role_check
+  CHECK (role IN ('owner', 'admin', 'moderator', 'member'));
+
+-- A room has at most one owner
+CREATE UNIQUE INDEX idx_room_memberships_owner ON room_memberships(room_id) WHERE role = 'owner';
+
+-- Rooms created before roles existed are owned by their longest-standing
+-- moderator, or failing that their longest-standing member
+UPDATE room_memberships rm
+SET role = 'owner'
+FROM (
+  SELECT DISTINCT ON (room_id) id
+  FROM room_memberships
+  ORDER BY room_id, role = 'moderator' DESC, joined_at, id
+) first_members
+WHERE rm.id = first_members.id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_room_memberships_owner;
+
+UPDATE room_memberships SET role = 'moderator' WHERE role IN ('owner', 'admin');
+
+ALTER TABLE room_memberships DROP CONSTRAINT IF EXISTS room_memberships_role_check;
+
+ALTER TABLE room_memberships
+  ADD CONSTRAINT room_memberships_role_check
+  CHECK (role IN ('member', 'moderator'));
+-- +goose StatementEnd
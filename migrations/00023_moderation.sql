@@ -0,0 +1,45 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Banned users cannot rejoin until the ban expires; NULL expires_at is forever
+CREATE TABLE IF NOT EXISTS room_bans (
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  user_id VARCHAR(255) NOT NULL,
+  banned_by VARCHAR(255) NOT NULL,
+  reason TEXT,
+  expires_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (room_id, user_id)
+);
+
+-- Muted users stay in the room but cannot post. Kept apart from the
+-- membership so leaving and rejoining does not lift a mute.
+CREATE TABLE IF NOT EXISTS room_mutes (
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  user_id VARCHAR(255) NOT NULL,
+  muted_by VARCHAR(255) NOT NULL,
+  reason TEXT,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (room_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS moderation_log (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  action VARCHAR(16) NOT NULL CHECK (action IN ('kick', 'ban', 'unban', 'mute', 'unmute')),
+  actor_id VARCHAR(255) NOT NULL,
+  target_id VARCHAR(255) NOT NULL,
+  reason TEXT,
+  expires_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_moderation_log_room_created ON moderation_log(room_id, created_at DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE moderation_log;
+DROP TABLE room_mutes;
+DROP TABLE room_bans;
+-- +goose StatementEnd
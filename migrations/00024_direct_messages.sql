@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE rooms
+  ADD COLUMN IF NOT EXISTS kind VARCHAR(16) NOT NULL DEFAULT 'channel'
+  CHECK (kind IN ('channel', 'dm', 'group'));
+
+-- The sorted participant IDs of a direct conversation, so opening one with
+-- the same people again finds the existing room
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS participants_key TEXT UNIQUE;
+
+-- Only channels need unique names; direct conversations are often unnamed
+ALTER TABLE rooms DROP CONSTRAINT IF EXISTS rooms_name_key;
+CREATE UNIQUE INDEX idx_rooms_channel_name ON rooms(name) WHERE kind = 'channel';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM rooms WHERE kind <> 'channel';
+
+DROP INDEX IF EXISTS idx_rooms_channel_name;
+ALTER TABLE rooms ADD CONSTRAINT rooms_name_key UNIQUE (name);
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS participants_key;
+ALTER TABLE rooms DROP COLUMN IF EXISTS kind;
+-- +goose StatementEnd
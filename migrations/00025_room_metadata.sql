@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE rooms
+  ADD COLUMN IF NOT EXISTS topic VARCHAR(250) NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS avatar_url TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS archived BOOLEAN NOT NULL DEFAULT FALSE,
+  -- Seconds a member has to wait between messages; 0 is off
+  ADD COLUMN IF NOT EXISTS slow_mode INTEGER NOT NULL DEFAULT 0 CHECK (slow_mode >= 0),
+  -- Most members the room takes; 0 is unlimited
+  ADD COLUMN IF NOT EXISTS member_limit INTEGER NOT NULL DEFAULT 0 CHECK (member_limit >= 0);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE rooms
+  DROP COLUMN IF EXISTS member_limit,
+  DROP COLUMN IF EXISTS slow_mode,
+  DROP COLUMN IF EXISTS archived,
+  DROP COLUMN IF EXISTS avatar_url,
+  DROP COLUMN IF EXISTS description,
+  DROP COLUMN IF EXISTS topic;
+-- +goose StatementEnd
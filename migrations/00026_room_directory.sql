@@ -0,0 +1,60 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Kept on the room so the directory can sort and show them without
+-- aggregating memberships or messages per request
+ALTER TABLE rooms
+  ADD COLUMN IF NOT EXISTS member_count INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS last_message_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE rooms r
+SET member_count = c.members
+FROM (SELECT room_id, COUNT(*) AS members FROM room_memberships GROUP BY room_id) c
+WHERE r.id = c.room_id;
+
+UPDATE rooms r
+SET last_message_at = m.newest
+FROM (SELECT room, MAX(time) AS newest FROM messages WHERE parent_id IS NULL GROUP BY room) m
+WHERE r.id::text = m.room;
+
+CREATE OR REPLACE FUNCTION room_member_count() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    UPDATE rooms SET member_count = member_count + 1 WHERE id = NEW.room_id;
+  ELSE
+    UPDATE rooms SET member_count = member_count - 1 WHERE id = OLD.room_id;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER room_memberships_count
+  AFTER INSERT OR DELETE ON room_memberships
+  FOR EACH ROW EXECUTE FUNCTION room_member_count();
+
+-- Substring search over names and topics
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_rooms_directory_search ON rooms
+  USING GIN ((name || ' ' || topic) gin_trgm_ops)
+  WHERE kind = 'channel' AND visibility = 'public' AND NOT archived;
+
+CREATE INDEX idx_rooms_directory_members ON rooms(member_count DESC, id DESC)
+  WHERE kind = 'channel' AND visibility = 'public' AND NOT archived;
+
+CREATE INDEX idx_rooms_directory_activity ON rooms(COALESCE(last_message_at, created_at) DESC, id DESC)
+  WHERE kind = 'channel' AND visibility = 'public' AND NOT archived;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_rooms_directory_activity;
+DROP INDEX IF EXISTS idx_rooms_directory_members;
+DROP INDEX IF EXISTS idx_rooms_directory_search;
+
+DROP TRIGGER IF EXISTS room_memberships_count ON room_memberships;
+DROP FUNCTION IF EXISTS room_member_count();
+
+ALTER TABLE rooms
+  DROP COLUMN IF EXISTS last_message_at,
+  DROP COLUMN IF EXISTS member_count;
+-- +goose StatementEnd
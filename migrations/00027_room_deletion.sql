@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A deleted room loses its members straight away but lingers until the
+-- retention worker has removed its messages in batches; then it goes for good
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- The name of a deleted channel is free again at once
+DROP INDEX IF EXISTS idx_rooms_channel_name;
+CREATE UNIQUE INDEX idx_rooms_channel_name ON rooms(name)
+  WHERE kind = 'channel' AND deleted_at IS NULL;
+
+CREATE INDEX idx_rooms_deleted ON rooms(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Messages of rooms deleted before rooms could be deleted properly were left
+-- behind. The delete trigger takes their replies, reactions and the rest
+-- with them. Files of the orphaned archives stay in the blob store.
+DELETE FROM messages m WHERE NOT EXISTS (SELECT 1 FROM rooms r WHERE r.id::text = m.room);
+DELETE FROM message_archives a WHERE NOT EXISTS (SELECT 1 FROM rooms r WHERE r.id::text = a.room_id);
+
+ALTER TABLE messages ALTER COLUMN room TYPE UUID USING room::uuid;
+ALTER TABLE messages ADD CONSTRAINT messages_room_fkey
+  FOREIGN KEY (room) REFERENCES rooms(id) ON DELETE CASCADE;
+
+ALTER TABLE message_archives ALTER COLUMN room_id TYPE UUID USING room_id::uuid;
+ALTER TABLE message_archives ADD CONSTRAINT message_archives_room_id_fkey
+  FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Rooms waiting to be purged would clash with the old name index; their
+-- messages go with them
+DELETE FROM rooms WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE message_archives DROP CONSTRAINT IF EXISTS message_archives_room_id_fkey;
+ALTER TABLE message_archives ALTER COLUMN room_id TYPE VARCHAR(255);
+
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_room_fkey;
+ALTER TABLE messages ALTER COLUMN room TYPE VARCHAR(255);
+
+DROP INDEX IF EXISTS idx_rooms_deleted;
+DROP INDEX IF EXISTS idx_rooms_channel_name;
+CREATE UNIQUE INDEX idx_rooms_channel_name ON rooms(name) WHERE kind = 'channel';
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS deleted_at;
+-- +goose StatementEnd
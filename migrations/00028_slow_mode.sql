@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- When a member last posted, for rooms in slow mode
+ALTER TABLE room_memberships ADD COLUMN IF NOT EXISTS last_posted_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE room_memberships DROP COLUMN IF EXISTS last_posted_at;
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Per-room rules for the content filters. Words and patterns are matched
+-- against what a message says; domains against the links in it. When rules
+-- to allow domains exist, links anywhere else break them.
+CREATE TABLE IF NOT EXISTS room_filter_rules (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  kind VARCHAR(16) NOT NULL CHECK (kind IN ('word', 'pattern', 'allow_domain', 'deny_domain')),
+  value VARCHAR(255) NOT NULL,
+  action VARCHAR(16) NOT NULL CHECK (action IN ('reject', 'mask', 'hold')),
+  created_by VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (room_id, kind, value)
+);
+
+-- Messages a filter held back until a moderator lets them through or turns
+-- them down. The message is kept as it would have been sent.
+CREATE TABLE IF NOT EXISTS held_messages (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  sender VARCHAR(255) NOT NULL,
+  message JSONB NOT NULL,
+  filter VARCHAR(32) NOT NULL,
+  reason TEXT,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  reviewed_by VARCHAR(255),
+  reviewed_at TIMESTAMP WITH TIME ZONE,
+  approved BOOLEAN
+);
+
+CREATE INDEX idx_held_messages_pending ON held_messages(room_id, created_at)
+  WHERE reviewed_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE held_messages;
+DROP TABLE room_filter_rules;
+-- +goose StatementEnd
@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A member's report of a message or of another member. Reports of a message
+-- keep a copy of what it said, so the evidence outlives edits, deletion and
+-- retention. Messages are partitioned, so message_id has no foreign key.
+CREATE TABLE IF NOT EXISTS reports (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+  reporter_id VARCHAR(255) NOT NULL,
+  target_id VARCHAR(255) NOT NULL,
+  message_id UUID,
+  message_content TEXT,
+  reason TEXT NOT NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'claimed', 'resolved')),
+  claimed_by VARCHAR(255),
+  claimed_at TIMESTAMP WITH TIME ZONE,
+  resolution VARCHAR(16) CHECK (resolution IN ('dismiss', 'delete_message', 'mute', 'ban')),
+  resolved_by VARCHAR(255),
+  resolved_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A member can only have one unresolved report of the same thing
+CREATE UNIQUE INDEX idx_reports_unresolved ON reports(room_id, reporter_id, target_id, COALESCE(message_id::text, ''))
+  WHERE status <> 'resolved';
+
+CREATE INDEX idx_reports_room_status ON reports(room_id, status, created_at);
+
+-- Everything that happened to a report, in order: who filed, claimed,
+-- released and resolved it, and how
+CREATE TABLE IF NOT EXISTS report_events (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  report_id UUID NOT NULL REFERENCES reports(id) ON DELETE CASCADE,
+  action VARCHAR(16) NOT NULL CHECK (action IN ('created', 'claimed', 'released', 'resolved')),
+  actor_id VARCHAR(255) NOT NULL,
+  resolution VARCHAR(16),
+  note TEXT,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_report_events_report ON report_events(report_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE report_events;
+DROP TABLE reports;
+-- +goose StatementEnd
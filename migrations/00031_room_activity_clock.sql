@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Activity used to come from the time a client put on its message, so a
+-- room could be dated into the future and stay on top of the directory
+UPDATE rooms SET last_message_at = CURRENT_TIMESTAMP
+WHERE last_message_at > CURRENT_TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- The old times were wrong; there is nothing to put back
+SELECT 1;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Deleting a message used to leave its pin behind, hidden but still taking
+-- one of the room's pin slots
+DELETE FROM pinned_messages p
+USING messages m
+WHERE m.id = p.message_id AND m.deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- The pins pointed at deleted messages; there is nothing to put back
+SELECT 1;
+-- +goose StatementEnd